@@ -133,7 +133,7 @@ func TestPrintTableStructure(t *testing.T) {
 			}()
 
 			// Capture output (we're just testing it doesn't crash)
-			printTable(tc.rows)
+			printTable(tc.rows, nil)
 		})
 	}
 }
@@ -179,11 +179,29 @@ text`},
 				}
 			}()
 
-			printCSV(tc.rows)
+			printCSV(tc.rows, nil)
 		})
 	}
 }
 
+func TestResultColumnsOrder(t *testing.T) {
+	result := map[string]any{
+		"columns": []any{
+			map[string]any{"name": "zeta", "type": "integer", "nullable": false},
+			map[string]any{"name": "alpha", "type": "text", "nullable": true},
+		},
+	}
+	got := columnOrder(resultColumns(result), []map[string]any{{"alpha": "a", "zeta": 1}})
+	if len(got) != 2 || got[0] != "zeta" || got[1] != "alpha" {
+		t.Fatalf("expected select-list order, got %v", got)
+	}
+
+	got = columnOrder(resultColumns(map[string]any{}), []map[string]any{{"b": 1}, {"a": 2}})
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("expected sorted keys without metadata, got %v", got)
+	}
+}
+
 func TestAsksFlag(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -13,6 +13,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/tabwriter"
 	"time"
 
@@ -31,6 +32,58 @@ type asksFlag []string
 func (a *asksFlag) String() string     { return strings.Join(*a, "; ") }
 func (a *asksFlag) Set(v string) error { *a = append(*a, v); return nil }
 
+// progressLine renders the server's progress notifications on a single
+// stderr line, so stdout stays clean for json/csv output.
+type progressLine struct {
+	mu      sync.Mutex
+	enabled bool
+	seq     int
+	current string // token of the call in flight; late notifications for older calls are dropped
+	shown   bool
+}
+
+var progress = &progressLine{}
+
+// meta returns request metadata carrying a fresh progress token, or nil when disabled.
+func (p *progressLine) meta() mcp.Meta {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.enabled {
+		return nil
+	}
+	p.seq++
+	p.current = fmt.Sprintf("pgmcp-client-%d", p.seq)
+	return mcp.Meta{"progressToken": p.current}
+}
+
+func (p *progressLine) handle(_ context.Context, req *mcp.ProgressNotificationClientRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current == "" || req.Params.ProgressToken != p.current {
+		return
+	}
+	line := req.Params.Message
+	if line == "" {
+		line = fmt.Sprintf("%.0f", req.Params.Progress)
+	}
+	if req.Params.Total > 0 {
+		line += fmt.Sprintf(" (up to %.0f)", req.Params.Total)
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K%s", line)
+	p.shown = true
+}
+
+// done ends the progress line before results are printed.
+func (p *progressLine) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = ""
+	if p.shown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.shown = false
+	}
+}
+
 func main() {
 	url := getenv("PGMCP_SERVER_URL", "http://127.0.0.1:8080/mcp")
 	bearer := os.Getenv("PGMCP_AUTH_BEARER")
@@ -44,7 +97,12 @@ func main() {
 	var asks asksFlag
 	flag.Var(&asks, "ask", "Plain-English question to run (repeatable)")
 	search := flag.String("search", "", "Optional free-text search string")
+	searchMode := flag.String("search-mode", "", "Search mode: text (substring), fulltext (ranked) or fuzzy (misspellings)")
+	searchMatch := flag.String("search-match", "", "Text mode match: substring, prefix, exact, case_sensitive or regex")
+	searchTables := flag.String("search-tables", "", "Comma-separated tables to search (globs allowed)")
+	showProgress := flag.Bool("progress", true, "Show streaming progress on stderr")
 	flag.Parse()
+	progress.enabled = *showProgress
 
 	if *versionFlag {
 		fmt.Printf("pgmcp-client %s\n", version)
@@ -67,7 +125,9 @@ func main() {
 		HTTPClient: httpClient,
 	}
 
-	client := mcp.NewClient(&mcp.Implementation{Name: "pgmcp-client", Version: "0.5.0"}, nil)
+	client := mcp.NewClient(&mcp.Implementation{Name: "pgmcp-client", Version: "0.5.0"}, &mcp.ClientOptions{
+		ProgressNotificationHandler: progress.handle,
+	})
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
@@ -95,7 +155,7 @@ func main() {
 		if *verbose {
 			fmt.Printf("Searching for: %s\n", s)
 		}
-		runSearch(ctx, session, s, *format, *verbose)
+		runSearch(ctx, session, s, *searchMode, *searchMatch, *searchTables, *format, *verbose)
 	}
 }
 
@@ -106,7 +166,8 @@ func runAsk(ctx context.Context, session *mcp.ClientSession, question, format st
 		fmt.Printf("Streaming query (max %d rows)...\n", maxRows)
 	}
 
-	res, err := session.CallTool(ctx, &mcp.CallToolParams{Name: "ask", Arguments: args})
+	res, err := session.CallTool(ctx, &mcp.CallToolParams{Name: "ask", Arguments: args, Meta: progress.meta()})
+	progress.done()
 	if err != nil {
 		log.Fatalf("ask failed: %v", err)
 	}
@@ -132,13 +193,29 @@ func runAsk(ctx context.Context, session *mcp.ClientSession, question, format st
 	printContent(res.Content)
 }
 
-func runSearch(ctx context.Context, session *mcp.ClientSession, q, format string, verbose bool) {
+func runSearch(ctx context.Context, session *mcp.ClientSession, q, mode, match, tables, format string, verbose bool) {
 	args := map[string]any{"q": q, "limit": 50}
+	if mode != "" {
+		args["mode"] = mode
+	}
+	if match != "" {
+		args["match"] = match
+	}
+	var list []string
+	for _, t := range strings.Split(tables, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			list = append(list, t)
+		}
+	}
+	if len(list) > 0 {
+		args["tables"] = list
+	}
 	call(ctx, session, "search", args, format, verbose)
 }
 
 func call(ctx context.Context, session *mcp.ClientSession, tool string, args map[string]any, format string, verbose bool) {
-	res, err := session.CallTool(ctx, &mcp.CallToolParams{Name: tool, Arguments: args})
+	res, err := session.CallTool(ctx, &mcp.CallToolParams{Name: tool, Arguments: args, Meta: progress.meta()})
+	progress.done()
 	if err != nil {
 		log.Fatalf("%s failed: %v", tool, err)
 	}
@@ -194,9 +271,9 @@ func printFormattedResult(result map[string]any, format string, verbose bool) {
 
 	switch format {
 	case "table":
-		printTable(rows)
+		printTable(rows, resultColumns(result))
 	case "csv":
-		printCSV(rows)
+		printCSV(rows, resultColumns(result))
 	case "json":
 		printJSON(result)
 	default:
@@ -204,7 +281,42 @@ func printFormattedResult(result map[string]any, format string, verbose bool) {
 	}
 }
 
-func printTable(rows []any) {
+// resultColumns returns the column names from the result's "columns"
+// metadata, in select-list order, or nil if the tool did not send any.
+func resultColumns(result map[string]any) []string {
+	cols, _ := result["columns"].([]any)
+	var names []string
+	for _, c := range cols {
+		if m, ok := c.(map[string]any); ok {
+			if name, ok := m["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// columnOrder uses order when given, otherwise every key in records sorted
+// for consistent output.
+func columnOrder(order []string, records []map[string]any) []string {
+	if len(order) > 0 {
+		return order
+	}
+	columnSet := make(map[string]bool)
+	for _, record := range records {
+		for key := range record {
+			columnSet[key] = true
+		}
+	}
+	var columns []string
+	for col := range columnSet {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func printTable(rows []any, order []string) {
 	if len(rows) == 0 {
 		return
 	}
@@ -221,20 +333,7 @@ func printTable(rows []any) {
 		return
 	}
 
-	// Get all column names
-	columnSet := make(map[string]bool)
-	for _, record := range records {
-		for key := range record {
-			columnSet[key] = true
-		}
-	}
-
-	// Sort column names for consistent output
-	var columns []string
-	for col := range columnSet {
-		columns = append(columns, col)
-	}
-	sort.Strings(columns)
+	columns := columnOrder(order, records)
 
 	// Create table writer
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
@@ -275,7 +374,7 @@ func printTable(rows []any) {
 	}
 }
 
-func printCSV(rows []any) {
+func printCSV(rows []any, order []string) {
 	if len(rows) == 0 {
 		return
 	}
@@ -292,20 +391,7 @@ func printCSV(rows []any) {
 		return
 	}
 
-	// Get all column names
-	columnSet := make(map[string]bool)
-	for _, record := range records {
-		for key := range record {
-			columnSet[key] = true
-		}
-	}
-
-	// Sort column names for consistent output
-	var columns []string
-	for col := range columnSet {
-		columns = append(columns, col)
-	}
-	sort.Strings(columns)
+	columns := columnOrder(order, records)
 
 	// Create CSV writer
 	writer := csv.NewWriter(os.Stdout)
@@ -9,6 +9,7 @@ require (
 	github.com/modelcontextprotocol/go-sdk v0.6.0
 	github.com/openai/openai-go/v2 v2.4.3
 	github.com/rs/zerolog v1.33.0
+	github.com/yosida95/uritemplate/v3 v3.0.2
 )
 
 require (
@@ -22,7 +23,6 @@ require (
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/tidwall/sjson v1.2.5 // indirect
-	github.com/yosida95/uritemplate/v3 v3.0.2 // indirect
 	golang.org/x/crypto v0.32.0 // indirect
 	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
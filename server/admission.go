@@ -0,0 +1,249 @@
+// server/admission.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	defaultAdmitPerClient = 4
+	defaultAdmitQueue     = 32
+	defaultAdmitWait      = 5 * time.Second
+)
+
+// busyError rejects a request that could not be admitted. The message tells
+// the client to retry, since nothing is wrong with the request itself.
+type busyError struct {
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e *busyError) Error() string {
+	return fmt.Sprintf("server busy: %s; retry in %s", e.reason, e.retryAfter.Round(time.Second))
+}
+
+// admission limits how many queries run at once, overall and per client.
+// Requests over the overall limit wait in a bounded queue for up to wait;
+// requests over the per-client limit or finding the queue full are
+// rejected straight away so they never tie up a pool connection. A slot
+// stands for a pool connection: result handles keep the slot of the request
+// that opened them, and running jobs and extra search workers take slots of
+// their own, so they only hold back other queries while they use the pool.
+type admission struct {
+	slots     chan struct{} // one token per connection in use
+	perClient int
+	queue     int
+	wait      time.Duration
+
+	mu      sync.Mutex
+	clients map[string]int // running + queued requests per identity
+	waiting int
+
+	admitted atomic.Int64
+	queued   atomic.Int64 // admitted after waiting
+	rejected atomic.Int64
+}
+
+func newAdmission(maxConcurrent, perClient, queue int, wait time.Duration) *admission {
+	if perClient == 0 {
+		perClient = defaultAdmitPerClient
+	}
+	if queue == 0 {
+		queue = defaultAdmitQueue
+	}
+	if wait == 0 {
+		wait = defaultAdmitWait
+	}
+	return &admission{
+		slots:     make(chan struct{}, maxConcurrent),
+		perClient: perClient,
+		queue:     queue,
+		wait:      wait,
+		clients:   map[string]int{},
+	}
+}
+
+// acquire admits a request from identity, waiting for a free slot if
+// necessary. The returned release must be called when the query is done.
+func (a *admission) acquire(ctx context.Context, identity string) (func(), error) {
+	if err := a.enter(ctx, identity); err != nil {
+		return nil, err
+	}
+	return func() {
+		a.free()
+		a.leave(identity)
+	}, nil
+}
+
+// enter takes a slot for identity as acquire does, leaving it to the caller
+// to free the slot and leave.
+func (a *admission) enter(ctx context.Context, identity string) error {
+	a.mu.Lock()
+	if a.clients[identity] >= a.perClient {
+		a.mu.Unlock()
+		a.rejected.Add(1)
+		return &busyError{fmt.Sprintf("too many concurrent requests from this client (max %d)", a.perClient), time.Second}
+	}
+	a.clients[identity]++
+	a.mu.Unlock()
+
+	select {
+	case a.slots <- struct{}{}:
+		a.admitted.Add(1)
+		return nil
+	default:
+	}
+
+	a.mu.Lock()
+	if a.waiting >= a.queue {
+		a.mu.Unlock()
+		a.leave(identity)
+		a.rejected.Add(1)
+		return &busyError{fmt.Sprintf("request queue full (%d waiting)", a.queue), a.wait}
+	}
+	a.waiting++
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.waiting--
+		a.mu.Unlock()
+	}()
+
+	t := time.NewTimer(a.wait)
+	defer t.Stop()
+	select {
+	case a.slots <- struct{}{}:
+		a.admitted.Add(1)
+		a.queued.Add(1)
+		return nil
+	case <-t.C:
+		a.leave(identity)
+		a.rejected.Add(1)
+		return &busyError{fmt.Sprintf("no capacity after waiting %s", a.wait), a.wait}
+	case <-ctx.Done():
+		a.leave(identity)
+		return ctx.Err()
+	}
+}
+
+func (a *admission) free() {
+	<-a.slots
+}
+
+// hold takes a slot for work done outside any request, such as a job,
+// waiting as long as ctx allows. It counts against no client.
+func (a *admission) hold(ctx context.Context) (func(), error) {
+	select {
+	case a.slots <- struct{}{}:
+		return a.free, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// tryHold takes a slot only if one is free now, for connections a request
+// can do without, such as more search workers.
+func (a *admission) tryHold() (func(), bool) {
+	select {
+	case a.slots <- struct{}{}:
+		return a.free, true
+	default:
+		return nil, false
+	}
+}
+
+func (a *admission) leave(identity string) {
+	a.mu.Lock()
+	if a.clients[identity]--; a.clients[identity] <= 0 {
+		delete(a.clients, identity)
+	}
+	a.mu.Unlock()
+}
+
+// admissionStats is the admission part of /stats.
+type admissionStats struct {
+	Running       int   `json:"running"` // slots in use, including result handles, jobs and search workers
+	Waiting       int   `json:"waiting"`
+	Clients       int   `json:"clients"`
+	Admitted      int64 `json:"admitted"`
+	Queued        int64 `json:"queued"` // admitted after waiting
+	Rejected      int64 `json:"rejected"`
+	MaxConcurrent int   `json:"max_concurrent"`
+	MaxPerClient  int   `json:"max_per_client"`
+	MaxQueue      int   `json:"max_queue"`
+	MaxWaitMS     int64 `json:"max_wait_ms"`
+}
+
+func (a *admission) stats() admissionStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return admissionStats{
+		Running:       len(a.slots),
+		Waiting:       a.waiting,
+		Clients:       len(a.clients),
+		Admitted:      a.admitted.Load(),
+		Queued:        a.queued.Load(),
+		Rejected:      a.rejected.Load(),
+		MaxConcurrent: cap(a.slots),
+		MaxPerClient:  a.perClient,
+		MaxQueue:      a.queue,
+		MaxWaitMS:     a.wait.Milliseconds(),
+	}
+}
+
+// admit runs admission for a tool call. Servers built without an admission
+// controller (tests) admit everything.
+func (s *Server) admit(ctx context.Context, req *mcp.CallToolRequest) (func(), error) {
+	if s.admission == nil {
+		return func() {}, nil
+	}
+	return s.admission.acquire(ctx, requestIdentity(req))
+}
+
+// admitKeep is admit for requests that may open a result handle. keep hands
+// the request's slot to the handle, which holds its connection after the
+// request ends: release then only ends the request, and the function keep
+// returns frees the slot once the handle closes.
+func (s *Server) admitKeep(ctx context.Context, req *mcp.CallToolRequest) (release func(), keep func() func(), err error) {
+	if s.admission == nil {
+		return func() {}, func() func() { return func() {} }, nil
+	}
+	a, identity := s.admission, requestIdentity(req)
+	if err := a.enter(ctx, identity); err != nil {
+		return nil, nil, err
+	}
+	var kept atomic.Bool
+	release = func() {
+		if !kept.Load() {
+			a.free()
+		}
+		a.leave(identity)
+	}
+	keep = func() func() {
+		kept.Store(true)
+		return a.free
+	}
+	return release, keep, nil
+}
+
+// hold takes an admission slot for background work, see admission.hold.
+func (s *Server) hold(ctx context.Context) (func(), error) {
+	if s.admission == nil {
+		return func() {}, nil
+	}
+	return s.admission.hold(ctx)
+}
+
+// tryHold takes an admission slot if one is free, see admission.tryHold.
+func (s *Server) tryHold() (func(), bool) {
+	if s.admission == nil {
+		return func() {}, true
+	}
+	return s.admission.tryHold()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdmissionPerClientLimit(t *testing.T) {
+	a := newAdmission(4, 2, 4, time.Second)
+	ctx := context.Background()
+	r1, err := a.acquire(ctx, "session:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := a.acquire(ctx, "session:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var busy *busyError
+	if _, err := a.acquire(ctx, "session:a"); !errors.As(err, &busy) {
+		t.Fatalf("third request from the same client should be rejected, got %v", err)
+	}
+	if r, err := a.acquire(ctx, "session:b"); err != nil {
+		t.Fatalf("other clients should still be admitted: %v", err)
+	} else {
+		r()
+	}
+	r1()
+	r2()
+	if st := a.stats(); st.Running != 0 || st.Clients != 0 || st.Admitted != 3 || st.Rejected != 1 {
+		t.Fatalf("unexpected stats after release: %+v", st)
+	}
+}
+
+func TestAdmissionQueue(t *testing.T) {
+	a := newAdmission(1, 4, 1, 2*time.Second)
+	ctx := context.Background()
+	hold, err := a.acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := make(chan error, 1)
+	go func() {
+		release, err := a.acquire(ctx, "b")
+		if err == nil {
+			release()
+		}
+		admitted <- err
+	}()
+	waitFor(t, func() bool { return a.stats().Waiting == 1 })
+
+	var busy *busyError
+	if _, err := a.acquire(ctx, "c"); !errors.As(err, &busy) {
+		t.Fatalf("full queue should reject immediately, got %v", err)
+	}
+
+	hold()
+	if err := <-admitted; err != nil {
+		t.Fatalf("queued request should be admitted once a slot frees: %v", err)
+	}
+	if st := a.stats(); st.Queued != 1 || st.Waiting != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestAdmissionWaitTimeout(t *testing.T) {
+	a := newAdmission(1, 4, 4, 50*time.Millisecond)
+	hold, err := a.acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hold()
+
+	var busy *busyError
+	if _, err := a.acquire(context.Background(), "b"); !errors.As(err, &busy) {
+		t.Fatalf("expected busy after waiting, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.acquire(ctx, "b"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller should stop waiting, got %v", err)
+	}
+	if st := a.stats(); st.Clients != 1 || st.Waiting != 0 {
+		t.Fatalf("rejected callers should not stay registered: %+v", st)
+	}
+}
+
+func TestAdmitMaxConcurrentDefault(t *testing.T) {
+	c := Config{}
+	if got := c.admitMaxConcurrent(); got != defaultMaxConns {
+		t.Fatalf("default concurrency should be the whole pool, got %d", got)
+	}
+	c = Config{DBMaxConns: 20, ResultMax: 5, JobWorkers: 3, AdmitMaxConcurrent: 0}
+	if got := c.admitMaxConcurrent(); got != 20 {
+		t.Fatalf("result handles and jobs should not be reserved up front, got %d", got)
+	}
+	c.AdmitMaxConcurrent = 3
+	if got := c.admitMaxConcurrent(); got != 3 {
+		t.Fatalf("explicit setting should win, got %d", got)
+	}
+}
+
+func TestAdmitKeepAndHold(t *testing.T) {
+	srv := &Server{admission: newAdmission(2, 4, 4, 50*time.Millisecond)}
+	ctx := context.Background()
+
+	// A request that opens no handle gives its slot back
+	release, _, err := srv.admitKeep(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if st := srv.admission.stats(); st.Running != 0 || st.Clients != 0 {
+		t.Fatalf("unexpected stats after release: %+v", st)
+	}
+
+	// One that opens a handle leaves its slot with the handle
+	release, keep, err := srv.admitKeep(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	free := keep()
+	release()
+	if st := srv.admission.stats(); st.Running != 1 || st.Clients != 0 {
+		t.Fatalf("handle should hold the slot once the request ends: %+v", st)
+	}
+
+	// A job takes the other slot, leaving nothing for a new request
+	done, err := srv.hold(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var busy *busyError
+	if _, err := srv.admit(ctx, nil); !errors.As(err, &busy) {
+		t.Fatalf("expected busy with the pool held by a handle and a job, got %v", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := srv.hold(cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("hold should give up with its context, got %v", err)
+	}
+
+	free()
+	done()
+	if r, err := srv.admit(ctx, nil); err != nil {
+		t.Fatalf("slots should be free again: %v", err)
+	} else {
+		r()
+	}
+}
+
+func TestAdmissionTryHold(t *testing.T) {
+	a := newAdmission(2, 4, 4, time.Second)
+	release, err := a.acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free, ok := a.tryHold()
+	if !ok {
+		t.Fatal("a free slot should be taken")
+	}
+	if _, ok := a.tryHold(); ok {
+		t.Fatal("tryHold should not take more slots than there are")
+	}
+	free()
+	release()
+	if st := a.stats(); st.Running != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,91 @@
+// server/cancel.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// requestIDHeader tags each HTTP request so tool handlers can find the
+// request they arrived on. It is set by trackRequests and overwrites any
+// client-supplied value.
+const requestIDHeader = "X-Pgmcp-Request-Id"
+
+// cancelDeadlineDelay bounds how long a cancelled query may keep the
+// connection busy before pgx gives up on the server and closes it.
+const cancelDeadlineDelay = 5 * time.Second
+
+// cancelRequestHandler makes context cancellation send a PostgreSQL cancel
+// request, so the backend stops the query. pgx's default only sets a
+// socket deadline, which drops the connection but leaves the query running.
+func cancelRequestHandler(pgConn *pgconn.PgConn) ctxwatch.Handler {
+	return &pgconn.CancelRequestContextWatcherHandler{Conn: pgConn, DeadlineDelay: cancelDeadlineDelay}
+}
+
+// httpRequests maps in-flight HTTP request IDs to their contexts.
+//
+// The SDK cancels a tool call's context on notifications/cancelled, but the
+// context is derived from the MCP session rather than from the HTTP request
+// that carried the call, so a client that simply disconnects is not noticed.
+type httpRequests struct {
+	m sync.Map // request ID -> context.Context
+}
+
+// trackRequests registers every request's context for the duration of next.ServeHTTP.
+func (h *httpRequests) trackRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 12)
+		if _, err := rand.Read(b); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id := hex.EncodeToString(b)
+		r.Header.Set(requestIDHeader, id)
+		h.m.Store(id, r.Context())
+		defer h.m.Delete(id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withRequestCancel returns a context that is also cancelled when the HTTP
+// request carrying req goes away. The returned stop func must be called.
+func (h *httpRequests) withRequestCancel(ctx context.Context, req *mcp.CallToolRequest) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if h == nil || req == nil || req.Extra == nil || req.Extra.Header == nil {
+		return ctx, cancel
+	}
+	v, ok := h.m.Load(req.Extra.Header.Get(requestIDHeader))
+	if !ok {
+		return ctx, cancel
+	}
+	stop := context.AfterFunc(v.(context.Context), cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// isCancelled reports whether err came from the caller going away, as
+// opposed to a timeout or a query error.
+func isCancelled(ctx context.Context, err error) bool {
+	return errors.Is(ctx.Err(), context.Canceled) || errors.Is(err, context.Canceled)
+}
+
+// auditFailure records a failed tool call, as "<tool>_cancelled" when the
+// client cancelled or disconnected and as event otherwise.
+func auditFailure(ctx context.Context, tool, event, user, query string, err error) {
+	if isCancelled(ctx, err) {
+		auditLog(tool+"_cancelled", user, query, err.Error(), false)
+		return
+	}
+	auditLog(event, user, query, err.Error(), false)
+}
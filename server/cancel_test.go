@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestWithRequestCancel(t *testing.T) {
+	var h httpRequests
+	linked := make(chan context.Context, 1)
+	release := make(chan struct{})
+	handler := h.trackRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &mcp.CallToolRequest{Extra: &mcp.RequestExtra{Header: r.Header}}
+		ctx, stop := h.withRequestCancel(context.Background(), req)
+		defer stop()
+		linked <- ctx
+		<-release
+	}))
+
+	reqCtx, disconnect := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodPost, "/mcp", nil).WithContext(reqCtx)
+	r.Header.Set(requestIDHeader, "spoofed")
+	served := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		close(served)
+	}()
+
+	ctx := <-linked
+	if r.Header.Get(requestIDHeader) == "spoofed" {
+		t.Fatalf("client-supplied request ID should be replaced")
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("linked context cancelled before disconnect: %v", ctx.Err())
+	}
+	disconnect()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("disconnect did not cancel the tool context")
+	}
+	close(release)
+	<-served
+
+	n := 0
+	h.m.Range(func(_, _ any) bool { n++; return true })
+	if n != 0 {
+		t.Fatalf("finished requests should be forgotten, %d left", n)
+	}
+}
+
+func TestWithRequestCancelUntracked(t *testing.T) {
+	var nilRequests *httpRequests
+	ctx, stop := nilRequests.withRequestCancel(context.Background(), nil)
+	if ctx.Err() != nil {
+		t.Fatalf("unexpected cancellation: %v", ctx.Err())
+	}
+	stop()
+	if ctx.Err() == nil {
+		t.Fatalf("stop should cancel the derived context")
+	}
+
+	// stdio and in-memory transports carry no header
+	var h httpRequests
+	ctx, stop = h.withRequestCancel(context.Background(), &mcp.CallToolRequest{})
+	defer stop()
+	if ctx.Err() != nil {
+		t.Fatalf("unexpected cancellation: %v", ctx.Err())
+	}
+}
+
+func TestIsCancelled(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel2 := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel2()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"query error", context.Background(), errors.New("syntax error"), false},
+		{"caller cancelled", cancelled, errors.New("conn closed"), true},
+		{"wrapped cancel", context.Background(), errors.Join(errors.New("fetch"), context.Canceled), true},
+		{"timeout", expired, context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isCancelled(tt.ctx, tt.err); got != tt.want {
+				t.Errorf("isCancelled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
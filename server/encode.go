@@ -0,0 +1,310 @@
+// server/encode.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// columnInfo describes one result column, in select-list order. Rows are
+// maps, which JSON encodes in key order, so this is the only record of the
+// query's column order.
+type columnInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // as format_type prints it, e.g. "numeric(10,2)"
+	// Nullable is false only for columns taken straight from a NOT NULL
+	// table column. An outer join can still produce NULLs there.
+	Nullable bool `json:"nullable"`
+}
+
+// scanRows reads the remaining rows into JSON-ready maps keyed by column
+// name and closes rows. The field descriptions are returned for
+// describeColumns.
+func scanRows(rows pgx.Rows, capHint int) ([]map[string]any, []pgconn.FieldDescription, error) {
+	defer rows.Close()
+	m := rows.Conn().TypeMap()
+	flds := append([]pgconn.FieldDescription(nil), rows.FieldDescriptions()...)
+	out := make([]map[string]any, 0, capHint)
+	for rows.Next() {
+		vals, err := rows.Values()
+		if err != nil {
+			return nil, nil, err
+		}
+		out = append(out, encodeRow(m, flds, vals, rows.RawValues()))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return out, flds, nil
+}
+
+// encodeRow builds the JSON form of one row. raw must be the row's
+// undecoded values, which are only valid until the next rows.Next.
+func encodeRow(m *pgtype.Map, flds []pgconn.FieldDescription, vals []any, raw [][]byte) map[string]any {
+	row := make(map[string]any, len(flds))
+	for i, f := range flds {
+		if vals[i] != nil && (f.DataTypeOID == pgtype.JSONOID || f.DataTypeOID == pgtype.JSONBOID) && i < len(raw) {
+			if v, ok := decodeJSON(raw[i], f); ok {
+				row[f.Name] = v
+				continue
+			}
+		}
+		row[f.Name] = encodeValue(m, f.DataTypeOID, vals[i])
+	}
+	return row
+}
+
+// decodeJSON re-reads a json/jsonb value keeping numbers exact; pgx decodes
+// them into float64.
+func decodeJSON(raw []byte, f pgconn.FieldDescription) (any, bool) {
+	if f.DataTypeOID == pgtype.JSONBOID && f.Format == pgtype.BinaryFormatCode {
+		if len(raw) == 0 || raw[0] != 1 {
+			return nil, false
+		}
+		raw = raw[1:] // jsonb binary format version
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// encodeValue maps a value decoded by pgx to a stable JSON representation:
+//
+//   - numeric as a string, so no precision is lost
+//   - NaN and ±Infinity (float and numeric) as "NaN", "Infinity", "-Infinity"
+//   - uuid in canonical 8-4-4-4-12 form
+//   - date as YYYY-MM-DD, timestamp without a zone, timestamptz as RFC 3339,
+//     and infinite dates/timestamps as "infinity" / "-infinity"
+//   - interval as an ISO 8601 duration, like IntervalStyle iso_8601
+//   - ranges as {"lower", "upper", "lower_inclusive", "upper_inclusive"} or
+//     {"empty": true}, with null for an unbounded side
+//   - inet as Postgres prints it (no /32 or /128 for a single host), cidr
+//     with its mask
+//   - bytea as base64
+//   - arrays element by element
+//
+// Other pgtype values use their text form.
+func encodeValue(m *pgtype.Map, oid uint32, v any) any {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case string, bool, int16, int64, uint32:
+		return x
+	case float32:
+		return encodeFloat(float64(x))
+	case float64:
+		return encodeFloat(x)
+	case pgtype.Numeric:
+		s, err := x.Value()
+		if err != nil {
+			return nil
+		}
+		return s
+	case [16]byte:
+		return fmt.Sprintf("%x-%x-%x-%x-%x", x[0:4], x[4:6], x[6:8], x[8:10], x[10:16])
+	case time.Time:
+		switch oid {
+		case pgtype.DateOID:
+			return x.Format("2006-01-02")
+		case pgtype.TimestampOID:
+			return x.Format("2006-01-02T15:04:05.999999")
+		default:
+			return x.Format(time.RFC3339Nano)
+		}
+	case pgtype.InfinityModifier:
+		return x.String()
+	case pgtype.Interval:
+		return isoInterval(x)
+	case netip.Prefix:
+		if oid == pgtype.InetOID && x.IsSingleIP() {
+			return x.Addr().String()
+		}
+		return x.String()
+	case net.HardwareAddr:
+		return x.String()
+	case int32: // also "char", which pgx decodes to a rune
+		if oid == pgtype.QCharOID {
+			return string(x)
+		}
+		return x
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x)
+	case []any:
+		elem := elementOID(m, oid)
+		out := make([]any, len(x))
+		for i, e := range x {
+			out[i] = encodeValue(m, elem, e)
+		}
+		return out
+	case pgtype.Range[any]:
+		return encodeRange(m, elementOID(m, oid), x)
+	case pgtype.Multirange[pgtype.Range[any]]:
+		elem := elementOID(m, oid)
+		out := make([]any, len(x))
+		for i, r := range x {
+			out[i] = encodeRange(m, elementOID(m, elem), r)
+		}
+		return out
+	case map[string]any: // json, composite
+		return x
+	case driver.Valuer:
+		s, err := x.Value()
+		if err != nil {
+			return nil
+		}
+		return s
+	}
+	return v
+}
+
+func encodeFloat(f float64) any {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
+
+func encodeRange(m *pgtype.Map, elem uint32, r pgtype.Range[any]) any {
+	if r.LowerType == pgtype.Empty {
+		return map[string]any{"empty": true}
+	}
+	out := map[string]any{
+		"lower":           nil,
+		"upper":           nil,
+		"lower_inclusive": r.LowerType == pgtype.Inclusive,
+		"upper_inclusive": r.UpperType == pgtype.Inclusive,
+	}
+	if r.LowerType != pgtype.Unbounded {
+		out["lower"] = encodeValue(m, elem, r.Lower)
+	}
+	if r.UpperType != pgtype.Unbounded {
+		out["upper"] = encodeValue(m, elem, r.Upper)
+	}
+	return out
+}
+
+// elementOID returns the element type of an array, range or multirange
+// type, or 0 if oid is none of those or unknown to the connection.
+func elementOID(m *pgtype.Map, oid uint32) uint32 {
+	t, ok := m.TypeForOID(oid)
+	if !ok {
+		return 0
+	}
+	var elem *pgtype.Type
+	switch c := t.Codec.(type) {
+	case *pgtype.ArrayCodec:
+		elem = c.ElementType
+	case *pgtype.RangeCodec:
+		elem = c.ElementType
+	case *pgtype.MultirangeCodec:
+		elem = c.ElementType
+	}
+	if elem == nil {
+		return 0
+	}
+	return elem.OID
+}
+
+// isoInterval formats iv as an ISO 8601 duration the way Postgres does with
+// IntervalStyle iso_8601: each component keeps its own sign, e.g. P-1DT2H.
+func isoInterval(iv pgtype.Interval) string {
+	var b strings.Builder
+	b.WriteString("P")
+	if y := iv.Months / 12; y != 0 {
+		fmt.Fprintf(&b, "%dY", y)
+	}
+	if mo := iv.Months % 12; mo != 0 {
+		fmt.Fprintf(&b, "%dM", mo)
+	}
+	if iv.Days != 0 {
+		fmt.Fprintf(&b, "%dD", iv.Days)
+	}
+	if us := iv.Microseconds; us != 0 {
+		b.WriteString("T")
+		h := us / int64(time.Hour/time.Microsecond)
+		us -= h * int64(time.Hour/time.Microsecond)
+		mi := us / int64(time.Minute/time.Microsecond)
+		us -= mi * int64(time.Minute/time.Microsecond)
+		if h != 0 {
+			fmt.Fprintf(&b, "%dH", h)
+		}
+		if mi != 0 {
+			fmt.Fprintf(&b, "%dM", mi)
+		}
+		if us != 0 {
+			sign := ""
+			if us < 0 {
+				sign, us = "-", -us
+			}
+			sec := fmt.Sprintf("%s%d.%06d", sign, us/1e6, us%1e6)
+			fmt.Fprintf(&b, "%sS", strings.TrimRight(strings.TrimRight(sec, "0"), "."))
+		}
+	}
+	if b.Len() == 1 {
+		return "PT0S"
+	}
+	return b.String()
+}
+
+const describeColumnsSQL = `
+SELECT pg_catalog.format_type(f.typ, NULLIF(f.mod, -1)), NOT coalesce(a.attnotnull, false)
+FROM unnest($1::oid[], $2::int4[], $3::oid[], $4::int2[]) WITH ORDINALITY AS f(typ, mod, rel, att, ord)
+LEFT JOIN pg_catalog.pg_attribute a ON a.attrelid = f.rel AND a.attnum = f.att AND f.att > 0
+ORDER BY f.ord`
+
+// describeColumns resolves the type names and nullability of a result's
+// columns in one catalog query on tx.
+func describeColumns(ctx context.Context, tx pgx.Tx, flds []pgconn.FieldDescription) ([]columnInfo, error) {
+	cols := make([]columnInfo, len(flds))
+	if len(flds) == 0 {
+		return cols, nil
+	}
+	typs := make([]uint32, len(flds))
+	mods := make([]int32, len(flds))
+	rels := make([]uint32, len(flds))
+	atts := make([]int16, len(flds))
+	for i, f := range flds {
+		cols[i].Name = f.Name
+		typs[i], mods[i], rels[i], atts[i] = f.DataTypeOID, f.TypeModifier, f.TableOID, int16(f.TableAttributeNumber)
+	}
+	rows, err := tx.Query(ctx, describeColumnsSQL, typs, mods, rels, atts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for i := 0; rows.Next(); i++ {
+		if i >= len(cols) {
+			break
+		}
+		var typ *string
+		if err := rows.Scan(&typ, &cols[i].Nullable); err != nil {
+			return nil, err
+		}
+		if typ != nil {
+			cols[i].Type = *typ
+		}
+	}
+	return cols, rows.Err()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestEncodeValue(t *testing.T) {
+	m := pgtype.NewMap()
+	ts := time.Date(2024, 3, 9, 14, 5, 6, 123456000, time.UTC)
+	tests := []struct {
+		name string
+		oid  uint32
+		in   any
+		want string // JSON
+	}{
+		{"null", pgtype.TextOID, nil, `null`},
+		{"numeric keeps precision", pgtype.NumericOID, pgtype.Numeric{Int: mustBig("123456789012345678901234567890"), Exp: -2, Valid: true}, `"1234567890123456789012345678.90"`},
+		{"numeric NaN", pgtype.NumericOID, pgtype.Numeric{NaN: true, Valid: true}, `"NaN"`},
+		{"float NaN", pgtype.Float8OID, math.NaN(), `"NaN"`},
+		{"float -Inf", pgtype.Float4OID, float32(math.Inf(-1)), `"-Infinity"`},
+		{"float", pgtype.Float8OID, 1.5, `1.5`},
+		{"uuid", pgtype.UUIDOID, [16]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}, `"12345678-9abc-def0-0123-456789abcdef"`},
+		{"date", pgtype.DateOID, time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC), `"2024-03-09"`},
+		{"timestamp", pgtype.TimestampOID, ts, `"2024-03-09T14:05:06.123456"`},
+		{"timestamptz", pgtype.TimestamptzOID, ts, `"2024-03-09T14:05:06.123456Z"`},
+		{"infinity", pgtype.DateOID, pgtype.Infinity, `"infinity"`},
+		{"interval", pgtype.IntervalOID, pgtype.Interval{Months: 14, Days: 3, Microseconds: 4*3600e6 + 5*60e6 + 6.5e6, Valid: true}, `"P1Y2M3DT4H5M6.5S"`},
+		{"inet host", pgtype.InetOID, netip.MustParsePrefix("10.0.0.1/32"), `"10.0.0.1"`},
+		{"inet net", pgtype.InetOID, netip.MustParsePrefix("10.0.0.1/8"), `"10.0.0.1/8"`},
+		{"cidr", pgtype.CIDROID, netip.MustParsePrefix("10.0.0.0/32"), `"10.0.0.0/32"`},
+		{"macaddr", pgtype.MacaddrOID, net.HardwareAddr{0x08, 0, 0x2b, 1, 2, 3}, `"08:00:2b:01:02:03"`},
+		{"bytea", pgtype.ByteaOID, []byte("hi"), `"aGk="`},
+		{"char", pgtype.QCharOID, int32('x'), `"x"`},
+		{"int4", pgtype.Int4OID, int32(7), `7`},
+		{"uuid array", pgtype.UUIDArrayOID, []any{[16]byte{15: 1}, nil}, `["00000000-0000-0000-0000-000000000001",null]`},
+		{"date range", pgtype.DaterangeOID, pgtype.Range[any]{
+			Lower: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), LowerType: pgtype.Inclusive,
+			UpperType: pgtype.Unbounded, Valid: true,
+		}, `{"lower":"2024-01-01","lower_inclusive":true,"upper":null,"upper_inclusive":false}`},
+		{"empty range", pgtype.Int4rangeOID, pgtype.Range[any]{LowerType: pgtype.Empty, UpperType: pgtype.Empty, Valid: true}, `{"empty":true}`},
+		{"point", pgtype.PointOID, pgtype.Point{P: pgtype.Vec2{X: 1, Y: 2}, Valid: true}, `"(1,2)"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(encodeValue(m, tt.oid, tt.in))
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func mustBig(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic(s)
+	}
+	return n
+}
+
+func TestIsoInterval(t *testing.T) {
+	tests := []struct {
+		in   pgtype.Interval
+		want string
+	}{
+		{pgtype.Interval{Valid: true}, "PT0S"},
+		{pgtype.Interval{Days: -1, Microseconds: 2 * 3600e6, Valid: true}, "P-1DT2H"},
+		{pgtype.Interval{Microseconds: -1500000, Valid: true}, "PT-1.5S"},
+		{pgtype.Interval{Months: 1, Microseconds: 60e6, Valid: true}, "P1MT1M"},
+	}
+	for _, tt := range tests {
+		if got := isoInterval(tt.in); got != tt.want {
+			t.Errorf("isoInterval(%+v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeJSONKeepsNumbers(t *testing.T) {
+	f := pgconn.FieldDescription{DataTypeOID: pgtype.JSONBOID, Format: pgtype.BinaryFormatCode}
+	v, ok := decodeJSON(append([]byte{1}, `{"id": 9007199254740993, "n": 0.1}`...), f)
+	if !ok {
+		t.Fatalf("binary jsonb not decoded")
+	}
+	b, _ := json.Marshal(v)
+	if string(b) != `{"id":9007199254740993,"n":0.1}` {
+		t.Fatalf("got %s", b)
+	}
+
+	f.Format = pgtype.TextFormatCode
+	if _, ok := decodeJSON([]byte(`[1, 2`), f); ok {
+		t.Fatalf("malformed json should fall back to pgx's value")
+	}
+}
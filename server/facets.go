@@ -0,0 +1,191 @@
+// server/facets.go
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const defaultFacetLimit = 5 // hits per facet when the request gives no limit
+
+// searchHitColumns is the select list of a hit, unless a tableSearch says otherwise.
+const searchHitColumns = `source_table, "column", score, match_text, row_key`
+
+// facetFields are the result columns that place a faceted hit rather than
+// describe it; they are dropped from the hits once grouped.
+var facetFields = []string{"source_table", "column", "facet_count", "table_count"}
+
+// searchFacet is one column's hits in a faceted search, best first.
+type searchFacet struct {
+	Column     string           `json:"column"`
+	Count      int64            `json:"count,omitempty"` // rows matching in this column; not repeated on later pages
+	Rows       []map[string]any `json:"rows"`
+	NextCursor string           `json:"next_cursor,omitempty"` // pass as cursor for this facet's next hits
+}
+
+// searchTableFacet is one table's facets, most matches first.
+type searchTableFacet struct {
+	Table   string        `json:"table"`
+	Count   int64         `json:"count,omitempty"` // rows matching in any column
+	Columns []searchFacet `json:"columns"`
+}
+
+func hitColumns(ts tableSearch) string {
+	return cmp.Or(ts.finish, searchHitColumns)
+}
+
+// facetSearchSQL returns ts's query grouped into facets: the n best hits of
+// each column, each carrying the column's match count and the table's,
+// where a row matching in several columns counts once. Rows of a view have
+// no key to tell them apart, so there they count once per column. Ties are
+// broken by row_key so facetPageSQL continues in the same order.
+func facetSearchSQL(ts tableSearch, n int) string {
+	tableCount := "count(DISTINCT row_key)"
+	if ts.table.Kind == tableKindView {
+		tableCount = "count(*)"
+	}
+	return "WITH u AS (\n" + strings.Join(ts.parts, "\nUNION ALL\n") + "\n), ranked AS (\n" +
+		`SELECT *, row_number() OVER (PARTITION BY "column" ORDER BY score DESC, row_key) AS facet_rank, count(*) OVER (PARTITION BY "column") AS facet_count FROM u` + "\n)\n" +
+		fmt.Sprintf(`SELECT %s, facet_count, (SELECT %s FROM u) AS table_count FROM ranked WHERE facet_rank <= %d ORDER BY score DESC, row_key`, hitColumns(ts), tableCount, n)
+}
+
+// facetPageSQL returns the query for every hit of one column of ts, in
+// facet order, with the column name bound after ts's own args. The other
+// columns' parts are pruned by the planner, as their column never matches.
+func facetPageSQL(ts tableSearch, column string) (string, []any) {
+	args := append(slices.Clip(ts.args), column)
+	return "WITH u AS (\n" + strings.Join(ts.parts, "\nUNION ALL\n") + "\n)\n" +
+		fmt.Sprintf(`SELECT %s FROM u WHERE "column" = $%d::text ORDER BY score DESC, row_key`, hitColumns(ts), len(args)), args
+}
+
+// facetHit strips a hit of the fields facetFields lists. row must not be
+// shared with the result cache.
+func facetHit(row map[string]any) map[string]any {
+	for _, f := range facetFields {
+		delete(row, f)
+	}
+	return row
+}
+
+// facetHitColumns is cols without facetFields.
+func facetHitColumns(cols []columnInfo) []columnInfo {
+	return slices.DeleteFunc(slices.Clone(cols), func(c columnInfo) bool { return slices.Contains(facetFields, c.Name) })
+}
+
+// searchFacets groups the hits of a run of facetSearchSQL queries by table
+// and column, most matches first, and signs a cursor for each facet with
+// more than the n hits shown.
+func (s *Server) searchFacets(run *searchRun, searches []tableSearch, settings map[string]string, n int) ([]searchTableFacet, error) {
+	byLabel := map[string]tableSearch{}
+	for _, ts := range searches {
+		byLabel[ts.label()] = ts
+	}
+	var out []searchTableFacet
+	tables := map[string]int{}     // label -> index in out
+	columns := map[[2]string]int{} // label and column -> index in out[i].Columns
+	for _, row := range run.rows {
+		label, _ := row["source_table"].(string)
+		column, _ := row["column"].(string)
+		ti, ok := tables[label]
+		if !ok {
+			ti = len(out)
+			tables[label] = ti
+			count, _ := row["table_count"].(int64)
+			out = append(out, searchTableFacet{Table: label, Count: count})
+		}
+		ci, ok := columns[[2]string{label, column}]
+		if !ok {
+			ci = len(out[ti].Columns)
+			columns[[2]string{label, column}] = ci
+			count, _ := row["facet_count"].(int64)
+			out[ti].Columns = append(out[ti].Columns, searchFacet{Column: column, Count: count})
+		}
+		out[ti].Columns[ci].Rows = append(out[ti].Columns[ci].Rows, facetHit(row))
+	}
+
+	for i := range out {
+		ts := byLabel[out[i].Table]
+		for j := range out[i].Columns {
+			f := &out[i].Columns[j]
+			if f.Count <= int64(len(f.Rows)) {
+				continue
+			}
+			sql, args := facetPageSQL(ts, f.Column)
+			tok := pageToken{
+				SQL: sql, Page: 1, Size: n, Mode: pageModeOffset, Offset: len(f.Rows),
+				Facet: []string{ts.table.Schema, ts.table.Name, f.Column}, Args: textArgs(args), Settings: settings,
+			}
+			var err error
+			if f.NextCursor, err = s.pages.Sign(tok); err != nil {
+				return nil, err
+			}
+		}
+		slices.SortStableFunc(out[i].Columns, func(a, b searchFacet) int { return cmp.Compare(b.Count, a.Count) })
+	}
+	slices.SortStableFunc(out, func(a, b searchTableFacet) int { return cmp.Compare(b.Count, a.Count) })
+	return out, nil
+}
+
+// textArgs renders search args, which are all text, for a pageToken.
+func textArgs(args []any) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = fmt.Sprint(a)
+	}
+	return out
+}
+
+// searchFacetPage fetches the hits of a facet that tok continues from, and
+// signs the token for the next page if there is one.
+func (s *Server) searchFacetPage(ctx context.Context, req *mcp.CallToolRequest, tok pageToken) (searchOutput, error) {
+	schema, table, column := tok.Facet[0], tok.Facet[1], tok.Facet[2]
+	label := schema + "." + table
+	sql, _ := pageSQL(tok) // offset pages take no key arguments
+	args := make([]any, len(tok.Args))
+	for i, a := range tok.Args {
+		args[i] = a
+	}
+	var tables []string
+	if model, err := s.cache.Model(ctx, s.db); err == nil {
+		tables = searchedTables(model.Table(schema, table))
+	}
+	res, cachedAt, err := cachedQuery(s, req, "search", sql, args, tables, []any{tok.Size + 1, tok.Settings}, func() (*queryResult, error) {
+		return s.runReadOnlyQueryWith(ctx, tok.Settings, sql, tok.Size+1, args...)
+	})
+	if err != nil {
+		return searchOutput{}, err
+	}
+
+	facet := searchFacet{Column: column, Rows: make([]map[string]any, 0, min(len(res.Rows), tok.Size))}
+	for _, r := range res.Rows[:min(len(res.Rows), tok.Size)] {
+		// Rows may be shared with the result cache, so change a copy
+		row := facetHit(maps.Clone(r))
+		if key, ok := row["row_key"].(map[string]any); ok {
+			row["row_uri"] = rowResourceURI(schema, table, rowKey(key))
+		}
+		facet.Rows = append(facet.Rows, row)
+	}
+	if len(res.Rows) > tok.Size {
+		next := tok
+		next.Page, next.Offset, next.Issued = tok.Page+1, tok.Offset+tok.Size, 0
+		if facet.NextCursor, err = s.pages.Sign(next); err != nil {
+			return searchOutput{}, err
+		}
+	}
+	return searchOutput{
+		Queries:        []searchQuery{{Table: label, SQL: tok.SQL, Params: args}},
+		Columns:        append(facetHitColumns(res.Columns), columnInfo{Name: "row_uri", Type: "text"}),
+		Rows:           []map[string]any{},
+		Facets:         []searchTableFacet{{Table: label, Columns: []searchFacet{facet}}},
+		Replica:        res.Replica,
+		Cached:         cachedAt != nil,
+		CachedAt:       cachedAt,
+		TablesSearched: 1,
+	}, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFacetSQL(t *testing.T) {
+	searches, _, err := serverWithModel(testSchemaModel()).buildSearchSQL(context.Background(), "book", 10, "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := searches[0]
+	if len(ts.parts) == 0 {
+		t.Fatalf("no parts recorded for %s", ts.label())
+	}
+
+	sql := facetSearchSQL(ts, 3)
+	for _, want := range []string{
+		`row_number() OVER (PARTITION BY "column" ORDER BY score DESC, row_key) AS facet_rank`,
+		`count(*) OVER (PARTITION BY "column") AS facet_count`,
+		`(SELECT count(DISTINCT row_key) FROM u) AS table_count`,
+		`WHERE facet_rank <= 3 ORDER BY score DESC, row_key`,
+		ts.parts[0],
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("facet sql missing %q:\n%s", want, sql)
+		}
+	}
+
+	page, args := facetPageSQL(ts, "name")
+	if want := append(append([]any(nil), ts.args...), "name"); !reflect.DeepEqual(args, want) {
+		t.Fatalf("args = %q, want %q", args, want)
+	}
+	if _, other := facetPageSQL(ts, "other"); args[len(args)-1] != "name" || other[len(other)-1] != "other" {
+		t.Fatal("facet pages share their args")
+	}
+	if want := fmt.Sprintf(`SELECT source_table, "column", score, match_text, row_key FROM u WHERE "column" = $%d::text ORDER BY score DESC, row_key`, len(args)); !strings.Contains(page, want) {
+		t.Fatalf("page sql missing %q:\n%s", want, page)
+	}
+
+	// Full-text hits keep their headline select list
+	ts.finish = `source_table, "column", score, ts_headline(cfg, doc, websearch_to_tsquery(cfg, $1::text)) AS match_text, row_key`
+	if sql := facetSearchSQL(ts, 3); !strings.Contains(sql, "SELECT "+ts.finish+", facet_count") {
+		t.Fatalf("facet sql ignores finish:\n%s", sql)
+	}
+}
+
+func TestSearchFacets(t *testing.T) {
+	signer, err := newPageSigner("0123456789abcdef-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{pages: signer}
+	m := testSchemaModel()
+	searches := []tableSearch{
+		{table: m.Tables[0], args: []any{"%a%", "x"}, parts: []string{"SELECT 1"}},
+		{table: m.Tables[1], args: []any{"%a%", "y"}, parts: []string{"SELECT 2"}},
+	}
+	hit := func(ts tableSearch, column string, facetCount, tableCount int64, score float64) map[string]any {
+		return map[string]any{"source_table": ts.label(), "column": column, "score": score, "match_text": "a",
+			"facet_count": facetCount, "table_count": tableCount, "row_uri": "pgmcp://row/x"}
+	}
+	run := &searchRun{rows: []map[string]any{
+		hit(searches[0], "name", 1, 1, 0.9),
+		hit(searches[1], "title", 1, 3, 0.8),
+		hit(searches[1], "description", 3, 3, 0.5),
+		hit(searches[1], "description", 3, 3, 0.4),
+	}}
+	settings := map[string]string{"pg_trgm.similarity_threshold": "0.4"}
+	facets, err := srv.searchFacets(run, searches, settings, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Most matches first, both across tables and within one
+	if len(facets) != 2 || facets[0].Table != searches[1].label() || facets[0].Count != 3 || facets[1].Count != 1 {
+		t.Fatalf("unexpected tables: %+v", facets)
+	}
+	cols := facets[0].Columns
+	if len(cols) != 2 || cols[0].Column != "description" || cols[0].Count != 3 || len(cols[0].Rows) != 2 || cols[1].Column != "title" {
+		t.Fatalf("unexpected columns: %+v", cols)
+	}
+	for _, f := range facetFields {
+		if _, ok := cols[0].Rows[0][f]; ok {
+			t.Fatalf("hit still has %s: %v", f, cols[0].Rows[0])
+		}
+	}
+	if cols[1].NextCursor != "" || facets[1].Columns[0].NextCursor != "" {
+		t.Fatal("facets with every hit shown should have no cursor")
+	}
+
+	tok, err := signer.Verify(cols[0].NextCursor)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	ts := searches[1]
+	if want := []string{ts.table.Schema, ts.table.Name, "description"}; !reflect.DeepEqual(tok.Facet, want) {
+		t.Fatalf("facet = %q, want %q", tok.Facet, want)
+	}
+	if tok.Offset != 2 || tok.Size != 2 || tok.Mode != pageModeOffset || !reflect.DeepEqual(tok.Settings, settings) {
+		t.Fatalf("unexpected token: %+v", tok)
+	}
+	sql, args := facetPageSQL(ts, "description")
+	if tok.SQL != sql || !reflect.DeepEqual(tok.Args, textArgs(args)) {
+		t.Fatalf("token does not carry the facet query: %+v", tok)
+	}
+}
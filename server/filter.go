@@ -0,0 +1,188 @@
+// server/filter.go
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// objectPattern matches schema or table names. Patterns are shell globs
+// (path.Match syntax) unless written as /regex/ or re:regex. A table pattern
+// containing a dot is matched against "schema.table", otherwise against the
+// bare table name.
+type objectPattern struct {
+	raw       string
+	re        *regexp.Regexp
+	qualified bool
+}
+
+func parseObjectPattern(raw string) (objectPattern, error) {
+	p := objectPattern{raw: raw}
+	expr, isRegex := "", false
+	switch {
+	case strings.HasPrefix(raw, "re:"):
+		expr, isRegex = strings.TrimPrefix(raw, "re:"), true
+	case len(raw) > 2 && strings.HasPrefix(raw, "/") && strings.HasSuffix(raw, "/"):
+		expr, isRegex = raw[1:len(raw)-1], true
+	}
+	if isRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return p, fmt.Errorf("invalid regex pattern %q: %v", raw, err)
+		}
+		p.re = re
+		p.qualified = strings.Contains(expr, `\.`)
+		return p, nil
+	}
+	if _, err := path.Match(raw, ""); err != nil {
+		return p, fmt.Errorf("invalid glob pattern %q: %v", raw, err)
+	}
+	p.qualified = strings.Contains(raw, ".")
+	return p, nil
+}
+
+func (p objectPattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	ok, _ := path.Match(p.raw, name)
+	return ok
+}
+
+func (p objectPattern) matchTable(schema, table string) bool {
+	if p.qualified {
+		return p.match(schema + "." + table)
+	}
+	return p.match(table)
+}
+
+// objectFilter decides which schemas and tables are visible to the prompt,
+// search and introspection. Excludes win over includes; an empty include
+// list includes everything.
+type objectFilter struct {
+	schemaInclude, schemaExclude []objectPattern
+	tableInclude, tableExclude   []objectPattern
+}
+
+// parsePatternList splits a comma-separated list of patterns.
+func parsePatternList(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func newObjectFilter(cfg Config) (*objectFilter, error) {
+	f := &objectFilter{}
+	var errs []string
+	for _, l := range []struct {
+		dst *[]objectPattern
+		src []string
+	}{
+		{&f.schemaInclude, cfg.SchemaInclude},
+		{&f.schemaExclude, cfg.SchemaExclude},
+		{&f.tableInclude, cfg.TableInclude},
+		{&f.tableExclude, cfg.TableExclude},
+	} {
+		for _, raw := range l.src {
+			p, err := parseObjectPattern(raw)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			*l.dst = append(*l.dst, p)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return f, nil
+}
+
+func anyMatch(ps []objectPattern, fn func(objectPattern) bool) bool {
+	for _, p := range ps {
+		if fn(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *objectFilter) allowSchema(schema string) bool {
+	if f == nil {
+		return true
+	}
+	m := func(p objectPattern) bool { return p.match(schema) }
+	if anyMatch(f.schemaExclude, m) {
+		return false
+	}
+	return len(f.schemaInclude) == 0 || anyMatch(f.schemaInclude, m)
+}
+
+func (f *objectFilter) allowTable(schema, table string) bool {
+	if f == nil {
+		return true
+	}
+	if !f.allowSchema(schema) {
+		return false
+	}
+	m := func(p objectPattern) bool { return p.matchTable(schema, table) }
+	if anyMatch(f.tableExclude, m) {
+		return false
+	}
+	return len(f.tableInclude) == 0 || anyMatch(f.tableInclude, m)
+}
+
+// apply removes filtered tables and enum types from m in place, drops
+// foreign keys that point at removed tables, and returns the qualified
+// names of everything it removed.
+func (f *objectFilter) apply(m *SchemaModel) []string {
+	if f == nil {
+		return nil
+	}
+	var excluded []string
+	kept := m.Tables[:0]
+	for _, t := range m.Tables {
+		if f.allowTable(t.Schema, t.Name) {
+			kept = append(kept, t)
+		} else {
+			excluded = append(excluded, t.Schema+"."+t.Name)
+		}
+	}
+	m.Tables = kept
+	views := m.Views[:0]
+	for _, v := range m.Views {
+		if f.allowTable(v.Schema, v.Name) {
+			views = append(views, v)
+		} else {
+			excluded = append(excluded, v.Schema+"."+v.Name)
+		}
+	}
+	m.Views = views
+
+	for _, t := range m.Tables {
+		fks := t.ForeignKeys[:0]
+		for _, fk := range t.ForeignKeys {
+			if f.allowTable(fk.RefSchema, fk.RefTable) {
+				fks = append(fks, fk)
+			}
+		}
+		t.ForeignKeys = fks
+	}
+
+	enums := m.Enums[:0]
+	for _, e := range m.Enums {
+		if f.allowSchema(e.Schema) {
+			enums = append(enums, e)
+		} else {
+			excluded = append(excluded, "type "+e.Schema+"."+e.Name)
+		}
+	}
+	m.Enums = enums
+	return excluded
+}
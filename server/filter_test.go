@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObjectFilterPatterns(t *testing.T) {
+	f, err := newObjectFilter(Config{
+		SchemaExclude: []string{"audit", "/^partman_/"},
+		TableExclude:  []string{"*_staging", "public.tmp?", "re:^sales\\.archive_"},
+	})
+	if err != nil {
+		t.Fatalf("newObjectFilter: %v", err)
+	}
+
+	tests := []struct {
+		schema, table string
+		want          bool
+	}{
+		{"public", "users", true},
+		{"audit", "events", false},
+		{"partman_1", "parts", false},
+		{"partman", "parts", true}, // regex requires the underscore
+		{"public", "orders_staging", false},
+		{"sales", "orders_staging", false}, // unqualified glob matches any schema
+		{"public", "tmp1", false},
+		{"other", "tmp1", true}, // qualified glob only matches public
+		{"sales", "archive_2020", false},
+		{"public", "archive_2020", true},
+	}
+	for _, tt := range tests {
+		if got := f.allowTable(tt.schema, tt.table); got != tt.want {
+			t.Fatalf("allowTable(%s, %s)=%v want %v", tt.schema, tt.table, got, tt.want)
+		}
+	}
+}
+
+func TestObjectFilterIncludes(t *testing.T) {
+	f, err := newObjectFilter(Config{
+		SchemaInclude: []string{"public", "sales"},
+		TableInclude:  []string{"order*", "sales.*"},
+		TableExclude:  []string{"orders_old"},
+	})
+	if err != nil {
+		t.Fatalf("newObjectFilter: %v", err)
+	}
+	tests := []struct {
+		schema, table string
+		want          bool
+	}{
+		{"public", "orders", true},
+		{"public", "order_items", true},
+		{"public", "users", false},      // not in table include list
+		{"public", "orders_old", false}, // excludes win over includes
+		{"sales", "leads", true},
+		{"hr", "orders", false}, // schema not included
+	}
+	for _, tt := range tests {
+		if got := f.allowTable(tt.schema, tt.table); got != tt.want {
+			t.Fatalf("allowTable(%s, %s)=%v want %v", tt.schema, tt.table, got, tt.want)
+		}
+	}
+
+	var nilFilter *objectFilter
+	if !nilFilter.allowTable("any", "thing") || !nilFilter.allowSchema("any") {
+		t.Fatalf("nil filter must allow everything")
+	}
+}
+
+func TestObjectFilterApply(t *testing.T) {
+	m := testSchemaModel()
+	m.Tables = append(m.Tables, &Table{Schema: "audit", Name: "log"})
+	m.Tables[1].ForeignKeys = append(m.Tables[1].ForeignKeys, ForeignKey{
+		Name: "order_items_log_fk", Columns: []string{"order_id"}, RefSchema: "audit", RefTable: "log", RefColumns: []string{"id"},
+	})
+	m.Enums = append(m.Enums, EnumType{Schema: "audit", Name: "action", Labels: []string{"insert"}})
+	m.Views = []*Table{{Schema: "audit", Name: "recent", Kind: tableKindView}, {Schema: "public", Name: "totals", Kind: tableKindMatview}}
+
+	f, err := newObjectFilter(Config{SchemaExclude: []string{"audit"}})
+	if err != nil {
+		t.Fatalf("newObjectFilter: %v", err)
+	}
+	excluded := f.apply(m)
+
+	if len(excluded) != 3 || excluded[0] != "audit.log" || excluded[1] != "audit.recent" || excluded[2] != "type audit.action" {
+		t.Fatalf("unexpected excluded list: %v", excluded)
+	}
+	if len(m.Views) != 1 || m.Views[0].Name != "totals" {
+		t.Fatalf("unexpected views: %+v", m.Views)
+	}
+	if m.Table("audit", "log") != nil {
+		t.Fatalf("excluded table still present")
+	}
+	for _, fk := range m.Table("public", "order_items").ForeignKeys {
+		if fk.RefSchema == "audit" {
+			t.Fatalf("foreign key to excluded table kept: %+v", fk)
+		}
+	}
+	if len(m.Enums) != 1 {
+		t.Fatalf("expected audit enum to be removed, got %+v", m.Enums)
+	}
+	if strings.Contains(m.RenderPrompt(), "audit") {
+		t.Fatalf("prompt still mentions excluded schema:\n%s", m.RenderPrompt())
+	}
+}
+
+func TestParsePatternList(t *testing.T) {
+	got := parsePatternList(" audit, ,staging_*,/^tmp/ ")
+	want := []string{"audit", "staging_*", "/^tmp/"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("parsePatternList=%q want %q", got, want)
+	}
+	if parsePatternList("") != nil {
+		t.Fatalf("empty list should parse to nil")
+	}
+}
+
+func TestConfigValidateFilters(t *testing.T) {
+	cfg := Config{
+		DatabaseURL:  "postgres://localhost/test",
+		MaxRows:      100,
+		QueryTO:      10 * time.Second,
+		SchemaTTL:    5 * time.Minute,
+		TableExclude: []string{"/([a-z/"},
+	}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "invalid schema/table filter") {
+		t.Fatalf("expected filter validation error, got %v", err)
+	}
+
+	cfg.TableExclude = []string{"[bad"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("expected invalid glob to be rejected")
+	}
+}
@@ -0,0 +1,219 @@
+// server/introspect.go
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/rs/zerolog/log"
+)
+
+// tableStats are the volatile numbers that are read live rather than cached.
+type tableStats struct {
+	RowEstimate int64
+	SizeBytes   int64
+	Size        string
+}
+
+const tableStatsSQL = `
+SELECT n.nspname, c.relname, c.reltuples::bigint,
+       pg_catalog.pg_total_relation_size(c.oid),
+       pg_catalog.pg_size_pretty(pg_catalog.pg_total_relation_size(c.oid))
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r','p') AND n.nspname NOT IN ('pg_catalog','information_schema')
+  AND ($1::text IS NULL OR n.nspname = $1)
+  AND ($2::text IS NULL OR c.relname = $2)`
+
+// loadTableStats reads row estimates and on-disk sizes, optionally for a
+// single schema and/or table (nil means all).
+func (s *Server) loadTableStats(ctx context.Context, schema, table *string) (map[string]tableStats, error) {
+	ctxTO, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	rows, err := s.db.Query(ctxTO, tableStatsSQL, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]tableStats{}
+	for rows.Next() {
+		var sc, tn string
+		var st tableStats
+		if err := rows.Scan(&sc, &tn, &st.RowEstimate, &st.SizeBytes, &st.Size); err != nil {
+			return nil, err
+		}
+		out[sc+"."+tn] = st
+	}
+	return out, rows.Err()
+}
+
+// ---------- list_tables tool ----------
+
+type listTablesInput struct {
+	Schema string `json:"schema,omitempty"` // only list tables in this schema
+	Filter string `json:"filter,omitempty"` // case-insensitive substring of the table name
+}
+
+type tableSummary struct {
+	Schema      string `json:"schema"`
+	Name        string `json:"name"`
+	Comment     string `json:"comment,omitempty"`
+	Columns     int    `json:"columns"`
+	RowEstimate int64  `json:"row_estimate"` // -1 if never analyzed
+	SizeBytes   int64  `json:"size_bytes"`
+	Size        string `json:"size"`
+	URI         string `json:"uri"`
+}
+
+type listTablesOutput struct {
+	Tables []tableSummary `json:"tables"`
+}
+
+// summarizeTables lists the model's tables that match the input, with stats merged in.
+func summarizeTables(m *SchemaModel, in listTablesInput, stats map[string]tableStats) []tableSummary {
+	out := []tableSummary{}
+	filter := strings.ToLower(strings.TrimSpace(in.Filter))
+	for _, t := range m.Tables {
+		if in.Schema != "" && t.Schema != in.Schema {
+			continue
+		}
+		if filter != "" && !strings.Contains(strings.ToLower(t.Name), filter) {
+			continue
+		}
+		st, ok := stats[t.Schema+"."+t.Name]
+		if !ok {
+			st = tableStats{RowEstimate: t.RowEstimate}
+		}
+		out = append(out, tableSummary{
+			Schema: t.Schema, Name: t.Name, Comment: t.Comment, Columns: len(t.Columns),
+			RowEstimate: st.RowEstimate, SizeBytes: st.SizeBytes, Size: st.Size,
+			URI: tableResourceURI(t.Schema, t.Name),
+		})
+	}
+	return out
+}
+
+func (s *Server) handleListTables(ctx context.Context, req *mcp.CallToolRequest, in listTablesInput) (*mcp.CallToolResult, listTablesOutput, error) {
+	start := time.Now()
+	log.Debug().Str("tool", "list_tables").Str("schema", in.Schema).Str("filter", in.Filter).Msg("request")
+
+	model, err := s.cache.Model(ctx, s.db)
+	if err != nil {
+		log.Debug().Str("tool", "list_tables").Err(err).Msg("schema load failed")
+		return nil, listTablesOutput{}, err
+	}
+	var schema *string
+	if in.Schema != "" {
+		schema = &in.Schema
+	}
+	stats, err := s.loadTableStats(ctx, schema, nil)
+	if err != nil {
+		log.Debug().Str("tool", "list_tables").Err(err).Msg("stats query failed")
+		return nil, listTablesOutput{}, err
+	}
+	out := listTablesOutput{Tables: summarizeTables(model, in, stats)}
+	log.Debug().Str("tool", "list_tables").Int("tables", len(out.Tables)).Dur("dur", time.Since(start)).Msg("done")
+	return nil, out, nil
+}
+
+// ---------- describe_table tool ----------
+
+type describeTableInput struct {
+	Table  string `json:"table"`            // table name, optionally schema-qualified
+	Schema string `json:"schema,omitempty"` // schema, if not part of table
+}
+
+// InboundFK is a foreign key on another table that references the described table.
+type InboundFK struct {
+	Schema     string   `json:"schema"`
+	Table      string   `json:"table"`
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	RefColumns []string `json:"ref_columns"`
+}
+
+type describeTableOutput struct {
+	Schema       string       `json:"schema"`
+	Name         string       `json:"name"`
+	Comment      string       `json:"comment,omitempty"`
+	RowEstimate  int64        `json:"row_estimate"`
+	SizeBytes    int64        `json:"size_bytes"`
+	Size         string       `json:"size"`
+	Columns      []Column     `json:"columns"`
+	PrimaryKey   *Constraint  `json:"primary_key,omitempty"`
+	Uniques      []Constraint `json:"unique_constraints,omitempty"`
+	Checks       []Check      `json:"check_constraints,omitempty"`
+	Indexes      []Index      `json:"indexes,omitempty"`
+	ForeignKeys  []ForeignKey `json:"foreign_keys,omitempty"`
+	ReferencedBy []InboundFK  `json:"referenced_by,omitempty"`
+	Enums        []EnumType   `json:"enums,omitempty"` // enum types used by the columns
+}
+
+// describeTable assembles the full description of t from the model.
+func describeTable(m *SchemaModel, t *Table, st tableStats) describeTableOutput {
+	out := describeTableOutput{
+		Schema: t.Schema, Name: t.Name, Comment: t.Comment,
+		RowEstimate: st.RowEstimate, SizeBytes: st.SizeBytes, Size: st.Size,
+		Columns: t.Columns, PrimaryKey: t.PrimaryKey, Uniques: t.Uniques,
+		Checks: t.Checks, Indexes: t.Indexes, ForeignKeys: t.ForeignKeys,
+	}
+	for _, other := range m.Tables {
+		for _, fk := range other.ForeignKeys {
+			if fk.RefSchema == t.Schema && fk.RefTable == t.Name {
+				out.ReferencedBy = append(out.ReferencedBy, InboundFK{
+					Schema: other.Schema, Table: other.Name, Name: fk.Name,
+					Columns: fk.Columns, RefColumns: fk.RefColumns,
+				})
+			}
+		}
+	}
+	used := map[[2]string]bool{} // schema and name of each column type
+	for _, c := range t.Columns {
+		// Arrays of enums too; an array type shares its element's schema
+		used[[2]string{c.TypeSchema, strings.TrimPrefix(c.BaseType, "_")}] = true
+	}
+	for _, e := range m.Enums {
+		if used[[2]string{e.Schema, e.Name}] {
+			out.Enums = append(out.Enums, e)
+		}
+	}
+	return out
+}
+
+func (s *Server) handleDescribeTable(ctx context.Context, req *mcp.CallToolRequest, in describeTableInput) (*mcp.CallToolResult, describeTableOutput, error) {
+	start := time.Now()
+	log.Debug().Str("tool", "describe_table").Str("schema", in.Schema).Str("table", in.Table).Msg("request")
+
+	if strings.TrimSpace(in.Table) == "" {
+		return nil, describeTableOutput{}, errors.New("table is required")
+	}
+	model, err := s.cache.Model(ctx, s.db)
+	if err != nil {
+		log.Debug().Str("tool", "describe_table").Err(err).Msg("schema load failed")
+		return nil, describeTableOutput{}, err
+	}
+	name := in.Table
+	if in.Schema != "" {
+		name = in.Schema + "." + in.Table
+	}
+	t, err := resolveTable(model, name)
+	if err != nil {
+		return nil, describeTableOutput{}, err
+	}
+	stats, err := s.loadTableStats(ctx, &t.Schema, &t.Name)
+	if err != nil {
+		log.Debug().Str("tool", "describe_table").Err(err).Msg("stats query failed")
+		return nil, describeTableOutput{}, err
+	}
+	st, ok := stats[t.Schema+"."+t.Name]
+	if !ok {
+		// Dropped since the schema was cached; report what we know.
+		st = tableStats{RowEstimate: t.RowEstimate}
+	}
+	out := describeTable(model, t, st)
+	log.Debug().Str("tool", "describe_table").Str("table", t.QualifiedName()).Dur("dur", time.Since(start)).Msg("done")
+	return nil, out, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestSummarizeTables(t *testing.T) {
+	m := testSchemaModel()
+	stats := map[string]tableStats{"public.order_items": {RowEstimate: 42, SizeBytes: 16384, Size: "16 kB"}}
+
+	all := summarizeTables(m, listTablesInput{}, stats)
+	if len(all) != 2 {
+		t.Fatalf("expected 2 tables, got %d", len(all))
+	}
+	got := summarizeTables(m, listTablesInput{Filter: "ORDER"}, stats)
+	if len(got) != 1 || got[0].Name != "order_items" {
+		t.Fatalf("filter mismatch: %+v", got)
+	}
+	if got[0].RowEstimate != 42 || got[0].Size != "16 kB" || got[0].Columns != 4 {
+		t.Fatalf("stats not merged: %+v", got[0])
+	}
+	if got[0].URI != "pgmcp://schema/public/order_items" {
+		t.Fatalf("unexpected uri %q", got[0].URI)
+	}
+	if got := summarizeTables(m, listTablesInput{Schema: "other"}, stats); len(got) != 0 {
+		t.Fatalf("schema filter ignored: %+v", got)
+	}
+}
+
+func TestDescribeTable(t *testing.T) {
+	m := testSchemaModel()
+	m.Tables = append(m.Tables, &Table{
+		Schema: "public", Name: "shipments",
+		Columns: []Column{
+			{Name: "order_id", Type: "integer", BaseType: "int4", NotNull: true, Position: 1},
+			{Name: "item_id", Type: "integer", BaseType: "int4", NotNull: true, Position: 2},
+		},
+	})
+
+	items := describeTable(m, m.Table("public", "order_items"), tableStats{RowEstimate: -1})
+	if len(items.ForeignKeys) != 1 || len(items.ReferencedBy) != 0 {
+		t.Fatalf("order_items keys mismatch: out=%v in=%v", items.ForeignKeys, items.ReferencedBy)
+	}
+	if len(items.Enums) != 1 || items.Enums[0].Name != "order_status" {
+		t.Fatalf("expected order_status enum, got %+v", items.Enums)
+	}
+
+	// Enum types are matched by schema, arrays of them included
+	m.Enums = append(m.Enums, EnumType{Schema: "sales", Name: "order_status", Labels: []string{"open"}})
+	m.Tables = append(m.Tables, &Table{Schema: "sales", Name: "orders", Columns: []Column{
+		{Name: "history", Type: "sales.order_status[]", BaseType: "_order_status", TypeSchema: "sales", Position: 1},
+	}})
+	if items := describeTable(m, m.Table("public", "order_items"), tableStats{}); len(items.Enums) != 1 || items.Enums[0].Schema != "public" {
+		t.Fatalf("expected only public.order_status, got %+v", items.Enums)
+	}
+	if orders := describeTable(m, m.Table("sales", "orders"), tableStats{}); len(orders.Enums) != 1 || orders.Enums[0].Schema != "sales" {
+		t.Fatalf("expected only sales.order_status, got %+v", orders.Enums)
+	}
+	if items.PrimaryKey == nil || len(items.Checks) != 1 || len(items.Indexes) != 1 {
+		t.Fatalf("constraints missing: %+v", items)
+	}
+
+	ship := describeTable(m, m.Table("public", "shipments"), tableStats{})
+	if len(ship.ReferencedBy) != 1 {
+		t.Fatalf("expected one inbound FK, got %+v", ship.ReferencedBy)
+	}
+	in := ship.ReferencedBy[0]
+	if in.Table != "order_items" || in.Name != "order_items_order_fk" || len(in.Columns) != 2 {
+		t.Fatalf("inbound FK mismatch: %+v", in)
+	}
+}
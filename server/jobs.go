@@ -0,0 +1,596 @@
+// server/jobs.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultJobWorkers      = 2
+	defaultJobMax          = 32
+	defaultJobMaxPerClient = 4
+	defaultJobTimeout      = 10 * time.Minute
+	defaultJobTTL          = 15 * time.Minute
+	defaultJobMaxRows      = 10000
+	jobCursor              = "pgmcp_job"
+	jobFetchSize           = 500
+	jobReapInterval        = 15 * time.Second
+	maxJobWait             = 20 * time.Second // stays under the HTTP write timeout
+)
+
+// Job states
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+var errJobNotFound = errors.New("job not found or expired")
+
+// job is a query run in the background by submit_query. Its result is kept
+// in memory until the TTL after it finishes.
+type job struct {
+	id        string
+	owner     string
+	question  string
+	sql       string
+	note      string
+	submitted time.Time
+	done      chan struct{} // closed when the job finishes
+
+	mu          sync.Mutex
+	state       string
+	started     time.Time
+	finished    time.Time
+	expires     time.Time // set when finished
+	err         string
+	columns     []columnInfo
+	rows        []map[string]any
+	truncated   bool // stopped at the row limit
+	replica     string
+	cancel      context.CancelFunc // set while running
+	cancelAsked bool
+}
+
+// jobStore queues jobs for a fixed set of workers and keeps their results.
+// max bounds the jobs held at once in any state, which also bounds the queue.
+type jobStore struct {
+	queue        chan *job
+	workers      int
+	max          int
+	maxPerClient int
+	timeout      time.Duration
+	ttl          time.Duration
+	maxRows      int
+
+	mu   sync.Mutex
+	jobs map[string]*job
+
+	submitted, succeeded, failed, cancelled atomic.Int64
+}
+
+func newJobStore(cfg Config) *jobStore {
+	js := &jobStore{
+		workers:      cfg.jobWorkers(),
+		max:          cfg.JobMax,
+		maxPerClient: cfg.JobMaxPerClient,
+		timeout:      cfg.JobTimeout,
+		ttl:          cfg.JobTTL,
+		maxRows:      cfg.JobMaxRows,
+		jobs:         map[string]*job{},
+	}
+	if js.max == 0 {
+		js.max = defaultJobMax
+	}
+	if js.maxPerClient == 0 {
+		js.maxPerClient = defaultJobMaxPerClient
+	}
+	if js.timeout == 0 {
+		js.timeout = defaultJobTimeout
+	}
+	if js.ttl == 0 {
+		js.ttl = defaultJobTTL
+	}
+	if js.maxRows == 0 {
+		js.maxRows = defaultJobMaxRows
+	}
+	js.queue = make(chan *job, js.max)
+	return js
+}
+
+// submit registers a job for owner and queues it.
+func (js *jobStore) submit(owner, question, sql, note string) (*job, error) {
+	idb := make([]byte, 16)
+	if _, err := rand.Read(idb); err != nil {
+		return nil, err
+	}
+	j := &job{
+		id: hex.EncodeToString(idb), owner: owner, question: question, sql: sql, note: note,
+		submitted: time.Now(), done: make(chan struct{}), state: jobQueued,
+	}
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if len(js.jobs) >= js.max {
+		return nil, &busyError{fmt.Sprintf("too many jobs on the server (max %d)", js.max), time.Minute}
+	}
+	n := 0
+	for _, o := range js.jobs {
+		if o.owner == owner {
+			n++
+		}
+	}
+	if n >= js.maxPerClient {
+		return nil, fmt.Errorf("too many jobs (max %d per client); collect or cancel_job one first", js.maxPerClient)
+	}
+	select {
+	case js.queue <- j:
+	default:
+		// Jobs cancelled while queued stay in the queue until a worker skips them
+		return nil, &busyError{"job queue is full", time.Minute}
+	}
+	js.jobs[j.id] = j
+	js.submitted.Add(1)
+	return j, nil
+}
+
+func (js *jobStore) get(id, owner string) (*job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.jobs[id]
+	if !ok || j.owner != owner {
+		return nil, errJobNotFound
+	}
+	return j, nil
+}
+
+func (js *jobStore) remove(id string) {
+	js.mu.Lock()
+	delete(js.jobs, id)
+	js.mu.Unlock()
+}
+
+// start moves a queued job to running. It reports false if the job was
+// cancelled while it waited.
+func (j *job) start(cancel context.CancelFunc) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state != jobQueued {
+		return false
+	}
+	j.state, j.started, j.cancel = jobRunning, time.Now(), cancel
+	return true
+}
+
+// finishLocked records the outcome and starts the result TTL.
+func (j *job) finishLocked(state, errMsg string, ttl time.Duration) {
+	j.state, j.err = state, errMsg
+	j.finished = time.Now()
+	j.expires = j.finished.Add(ttl)
+	j.cancel = nil
+	close(j.done)
+}
+
+// cancel stops a queued or running job and reports whether it did. A
+// running job finishes as cancelled once its query has been interrupted.
+func (js *jobStore) cancel(j *job) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch j.state {
+	case jobQueued:
+		j.cancelAsked = true
+		j.finishLocked(jobCancelled, "", js.ttl)
+		js.cancelled.Add(1)
+		auditLog("job_cancelled", j.owner, j.sql, fmt.Sprintf("job %s (queued)", j.id), false)
+		return true
+	case jobRunning:
+		j.cancelAsked = true
+		j.cancel()
+		return true
+	}
+	return false
+}
+
+// progress returns the rows read so far.
+func (j *job) progress() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.rows)
+}
+
+// wait blocks until j finishes, ctx is done or d elapses, calling progress
+// every second while it waits.
+func (j *job) wait(ctx context.Context, d time.Duration, progress func(fetched, total int), total int) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-tick.C:
+			if progress != nil {
+				progress(j.progress(), total)
+			}
+		}
+	}
+}
+
+// Run starts the workers and discards expired results until ctx is done.
+// Shutting down cancels the running jobs through ctx.
+func (js *jobStore) Run(ctx context.Context, exec func(ctx context.Context, j *job) error) {
+	for i := 0; i < js.workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case j := <-js.queue:
+					js.runOne(ctx, j, exec)
+				}
+			}
+		}()
+	}
+	t := time.NewTicker(jobReapInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			js.reap(now)
+		}
+	}
+}
+
+func (js *jobStore) runOne(ctx context.Context, j *job, exec func(ctx context.Context, j *job) error) {
+	jobCtx, cancel := context.WithTimeout(ctx, js.timeout)
+	defer cancel()
+	if !j.start(cancel) {
+		return
+	}
+	log.Debug().Str("job", j.id).Str("owner", j.owner).Msg("job started")
+	err := exec(jobCtx, j)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch {
+	case err == nil:
+		j.finishLocked(jobSucceeded, "", js.ttl)
+		js.succeeded.Add(1)
+		auditLogVia(j.replica, "job_succeeded", j.owner, j.question, fmt.Sprintf("job %s: %d rows in %s", j.id, len(j.rows), j.finished.Sub(j.started).Round(time.Millisecond)), true)
+	case j.cancelAsked || ctx.Err() != nil:
+		j.finishLocked(jobCancelled, "", js.ttl)
+		js.cancelled.Add(1)
+		auditLogVia(j.replica, "job_cancelled", j.owner, j.sql, fmt.Sprintf("job %s", j.id), false)
+	case errors.Is(jobCtx.Err(), context.DeadlineExceeded):
+		j.finishLocked(jobFailed, fmt.Sprintf("timed out after %s", js.timeout), js.ttl)
+		js.failed.Add(1)
+		auditLogVia(j.replica, "job_failed", j.owner, j.sql, j.err, false)
+	default:
+		j.finishLocked(jobFailed, err.Error(), js.ttl)
+		js.failed.Add(1)
+		auditLogVia(j.replica, "job_failed", j.owner, j.sql, j.err, false)
+	}
+	log.Debug().Str("job", j.id).Str("state", j.state).Int("row_count", len(j.rows)).Dur("dur", j.finished.Sub(j.started)).Msg("job finished")
+}
+
+// reap discards finished jobs past their TTL.
+func (js *jobStore) reap(now time.Time) int {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	n := 0
+	for id, j := range js.jobs {
+		j.mu.Lock()
+		expired := !j.expires.IsZero() && now.After(j.expires)
+		j.mu.Unlock()
+		if expired {
+			delete(js.jobs, id)
+			n++
+		}
+	}
+	return n
+}
+
+// jobStats is the jobs part of /stats.
+type jobStats struct {
+	Queued    int   `json:"queued"`
+	Running   int   `json:"running"`
+	Finished  int   `json:"finished"` // results held until their TTL
+	Workers   int   `json:"workers"`
+	Submitted int64 `json:"submitted"`
+	Succeeded int64 `json:"succeeded"`
+	Failed    int64 `json:"failed"`
+	Cancelled int64 `json:"cancelled"`
+}
+
+func (js *jobStore) stats() jobStats {
+	out := jobStats{
+		Workers:   js.workers,
+		Submitted: js.submitted.Load(),
+		Succeeded: js.succeeded.Load(),
+		Failed:    js.failed.Load(),
+		Cancelled: js.cancelled.Load(),
+	}
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for _, j := range js.jobs {
+		j.mu.Lock()
+		switch j.state {
+		case jobQueued:
+			out.Queued++
+		case jobRunning:
+			out.Running++
+		default:
+			out.Finished++
+		}
+		j.mu.Unlock()
+	}
+	return out
+}
+
+// runJob executes j.sql behind a cursor, reading up to the row limit in
+// batches so job_status can report progress.
+func (s *Server) runJob(ctx context.Context, j *job) error {
+	// Jobs share the pool with interactive queries, so wait for a slot
+	free, err := s.hold(ctx)
+	if err != nil {
+		return err
+	}
+	defer free()
+	db, replica := s.readPool()
+	j.mu.Lock()
+	j.replica = replica
+	j.mu.Unlock()
+
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if err := execStatement(ctx, tx, fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR %s", jobCursor, cursorSQL(j.sql))); err != nil {
+		return err
+	}
+	limit := s.jobs.maxRows
+	for fetched := 0; ; {
+		// One row past the limit tells us whether the result was cut short
+		n := min(jobFetchSize, limit+1-fetched)
+		rows, flds, err := fetchRows(ctx, tx, fmt.Sprintf("FETCH FORWARD %d FROM %s", n, jobCursor), n)
+		if err != nil {
+			return err
+		}
+		var cols []columnInfo
+		if fetched == 0 {
+			if cols, err = describeColumns(ctx, tx, flds); err != nil {
+				return err
+			}
+		}
+		truncated := fetched+len(rows) > limit
+		if truncated {
+			rows = rows[:limit-fetched]
+		}
+		fetched += len(rows)
+		j.mu.Lock()
+		if cols != nil {
+			j.columns = cols
+		}
+		j.rows = append(j.rows, rows...)
+		j.truncated = truncated
+		j.mu.Unlock()
+		if truncated || len(rows) < n {
+			break
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// ---------- submit_query / job_status / job_result / cancel_job tools ----------
+
+type submitQueryInput struct {
+	Query       string `json:"query"`
+	WaitSeconds int    `json:"wait_seconds,omitempty"` // wait this long for the job to finish, sending progress (max 20)
+}
+
+type jobIDInput struct {
+	JobID       string `json:"job_id"`
+	WaitSeconds int    `json:"wait_seconds,omitempty"` // job_status only: wait this long for the job to finish (max 20)
+}
+
+type jobStatusOutput struct {
+	JobID       string     `json:"job_id"`
+	Status      string     `json:"status"` // queued, running, succeeded, failed or cancelled
+	SQL         string     `json:"sql"`
+	Note        string     `json:"note,omitempty"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	RowsFetched int        `json:"rows_fetched"`
+	Truncated   bool       `json:"truncated,omitempty"` // the result stopped at the job row limit
+	Error       string     `json:"error,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // when a finished job and its result are discarded
+	Replica     string     `json:"replica,omitempty"`
+}
+
+func (j *job) status() jobStatusOutput {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := jobStatusOutput{
+		JobID: j.id, Status: j.state, SQL: j.sql, Note: j.note, SubmittedAt: j.submitted,
+		RowsFetched: len(j.rows), Truncated: j.truncated, Error: j.err, Replica: j.replica,
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		out.StartedAt = &started
+	}
+	if !j.finished.IsZero() {
+		finished, expires := j.finished, j.expires
+		out.FinishedAt, out.ExpiresAt = &finished, &expires
+	}
+	return out
+}
+
+func jobWait(seconds int) time.Duration {
+	return min(time.Duration(max(seconds, 0))*time.Second, maxJobWait)
+}
+
+func (s *Server) handleSubmitQuery(ctx context.Context, req *mcp.CallToolRequest, in submitQueryInput) (*mcp.CallToolResult, jobStatusOutput, error) {
+	start := time.Now()
+	ctx, stop := s.requests.withRequestCancel(ctx, req)
+	defer stop()
+	owner := requestIdentity(req)
+	log.Debug().Str("tool", "submit_query").Str("query", strings.TrimSpace(in.Query)).Int("wait_seconds", in.WaitSeconds).Str("identity", owner).Msg("request")
+
+	if err := sanitizeInput(in.Query); err != nil {
+		auditLog("submit_query_input_validation_failed", owner, in.Query, err.Error(), false)
+		return nil, jobStatusOutput{}, err
+	}
+	schemaTxt, err := s.promptSchema(ctx, in.Query)
+	if err != nil {
+		log.Debug().Str("tool", "submit_query").Err(err).Msg("schema load failed")
+		return nil, jobStatusOutput{}, err
+	}
+	sql, note, err := s.generateSQL(ctx, in.Query, schemaTxt, s.jobs.maxRows)
+	if err != nil {
+		auditFailure(ctx, "submit_query", "submit_query_sql_generation_failed", owner, in.Query, err)
+		return nil, jobStatusOutput{}, err
+	}
+	if err := guardReadOnly(sql); err != nil {
+		auditLog("submit_query_guard_failed", owner, sql, err.Error(), false)
+		return nil, jobStatusOutput{SQL: sql}, err
+	}
+
+	j, err := s.jobs.submit(owner, in.Query, sql, note)
+	if err != nil {
+		auditLog("submit_query_rejected", owner, in.Query, err.Error(), false)
+		return nil, jobStatusOutput{SQL: sql}, err
+	}
+	auditLog("job_submitted", owner, in.Query, "job "+j.id, true)
+
+	j.wait(ctx, jobWait(in.WaitSeconds), progressFunc(ctx, req, "submit_query"), s.jobs.maxRows)
+	out := j.status()
+	log.Debug().Str("tool", "submit_query").Str("job", j.id).Str("status", out.Status).Dur("dur", time.Since(start)).Msg("done")
+	return nil, out, nil
+}
+
+func (s *Server) handleJobStatus(ctx context.Context, req *mcp.CallToolRequest, in jobIDInput) (*mcp.CallToolResult, jobStatusOutput, error) {
+	ctx, stop := s.requests.withRequestCancel(ctx, req)
+	defer stop()
+	owner := requestIdentity(req)
+	log.Debug().Str("tool", "job_status").Str("job", in.JobID).Int("wait_seconds", in.WaitSeconds).Str("identity", owner).Msg("request")
+
+	j, err := s.jobs.get(in.JobID, owner)
+	if err != nil {
+		return nil, jobStatusOutput{}, err
+	}
+	j.wait(ctx, jobWait(in.WaitSeconds), progressFunc(ctx, req, "job_status"), s.jobs.maxRows)
+	return nil, j.status(), nil
+}
+
+type jobResultInput struct {
+	JobID   string `json:"job_id"`
+	Offset  int    `json:"offset,omitempty"`   // first row to return
+	MaxRows int    `json:"max_rows,omitempty"` // rows to return (default 50)
+}
+
+type jobResultOutput struct {
+	JobID      string           `json:"job_id"`
+	Columns    []columnInfo     `json:"columns,omitempty"`
+	Rows       []map[string]any `json:"rows"`
+	Offset     int              `json:"offset"`
+	TotalRows  int              `json:"total_rows"`
+	HasMore    bool             `json:"has_more"`
+	NextOffset int              `json:"next_offset,omitempty"` // pass as offset for the following rows
+	Truncated  bool             `json:"truncated,omitempty"`   // the result stopped at the job row limit
+	ExpiresAt  *time.Time       `json:"expires_at,omitempty"`
+	Replica    string           `json:"replica,omitempty"`
+}
+
+func (s *Server) handleJobResult(ctx context.Context, req *mcp.CallToolRequest, in jobResultInput) (*mcp.CallToolResult, jobResultOutput, error) {
+	owner := requestIdentity(req)
+	log.Debug().Str("tool", "job_result").Str("job", in.JobID).Int("offset", in.Offset).Int("max_rows", in.MaxRows).Str("identity", owner).Msg("request")
+
+	j, err := s.jobs.get(in.JobID, owner)
+	if err != nil {
+		return nil, jobResultOutput{}, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch j.state {
+	case jobSucceeded:
+	case jobFailed:
+		return nil, jobResultOutput{JobID: j.id}, fmt.Errorf("job failed: %s", j.err)
+	case jobCancelled:
+		return nil, jobResultOutput{JobID: j.id}, errors.New("job was cancelled")
+	default:
+		return nil, jobResultOutput{JobID: j.id}, fmt.Errorf("job is %s; check job_status", j.state)
+	}
+	n := pageSize
+	if in.MaxRows > 0 {
+		n = min(in.MaxRows, s.cfg.MaxRows)
+	}
+	from := min(max(in.Offset, 0), len(j.rows))
+	to := min(from+n, len(j.rows))
+	expires := j.expires
+	out := jobResultOutput{
+		JobID: j.id, Columns: j.columns, Rows: j.rows[from:to], Offset: from, TotalRows: len(j.rows),
+		HasMore: to < len(j.rows), Truncated: j.truncated, ExpiresAt: &expires, Replica: j.replica,
+	}
+	if out.HasMore {
+		out.NextOffset = to
+	}
+	auditLogVia(j.replica, "job_result", owner, j.id, fmt.Sprintf("returned rows %d-%d of %d", from, to, len(j.rows)), true)
+	return nil, out, nil
+}
+
+type cancelJobOutput struct {
+	JobID     string `json:"job_id"`
+	Status    string `json:"status,omitempty"`
+	Cancelled bool   `json:"cancelled"` // a queued or running job was stopped
+	Discarded bool   `json:"discarded"` // a finished job's result was dropped
+}
+
+func (s *Server) handleCancelJob(ctx context.Context, req *mcp.CallToolRequest, in jobIDInput) (*mcp.CallToolResult, cancelJobOutput, error) {
+	owner := requestIdentity(req)
+	log.Debug().Str("tool", "cancel_job").Str("job", in.JobID).Str("identity", owner).Msg("request")
+
+	j, err := s.jobs.get(in.JobID, owner)
+	if err != nil {
+		// Already gone (discarded or expired) - nothing to stop
+		return nil, cancelJobOutput{JobID: in.JobID}, nil
+	}
+	if s.jobs.cancel(j) {
+		// A running job's worker records the outcome once the query stops
+		j.wait(ctx, 5*time.Second, nil, 0)
+		return nil, cancelJobOutput{JobID: j.id, Status: j.status().Status, Cancelled: true}, nil
+	}
+	s.jobs.remove(j.id)
+	auditLog("job_discarded", owner, j.id, "result discarded", true)
+	return nil, cancelJobOutput{JobID: j.id, Discarded: true}, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobStoreLimits(t *testing.T) {
+	js := newJobStore(Config{JobMax: 3, JobMaxPerClient: 2})
+
+	if _, err := js.submit("alice", "q", "SELECT 1", ""); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	a2, err := js.submit("alice", "q", "SELECT 2", "")
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if _, err := js.submit("alice", "q", "SELECT 3", ""); err == nil || !strings.Contains(err.Error(), "per client") {
+		t.Fatalf("expected per-client limit, got %v", err)
+	}
+	if _, err := js.submit("bob", "q", "SELECT 1", ""); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	var busy *busyError
+	if _, err := js.submit("carol", "q", "SELECT 1", ""); !errors.As(err, &busy) {
+		t.Fatalf("expected server-wide limit, got %v", err)
+	}
+
+	// Jobs are private to their owner
+	if _, err := js.get(a2.id, "bob"); err != errJobNotFound {
+		t.Fatalf("bob should not see alice's job, got %v", err)
+	}
+	if j, err := js.get(a2.id, "alice"); err != nil || j != a2 {
+		t.Fatalf("get: %v", err)
+	}
+	if st := js.stats(); st.Queued != 3 || st.Submitted != 3 || st.Workers != defaultJobWorkers {
+		t.Fatalf("stats = %+v", st)
+	}
+}
+
+func TestJobStoreRun(t *testing.T) {
+	js := newJobStore(Config{JobWorkers: 1, JobTimeout: 100 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	go js.Run(ctx, func(ctx context.Context, j *job) error {
+		switch j.sql {
+		case "block":
+			<-release
+		case "sleep":
+			<-ctx.Done()
+			return ctx.Err()
+		case "fail":
+			return errors.New("boom")
+		}
+		j.mu.Lock()
+		j.rows = append(j.rows, map[string]any{"n": 1})
+		j.mu.Unlock()
+		return nil
+	})
+
+	// The single worker is busy, so the second job waits and can be cancelled
+	blocker, _ := js.submit("alice", "q", "block", "")
+	waitFor(t, func() bool { return blocker.status().Status == jobRunning })
+	queued, _ := js.submit("alice", "q", "ok", "")
+	if !js.cancel(queued) || queued.status().Status != jobCancelled {
+		t.Fatalf("queued job should cancel at once, got %s", queued.status().Status)
+	}
+	close(release)
+	blocker.wait(ctx, time.Second, nil, 0)
+	if st := blocker.status(); st.Status != jobSucceeded || st.RowsFetched != 1 || st.ExpiresAt == nil {
+		t.Fatalf("blocker = %+v", st)
+	}
+
+	failed, _ := js.submit("bob", "q", "fail", "")
+	failed.wait(ctx, time.Second, nil, 0)
+	if st := failed.status(); st.Status != jobFailed || st.Error != "boom" {
+		t.Fatalf("failed = %+v", st)
+	}
+
+	slow, _ := js.submit("bob", "q", "sleep", "")
+	slow.wait(ctx, time.Second, nil, 0)
+	if st := slow.status(); st.Status != jobFailed || !strings.Contains(st.Error, "timed out") {
+		t.Fatalf("slow = %+v", st)
+	}
+
+	// Finished jobs are discarded once their results expire
+	if n := js.reap(time.Now().Add(defaultJobTTL + time.Second)); n != 4 {
+		t.Fatalf("reaped %d jobs", n)
+	}
+	if st := js.stats(); st.Finished != 0 || st.Succeeded != 1 || st.Failed != 2 || st.Cancelled != 1 {
+		t.Fatalf("stats = %+v", st)
+	}
+}
+
+func TestJobWait(t *testing.T) {
+	if got := jobWait(-5); got != 0 {
+		t.Fatalf("negative wait = %v", got)
+	}
+	if got := jobWait(3); got != 3*time.Second {
+		t.Fatalf("wait = %v", got)
+	}
+	if got := jobWait(600); got != maxJobWait {
+		t.Fatalf("wait should be capped, got %v", got)
+	}
+}
@@ -0,0 +1,350 @@
+// server/joins.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/rs/zerolog/log"
+)
+
+const maxHintTables = 5 // cap on tables considered for join hints (pairs grow quadratically)
+
+// JoinStep is one hop along a foreign key. Composite keys stay in a single
+// step, with every column pair ANDed together in On. On refers to the
+// tables by alias, so a table may appear more than once in a path.
+type JoinStep struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Alias      string `json:"alias"` // alias of To
+	On         string `json:"on"`
+	Constraint string `json:"constraint"`
+}
+
+// JoinPath is the shortest foreign key path between two tables. From is
+// aliased t0 and the table each step joins t1, t2 and so on.
+type JoinPath struct {
+	From  string     `json:"from"`
+	To    string     `json:"to"`
+	Steps []JoinStep `json:"steps"`
+}
+
+// SQL renders the path as a FROM/JOIN clause.
+func (p JoinPath) SQL() string {
+	var b strings.Builder
+	b.WriteString("FROM " + p.From + " " + joinAlias(0))
+	for _, s := range p.Steps {
+		b.WriteString(" JOIN " + s.To + " " + s.Alias + " ON " + s.On)
+	}
+	return b.String()
+}
+
+// joinAlias names the i-th table of a path.
+func joinAlias(i int) string {
+	return fmt.Sprintf("t%d", i)
+}
+
+type fkEdge struct {
+	to   string
+	fk   ForeignKey
+	from *Table // table that owns the foreign key
+	ref  *Table // table the foreign key references
+}
+
+// fkGraph is an undirected graph of tables connected by foreign keys.
+type fkGraph struct {
+	tables map[string]*Table
+	adj    map[string][]fkEdge
+}
+
+func buildFKGraph(m *SchemaModel) *fkGraph {
+	g := &fkGraph{tables: map[string]*Table{}, adj: map[string][]fkEdge{}}
+	for _, t := range m.Tables {
+		g.tables[t.QualifiedName()] = t
+	}
+	for _, t := range m.Tables {
+		for _, fk := range t.ForeignKeys {
+			ref := m.Table(fk.RefSchema, fk.RefTable)
+			if ref == nil {
+				continue
+			}
+			src, dst := t.QualifiedName(), ref.QualifiedName()
+			g.adj[src] = append(g.adj[src], fkEdge{to: dst, fk: fk, from: t, ref: ref})
+			if src != dst {
+				g.adj[dst] = append(g.adj[dst], fkEdge{to: src, fk: fk, from: t, ref: ref})
+			}
+		}
+	}
+	// Deterministic traversal order so equal-length paths are stable
+	for k := range g.adj {
+		edges := g.adj[k]
+		sort.SliceStable(edges, func(i, j int) bool {
+			if edges[i].to != edges[j].to {
+				return edges[i].to < edges[j].to
+			}
+			return edges[i].fk.Name < edges[j].fk.Name
+		})
+	}
+	return g
+}
+
+// joinCondition renders the ON clause for an edge taken from the table
+// aliased prev to the one aliased next, keeping composite keys together.
+// The foreign key is read from prev's side unless the edge was taken
+// against its direction.
+func joinCondition(e fkEdge, prev, next string) string {
+	src, dst := prev, next
+	if e.ref.QualifiedName() != e.to {
+		src, dst = next, prev
+	}
+	conds := make([]string, len(e.fk.Columns))
+	for i := range e.fk.Columns {
+		conds[i] = fmt.Sprintf("%s.%s = %s.%s", src, quoteIdent(e.fk.Columns[i]), dst, quoteIdent(e.fk.RefColumns[i]))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// joinStep is edge e taken as the i-th hop of a path, from prev.
+func joinStep(e fkEdge, prev string, i int) JoinStep {
+	return JoinStep{
+		From: prev, To: e.to, Alias: joinAlias(i + 1),
+		On: joinCondition(e, joinAlias(i), joinAlias(i+1)), Constraint: e.fk.Name,
+	}
+}
+
+// shortestPath runs a breadth-first search between two qualified table
+// names. A table is joined to itself through its first self-referencing
+// foreign key, if it has one.
+func (g *fkGraph) shortestPath(from, to string) (JoinPath, bool) {
+	path := JoinPath{From: from, To: to}
+	if from == to {
+		for _, e := range g.adj[from] {
+			if e.to == from {
+				path.Steps = []JoinStep{joinStep(e, from, 0)}
+				break
+			}
+		}
+		return path, g.tables[from] != nil
+	}
+	type hop struct {
+		prev string
+		edge fkEdge
+	}
+	visited := map[string]hop{from: {}}
+	queue := []string{from}
+	for len(queue) > 0 && visited[to].prev == "" {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, e := range g.adj[cur] {
+			if _, seen := visited[e.to]; seen {
+				continue
+			}
+			visited[e.to] = hop{prev: cur, edge: e}
+			queue = append(queue, e.to)
+		}
+	}
+	if _, ok := visited[to]; !ok {
+		return path, false
+	}
+	var edges []fkEdge
+	for cur := to; cur != from; cur = visited[cur].prev {
+		edges = append(edges, visited[cur].edge)
+	}
+	slices.Reverse(edges)
+	prev := from
+	for i, e := range edges {
+		path.Steps = append(path.Steps, joinStep(e, prev, i))
+		prev = e.to
+	}
+	return path, true
+}
+
+// joinPaths returns the shortest path for every connected pair of tables.
+func (g *fkGraph) joinPaths(tables []string) []JoinPath {
+	var out []JoinPath
+	for i := 0; i < len(tables); i++ {
+		for j := i + 1; j < len(tables); j++ {
+			if p, ok := g.shortestPath(tables[i], tables[j]); ok && len(p.Steps) > 0 {
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
+
+// singular strips common English plural endings for loose name matching.
+func singular(w string) string {
+	switch {
+	case strings.HasSuffix(w, "ies") && len(w) > 4:
+		return w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "sses"), strings.HasSuffix(w, "xes"), strings.HasSuffix(w, "ches"), strings.HasSuffix(w, "shes"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") && len(w) > 3:
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// mentionedTables returns the tables a question refers to by name, matching
+// singular/plural forms and multi-word names ("order items" -> order_items).
+// The longest match wins, so "order items" does not also mention items.
+func mentionedTables(m *SchemaModel, question string) []*Table {
+	byForm := map[string][]*Table{}
+	for _, t := range m.Tables {
+		f := singular(strings.ToLower(t.Name))
+		byForm[f] = append(byForm[f], t)
+	}
+	words := strings.FieldsFunc(strings.ToLower(question), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	seen := map[*Table]bool{}
+	var out []*Table
+	for i := 0; i < len(words); {
+		n := 3
+		for ; n > 0; n-- {
+			if i+n > len(words) {
+				continue
+			}
+			w := strings.Join(words[i:i+n], "_")
+			if len(w) < 3 || len(byForm[singular(w)]) == 0 {
+				continue
+			}
+			for _, t := range byForm[singular(w)] {
+				if !seen[t] {
+					seen[t] = true
+					out = append(out, t)
+				}
+			}
+			break
+		}
+		i += max(n, 1)
+	}
+	return out
+}
+
+// joinHints renders shortest FK paths between the tables a question mentions,
+// or "" when fewer than two connected tables are mentioned.
+func joinHints(m *SchemaModel, question string) string {
+	mentioned := mentionedTables(m, question)
+	if len(mentioned) < 2 {
+		return ""
+	}
+	if len(mentioned) > maxHintTables {
+		mentioned = mentioned[:maxHintTables]
+	}
+	names := make([]string, len(mentioned))
+	for i, t := range mentioned {
+		names[i] = t.QualifiedName()
+	}
+	paths := buildFKGraph(m).joinPaths(names)
+	if len(paths) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("JOIN HINTS (shortest foreign key paths between tables in the question):\n")
+	for _, p := range paths {
+		fmt.Fprintf(&b, "- %s -> %s: %s\n", p.From, p.To, p.SQL())
+	}
+	return b.String()
+}
+
+// promptSchema returns the cached schema summary followed by join hints for the question.
+func (s *Server) promptSchema(ctx context.Context, question string) (string, error) {
+	model, txt, err := s.cache.get(ctx, s.db)
+	if err != nil {
+		return "", err
+	}
+	if hints := joinHints(model, question); hints != "" {
+		txt += "\n" + hints
+	}
+	return txt, nil
+}
+
+// resolveTable finds a table by "schema.table" or by bare (case-insensitive) name.
+func resolveTable(m *SchemaModel, name string) (*Table, error) {
+	name = strings.TrimSpace(name)
+	if schema, table, ok := strings.Cut(name, "."); ok {
+		schema, table = strings.Trim(schema, `"`), strings.Trim(table, `"`)
+		if t := m.Table(schema, table); t != nil {
+			return t, nil
+		}
+		return nil, fmt.Errorf("table %q not found", name)
+	}
+	bare := strings.Trim(name, `"`)
+	var exact, folded []*Table
+	for _, t := range m.Tables {
+		if t.Name == bare {
+			exact = append(exact, t)
+		} else if strings.EqualFold(t.Name, bare) {
+			folded = append(folded, t)
+		}
+	}
+	matches := exact
+	if len(matches) == 0 {
+		matches = folded
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("table %q not found", name)
+	case 1:
+		return matches[0], nil
+	}
+	return nil, fmt.Errorf("table name %q is ambiguous; qualify it with a schema", name)
+}
+
+// ---------- find_join_path tool ----------
+
+type findJoinPathInput struct {
+	Tables []string `json:"tables"` // two or more table names, optionally schema-qualified
+}
+
+type findJoinPathOutput struct {
+	Paths        []JoinPath `json:"paths"`
+	Disconnected [][]string `json:"disconnected,omitempty"`
+}
+
+func (s *Server) handleFindJoinPath(ctx context.Context, req *mcp.CallToolRequest, in findJoinPathInput) (*mcp.CallToolResult, findJoinPathOutput, error) {
+	start := time.Now()
+	log.Debug().Str("tool", "find_join_path").Strs("tables", in.Tables).Msg("request")
+
+	if len(in.Tables) < 2 {
+		return nil, findJoinPathOutput{}, errors.New("at least two tables are required")
+	}
+	if len(in.Tables) > 10 {
+		return nil, findJoinPathOutput{}, errors.New("at most 10 tables are supported")
+	}
+	model, err := s.cache.Model(ctx, s.db)
+	if err != nil {
+		return nil, findJoinPathOutput{}, err
+	}
+	names := make([]string, len(in.Tables))
+	for i, n := range in.Tables {
+		t, err := resolveTable(model, n)
+		if err != nil {
+			return nil, findJoinPathOutput{}, err
+		}
+		names[i] = t.QualifiedName()
+	}
+
+	g := buildFKGraph(model)
+	out := findJoinPathOutput{Paths: []JoinPath{}}
+	for i := 0; i < len(names); i++ {
+		for j := i + 1; j < len(names); j++ {
+			p, ok := g.shortestPath(names[i], names[j])
+			if !ok {
+				out.Disconnected = append(out.Disconnected, []string{names[i], names[j]})
+				continue
+			}
+			out.Paths = append(out.Paths, p)
+		}
+	}
+	log.Debug().Str("tool", "find_join_path").Int("paths", len(out.Paths)).Dur("dur", time.Since(start)).Msg("done")
+	return nil, out, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// joinTestModel is a small shop schema: users <- orders <- order_items -> items,
+// with order_items also referencing shipments through a composite key.
+func joinTestModel() *SchemaModel {
+	fk := func(name string, cols []string, ref string, refCols []string) ForeignKey {
+		return ForeignKey{Name: name, Columns: cols, RefSchema: "public", RefTable: ref, RefColumns: refCols}
+	}
+	return &SchemaModel{Tables: []*Table{
+		{Schema: "public", Name: "users"},
+		{Schema: "public", Name: "orders", ForeignKeys: []ForeignKey{
+			fk("orders_user_id_fkey", []string{"user_id"}, "users", []string{"id"}),
+		}},
+		{Schema: "public", Name: "items"},
+		{Schema: "public", Name: "order_items", ForeignKeys: []ForeignKey{
+			fk("order_items_order_id_fkey", []string{"order_id"}, "orders", []string{"id"}),
+			fk("order_items_item_id_fkey", []string{"item_id"}, "items", []string{"id"}),
+		}},
+		{Schema: "public", Name: "shipments", ForeignKeys: []ForeignKey{
+			fk("shipments_line_fkey", []string{"order_id", "item_id"}, "order_items", []string{"order_id", "item_id"}),
+		}},
+		{Schema: "public", Name: "Categories", ForeignKeys: []ForeignKey{
+			fk("categories_parent_fkey", []string{"parent_id"}, "Categories", []string{"id"}),
+		}},
+		{Schema: "public", Name: "isolated"},
+	}}
+}
+
+func TestShortestJoinPath(t *testing.T) {
+	g := buildFKGraph(joinTestModel())
+
+	p, ok := g.shortestPath("public.users", "public.items")
+	if !ok {
+		t.Fatalf("expected a path from users to items")
+	}
+	if len(p.Steps) != 3 {
+		t.Fatalf("expected 3 hops users->orders->order_items->items, got %+v", p.Steps)
+	}
+	want := "FROM public.users t0 JOIN public.orders t1 ON t1.user_id = t0.id " +
+		"JOIN public.order_items t2 ON t2.order_id = t1.id " +
+		"JOIN public.items t3 ON t2.item_id = t3.id"
+	if got := p.SQL(); got != want {
+		t.Fatalf("path SQL:\n got %s\nwant %s", got, want)
+	}
+
+	if _, ok := g.shortestPath("public.users", "public.isolated"); ok {
+		t.Fatalf("expected no path to an isolated table")
+	}
+}
+
+func TestJoinPathCompositeKey(t *testing.T) {
+	g := buildFKGraph(joinTestModel())
+	p, ok := g.shortestPath("public.shipments", "public.order_items")
+	if !ok || len(p.Steps) != 1 {
+		t.Fatalf("expected a single composite hop, got %+v ok=%v", p, ok)
+	}
+	want := "t0.order_id = t1.order_id AND t0.item_id = t1.item_id"
+	if p.Steps[0].On != want {
+		t.Fatalf("composite join condition:\n got %s\nwant %s", p.Steps[0].On, want)
+	}
+}
+
+func TestJoinPathSelfReference(t *testing.T) {
+	g := buildFKGraph(joinTestModel())
+	p, ok := g.shortestPath(`public."Categories"`, `public."Categories"`)
+	if !ok || len(p.Steps) != 1 {
+		t.Fatalf("expected a single self-join, got %+v ok=%v", p, ok)
+	}
+	want := `FROM public."Categories" t0 JOIN public."Categories" t1 ON t0.parent_id = t1.id`
+	if got := p.SQL(); got != want {
+		t.Fatalf("self-join SQL:\n got %s\nwant %s", got, want)
+	}
+	if p, ok := g.shortestPath("public.users", "public.users"); !ok || len(p.Steps) != 0 {
+		t.Fatalf("a table without a self reference needs no join, got %+v", p)
+	}
+}
+
+func TestJoinPathSameNameAcrossSchemas(t *testing.T) {
+	m := joinTestModel()
+	m.Tables = append(m.Tables, &Table{Schema: "sales", Name: "orders", ForeignKeys: []ForeignKey{
+		{Name: "sales_orders_order_id_fkey", Columns: []string{"order_id"}, RefSchema: "public", RefTable: "orders", RefColumns: []string{"id"}},
+	}})
+	p, ok := buildFKGraph(m).shortestPath("public.orders", "sales.orders")
+	if !ok || len(p.Steps) != 1 {
+		t.Fatalf("expected a single hop, got %+v ok=%v", p, ok)
+	}
+	want := "FROM public.orders t0 JOIN sales.orders t1 ON t1.order_id = t0.id"
+	if got := p.SQL(); got != want {
+		t.Fatalf("path SQL:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestMentionedTables(t *testing.T) {
+	m := joinTestModel()
+	names := func(q string) string {
+		var out []string
+		for _, tbl := range mentionedTables(m, q) {
+			out = append(out, tbl.Name)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if got := names("Which user placed the most orders?"); got != "users,orders" {
+		t.Fatalf("got %q", got)
+	}
+	if got := names("total order items per category"); got != "order_items,Categories" {
+		t.Fatalf("got %q", got)
+	}
+	if got := names("how is the weather"); got != "" {
+		t.Fatalf("expected no tables, got %q", got)
+	}
+}
+
+func TestJoinHints(t *testing.T) {
+	hints := joinHints(joinTestModel(), "Which users bought which items?")
+	if !strings.HasPrefix(hints, "JOIN HINTS") {
+		t.Fatalf("expected join hints, got %q", hints)
+	}
+	if !strings.Contains(hints, "JOIN public.order_items t2 ON t2.order_id = t1.id") {
+		t.Fatalf("hints missing intermediate join:\n%s", hints)
+	}
+	if got := joinHints(joinTestModel(), "How many users are there?"); got != "" {
+		t.Fatalf("single-table question should have no hints, got %q", got)
+	}
+}
+
+func TestResolveTable(t *testing.T) {
+	m := joinTestModel()
+	m.Tables = append(m.Tables, &Table{Schema: "sales", Name: "orders"})
+
+	if tbl, err := resolveTable(m, "categories"); err != nil || tbl.Name != "Categories" {
+		t.Fatalf("case-insensitive lookup failed: %v %v", tbl, err)
+	}
+	if tbl, err := resolveTable(m, "sales.orders"); err != nil || tbl.Schema != "sales" {
+		t.Fatalf("qualified lookup failed: %v %v", tbl, err)
+	}
+	if _, err := resolveTable(m, "orders"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Fatalf("expected ambiguity error, got %v", err)
+	}
+	if _, err := resolveTable(m, "nope"); err == nil {
+		t.Fatalf("expected not-found error")
+	}
+}
+
+func TestHandleFindJoinPath(t *testing.T) {
+	srv := serverWithModel(joinTestModel())
+	_, out, err := srv.handleFindJoinPath(context.Background(), nil, findJoinPathInput{Tables: []string{"users", "items", "isolated"}})
+	if err != nil {
+		t.Fatalf("handleFindJoinPath: %v", err)
+	}
+	if len(out.Paths) != 1 || out.Paths[0].From != "public.users" || out.Paths[0].To != "public.items" {
+		t.Fatalf("unexpected paths: %+v", out.Paths)
+	}
+	if len(out.Disconnected) != 2 {
+		t.Fatalf("expected isolated table to be reported twice, got %v", out.Disconnected)
+	}
+
+	if _, _, err := srv.handleFindJoinPath(context.Background(), nil, findJoinPathInput{Tables: []string{"users"}}); err == nil {
+		t.Fatalf("expected error for a single table")
+	}
+}
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"flag"
@@ -10,12 +11,13 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 	openai "github.com/openai/openai-go/v2"
@@ -33,23 +35,42 @@ var (
 
 const (
 	defaultSchemaTTL    = 5 * time.Minute
+	defaultSchemaPoll   = 15 * time.Second
 	defaultQueryTimeout = 25 * time.Second
 	defaultMaxRows      = 200
 	maxModelTokens      = 2000
 	schemaMaxChars      = 18000
 	maxRequestSize      = 1024 * 1024 // 1MB max request size
 	maxQueryLength      = 10000       // Max query length in characters
-	pageSize            = 50          // Default page size for pagination
-	maxPagesAuto        = 10          // Max pages to auto-fetch
+	defaultMinConns     = 2
+	defaultMaxConns     = 8
+	defaultConnLifetime = 30 * time.Minute
+	defaultConnIdleTime = 5 * time.Minute
+	defaultHealthCheck  = 30 * time.Second
+	defaultExecMode     = "exec"
+	pageSize            = 50 // Default page size for pagination
+	maxPagesAuto        = 10 // Max pages to auto-fetch
 )
 
 type Server struct {
-	db     *pgxpool.Pool
-	llm    openai.Client // value type
-	model  string
-	cache  *SchemaCache
-	cfg    Config
-	server *http.Server
+	db          *pgxpool.Pool
+	llm         openai.Client // value type
+	model       string
+	cache       *SchemaCache
+	pages       *pageSigner
+	results     *resultStore
+	replicas    *replicaSet   // nil without REPLICA_URLS
+	admission   *admission    // nil admits everything
+	resultCache *resultCache  // nil when RESULT_CACHE_TTL is 0
+	jobs        *jobStore     // submit_query jobs
+	embedder    embedder      // semantic_search query embeddings
+	requests    *httpRequests // in-flight HTTP requests, for disconnect cancellation
+	cfg         Config
+	server      *http.Server
+
+	// bg scopes background workers; stopBG cancels it on shutdown.
+	bg     context.Context
+	stopBG context.CancelFunc
 }
 
 type Config struct {
@@ -58,8 +79,90 @@ type Config struct {
 	OpenAIModel string
 	OpenAIBase  string
 	SchemaTTL   time.Duration
+	SchemaPoll  time.Duration // catalog change check interval; 0 disables
 	QueryTO     time.Duration
 	MaxRows     int
+
+	// Include/exclude patterns for schemas and tables (globs, or /regex/)
+	SchemaInclude []string
+	SchemaExclude []string
+	TableInclude  []string
+	TableExclude  []string
+
+	PaginationSecret string // HMAC key for continuation tokens; random per process if empty
+
+	// Materialized result handles (see results.go); zero means the default
+	ResultTTL          time.Duration
+	ResultMaxPerClient int
+	ResultMax          int
+
+	// Read replicas for ask/stream/search (see replicas.go)
+	ReplicaURLs   []string
+	ReplicaMaxLag time.Duration // zero means the default
+
+	// Connection pool, applied to the primary and each replica; zero means the default
+	DBMinConns          int
+	DBMaxConns          int
+	DBMaxConnLifetime   time.Duration
+	DBMaxConnIdleTime   time.Duration
+	DBHealthCheckPeriod time.Duration
+	DBQueryExecMode     string // a key of queryExecModes
+
+	// Admission control for query tools (see admission.go); zero means the default
+	AdmitMaxConcurrent int
+	AdmitMaxPerClient  int
+	AdmitQueue         int
+	AdmitWait          time.Duration
+
+	// Result cache for ask, stream and search (see resultcache.go); a zero TTL disables it
+	ResultCacheTTL          time.Duration
+	ResultCacheSize         int
+	ResultCacheTableTTLs    []string // pattern=duration
+	ResultCacheScope        string   // client or shared
+	ResultCacheInvalidation string   // ttl, stats or notify
+	ResultCacheChannel      string   // NOTIFY channel for notify invalidation
+
+	// Asynchronous query jobs (see jobs.go); zero means the default
+	JobWorkers      int
+	JobMax          int
+	JobMaxPerClient int
+	JobTimeout      time.Duration
+	JobTTL          time.Duration
+	JobMaxRows      int
+
+	SearchFTSConfig      string        // text search configuration for fulltext search; empty means english
+	SearchFuzzyThreshold float64       // pg_trgm similarity threshold for fuzzy search; zero means the default
+	SearchWorkers        int           // tables searched at once, each on its own connection; zero means the default
+	SearchBudget         time.Duration // time allowed for a whole search; zero means the default
+
+	EmbeddingProvider string // openai or stub; empty means openai
+	EmbeddingModel    string // empty means text-embedding-3-small
+}
+
+// maxConns is the configured pool size.
+func (c *Config) maxConns() int {
+	if c.DBMaxConns > 0 {
+		return c.DBMaxConns
+	}
+	return defaultMaxConns
+}
+
+// jobWorkers is how many query jobs may run at once.
+func (c *Config) jobWorkers() int {
+	if c.JobWorkers > 0 {
+		return c.JobWorkers
+	}
+	return defaultJobWorkers
+}
+
+// admitMaxConcurrent is how many pool connections queries may hold at
+// once, the whole pool by default. Open result handles and running jobs
+// hold slots too, so they only reduce it while in use.
+func (c *Config) admitMaxConcurrent() int {
+	if c.AdmitMaxConcurrent > 0 {
+		return c.AdmitMaxConcurrent
+	}
+	return c.maxConns()
 }
 
 // Validate checks if the configuration is valid and returns detailed errors
@@ -88,128 +191,96 @@ func (c *Config) Validate() error {
 		errs = append(errs, "SCHEMA_TTL cannot exceed 24 hours")
 	}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("configuration validation failed:\n  - %s", strings.Join(errs, "\n  - "))
+	if c.SchemaPoll < 0 || (c.SchemaPoll > 0 && c.SchemaPoll < time.Second) {
+		errs = append(errs, "SCHEMA_POLL_INTERVAL must be 0 (disabled) or at least 1 second")
 	}
 
-	return nil
-}
+	if c.PaginationSecret != "" && len(c.PaginationSecret) < minSecretLen {
+		errs = append(errs, fmt.Sprintf("PAGINATION_SECRET must be at least %d characters", minSecretLen))
+	}
 
-type SchemaCache struct {
-	mu        sync.RWMutex
-	txt       string
-	expiresAt time.Time
-	ttl       time.Duration
-}
+	if c.ResultTTL != 0 && (c.ResultTTL < 10*time.Second || c.ResultTTL > time.Hour) {
+		errs = append(errs, "RESULT_HANDLE_TTL must be between 10 seconds and 1 hour")
+	}
+	if c.DBMinConns < 0 || c.DBMaxConns < 0 {
+		errs = append(errs, "DB_MIN_CONNS and DB_MAX_CONNS cannot be negative")
+	} else if c.DBMinConns > c.maxConns() {
+		errs = append(errs, fmt.Sprintf("DB_MIN_CONNS cannot exceed DB_MAX_CONNS (%d)", c.maxConns()))
+	}
+	if c.DBMaxConnLifetime < 0 || c.DBMaxConnIdleTime < 0 || c.DBHealthCheckPeriod < 0 {
+		errs = append(errs, "DB_MAX_CONN_LIFETIME, DB_MAX_CONN_IDLE_TIME and DB_HEALTH_CHECK_PERIOD cannot be negative")
+	}
+	if _, ok := queryExecModes[cmp.Or(c.DBQueryExecMode, defaultExecMode)]; !ok {
+		errs = append(errs, fmt.Sprintf("DB_QUERY_EXEC_MODE %q is not one of exec, describe_exec, cache_describe, cache_statement, simple_protocol", c.DBQueryExecMode))
+	}
+
+	// Result handles and running jobs hold admission slots, so however many
+	// are allowed they cannot take more connections than the pool has
+	if c.ResultMaxPerClient < 0 || c.ResultMax < 0 {
+		errs = append(errs, "RESULT_HANDLE_MAX and RESULT_HANDLE_MAX_PER_CLIENT cannot be negative")
+	}
+	if c.JobWorkers < 0 || c.JobMax < 0 || c.JobMaxPerClient < 0 || c.JobTimeout < 0 || c.JobTTL < 0 || c.JobMaxRows < 0 {
+		errs = append(errs, "JOB_* settings cannot be negative")
+	}
+	if c.SearchFuzzyThreshold < 0 || c.SearchFuzzyThreshold > 1 {
+		errs = append(errs, "SEARCH_FUZZY_THRESHOLD must be between 0 and 1")
+	}
+	if c.SearchWorkers < 0 || c.SearchWorkers >= c.maxConns() {
+		errs = append(errs, fmt.Sprintf("SEARCH_WORKERS must be below the pool size (%d): each worker holds a connection", c.maxConns()))
+	}
+	if c.SearchBudget < 0 || c.SearchBudget > 25*time.Second {
+		errs = append(errs, "SEARCH_TIME_BUDGET must be between 0 and 25 seconds (responses must finish within the 30 second HTTP write timeout)")
+	}
+	switch c.EmbeddingProvider {
+	case "", embedProviderOpenAI, embedProviderStub:
+	default:
+		errs = append(errs, fmt.Sprintf("EMBEDDING_PROVIDER %q is not one of openai, stub", c.EmbeddingProvider))
+	}
+	if c.JobMaxRows > 100000 {
+		errs = append(errs, "JOB_MAX_ROWS cannot exceed 100000 (job results are held in memory)")
+	}
 
-func (c *SchemaCache) Get(ctx context.Context, db *pgxpool.Pool) (string, error) {
-	c.mu.RLock()
-	if time.Now().Before(c.expiresAt) && c.txt != "" {
-		defer c.mu.RUnlock()
-		return c.txt, nil
+	if c.AdmitMaxConcurrent < 0 || c.AdmitMaxPerClient < 0 || c.AdmitQueue < 0 || c.AdmitWait < 0 {
+		errs = append(errs, "ADMISSION_* settings cannot be negative")
+	} else if c.AdmitMaxConcurrent > c.maxConns() {
+		errs = append(errs, fmt.Sprintf("ADMISSION_MAX_CONCURRENT cannot exceed the pool size (%d): each admitted query, result handle, job and search worker holds a connection", c.maxConns()))
 	}
-	c.mu.RUnlock()
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if time.Now().Before(c.expiresAt) && c.txt != "" {
-		return c.txt, nil
+	if c.ResultCacheTTL < 0 || c.ResultCacheSize < 0 {
+		errs = append(errs, "RESULT_CACHE_TTL and RESULT_CACHE_SIZE cannot be negative")
 	}
-	txt, err := loadSchema(ctx, db)
-	if err != nil {
-		return "", err
+	if _, err := parseTableTTLs(c.ResultCacheTableTTLs); err != nil {
+		errs = append(errs, "invalid RESULT_CACHE_TABLE_TTLS: "+err.Error())
 	}
-	if len(txt) > schemaMaxChars {
-		txt = txt[:schemaMaxChars] + "\n-- ...truncated schema..."
+	switch c.ResultCacheScope {
+	case "", cacheScopeClient, cacheScopeShared:
+	default:
+		errs = append(errs, fmt.Sprintf("RESULT_CACHE_SCOPE %q is not one of client, shared", c.ResultCacheScope))
+	}
+	switch c.ResultCacheInvalidation {
+	case "", cacheInvalidateTTL, cacheInvalidateStats, cacheInvalidateNotify:
+	default:
+		errs = append(errs, fmt.Sprintf("RESULT_CACHE_INVALIDATION %q is not one of ttl, stats, notify", c.ResultCacheInvalidation))
 	}
-	c.txt = txt
-	c.expiresAt = time.Now().Add(c.ttl)
-	return c.txt, nil
-}
 
-func loadSchema(ctx context.Context, db *pgxpool.Pool) (string, error) {
-	q := `
-WITH cols AS (
-  SELECT n.nspname AS schema, c.relname AS table, a.attname AS column,
-         pg_catalog.format_type(a.atttypid, a.atttypmod) AS data_type,
-         (SELECT EXISTS (
-            SELECT 1 FROM pg_constraint
-            WHERE conrelid = c.oid AND contype='p' AND a.attnum = ANY(conkey)
-         )) AS is_pk
-  FROM pg_attribute a
-  JOIN pg_class c ON a.attrelid = c.oid
-  JOIN pg_namespace n ON c.relnamespace = n.oid
-  WHERE a.attnum > 0 AND NOT a.attisdropped AND c.relkind='r' AND n.nspname NOT IN ('pg_catalog','information_schema')
-),
-fks AS (
-  SELECT
-    n1.nspname AS src_schema, c1.relname AS src_table, a1.attname AS src_column,
-    n2.nspname AS dst_schema, c2.relname AS dst_table, a2.attname AS dst_column
-  FROM pg_constraint co
-  JOIN pg_class c1 ON co.conrelid=c1.oid
-  JOIN pg_namespace n1 ON c1.relnamespace=n1.oid
-  JOIN pg_class c2 ON co.confrelid=c2.oid
-  JOIN pg_namespace n2 ON c2.relnamespace=n2.oid
-  JOIN unnest(co.conkey) WITH ORDINALITY AS ck(attnum, pos) ON TRUE
-  JOIN unnest(co.confkey) WITH ORDINALITY AS fk(attnum, pos) ON ck.pos=fk.pos
-  JOIN pg_attribute a1 ON a1.attrelid=c1.oid AND a1.attnum=ck.attnum
-  JOIN pg_attribute a2 ON a2.attrelid=c2.oid AND a2.attnum=fk.attnum
-  WHERE co.contype='f'
-)
-SELECT
-  'TABLE '||cols.schema||'.'||
-  CASE 
-    WHEN cols.table ~ '^[a-z_][a-z0-9_]*$' THEN cols.table
-    ELSE '"' || cols.table || '"'
-  END ||'('||
-    string_agg(
-      CASE 
-        WHEN cols.column ~ '^[a-z_][a-z0-9_]*$' THEN cols.column
-        ELSE '"' || cols.column || '"'
-      END ||' '||cols.data_type||CASE WHEN cols.is_pk THEN ' PRIMARY KEY' ELSE '' END, 
-      ', ' ORDER BY cols.column
-    )||
-  ')' AS line
-FROM cols
-GROUP BY cols.schema, cols.table
-UNION ALL
-SELECT 'FK '||src_schema||'.'||
-  CASE 
-    WHEN src_table ~ '^[a-z_][a-z0-9_]*$' THEN src_table
-    ELSE '"' || src_table || '"'
-  END ||'('||
-  CASE 
-    WHEN src_column ~ '^[a-z_][a-z0-9_]*$' THEN src_column
-    ELSE '"' || src_column || '"'
-  END ||') -> '||dst_schema||'.'||
-  CASE 
-    WHEN dst_table ~ '^[a-z_][a-z0-9_]*$' THEN dst_table
-    ELSE '"' || dst_table || '"'
-  END ||'('||
-  CASE 
-    WHEN dst_column ~ '^[a-z_][a-z0-9_]*$' THEN dst_column
-    ELSE '"' || dst_column || '"'
-  END ||')'
-FROM fks
-ORDER BY 1;`
-
-	ctxTO, cancel := context.WithTimeout(ctx, 15*time.Second)
-	defer cancel()
-	rows, err := db.Query(ctxTO, q)
-	if err != nil {
-		return "", err
-	}
-	defer rows.Close()
-	var b strings.Builder
-	for rows.Next() {
-		var line string
-		if err := rows.Scan(&line); err != nil {
-			return "", err
+	if _, err := newObjectFilter(*c); err != nil {
+		errs = append(errs, "invalid schema/table filter: "+err.Error())
+	}
+
+	for _, u := range c.ReplicaURLs {
+		if _, err := pgxpool.ParseConfig(u); err != nil {
+			errs = append(errs, "invalid REPLICA_URLS entry: "+err.Error())
 		}
-		b.WriteString(line)
-		b.WriteByte('\n')
 	}
-	return b.String(), rows.Err()
+	if c.ReplicaMaxLag < 0 {
+		errs = append(errs, "REPLICA_MAX_LAG cannot be negative")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("configuration validation failed:\n  - %s", strings.Join(errs, "\n  - "))
+	}
+
+	return nil
 }
 
 func mustConfig() Config {
@@ -224,6 +295,15 @@ func mustConfig() Config {
 		}
 	}
 
+	poll := defaultSchemaPoll
+	if v := os.Getenv("SCHEMA_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			poll = d
+		} else {
+			warnings = append(warnings, fmt.Sprintf("invalid SCHEMA_POLL_INTERVAL '%s': %v, using default %v", v, err, defaultSchemaPoll))
+		}
+	}
+
 	qto := defaultQueryTimeout
 	if v := os.Getenv("QUERY_TIMEOUT"); v != "" {
 		if d, err := time.ParseDuration(v); err == nil {
@@ -243,14 +323,67 @@ func mustConfig() Config {
 		}
 	}
 
+	resultTTL := envDuration("RESULT_HANDLE_TTL", defaultResultTTL, &warnings)
+	resultMaxPerClient := envInt("RESULT_HANDLE_MAX_PER_CLIENT", defaultResultMaxPerClient, &warnings)
+	resultMax := envInt("RESULT_HANDLE_MAX", defaultResultMax, &warnings)
+
 	cfg := Config{
 		DatabaseURL: envOrDie("DATABASE_URL"),
 		OpenAIKey:   os.Getenv("OPENAI_API_KEY"),
 		OpenAIModel: envDefault("OPENAI_MODEL", "gpt-4o-mini"),
 		OpenAIBase:  os.Getenv("OPENAI_BASE_URL"),
 		SchemaTTL:   ttl,
+		SchemaPoll:  poll,
 		QueryTO:     qto,
 		MaxRows:     mr,
+
+		SchemaInclude: parsePatternList(os.Getenv("SCHEMA_INCLUDE")),
+		SchemaExclude: parsePatternList(os.Getenv("SCHEMA_EXCLUDE")),
+		TableInclude:  parsePatternList(os.Getenv("TABLE_INCLUDE")),
+		TableExclude:  parsePatternList(os.Getenv("TABLE_EXCLUDE")),
+
+		PaginationSecret: os.Getenv("PAGINATION_SECRET"),
+
+		ResultTTL:          resultTTL,
+		ResultMaxPerClient: resultMaxPerClient,
+		ResultMax:          resultMax,
+
+		ReplicaURLs:   parsePatternList(os.Getenv("REPLICA_URLS")),
+		ReplicaMaxLag: envDuration("REPLICA_MAX_LAG", defaultReplicaMaxLag, &warnings),
+
+		DBMinConns:          envInt("DB_MIN_CONNS", defaultMinConns, &warnings),
+		DBMaxConns:          envInt("DB_MAX_CONNS", defaultMaxConns, &warnings),
+		DBMaxConnLifetime:   envDuration("DB_MAX_CONN_LIFETIME", defaultConnLifetime, &warnings),
+		DBMaxConnIdleTime:   envDuration("DB_MAX_CONN_IDLE_TIME", defaultConnIdleTime, &warnings),
+		DBHealthCheckPeriod: envDuration("DB_HEALTH_CHECK_PERIOD", defaultHealthCheck, &warnings),
+		DBQueryExecMode:     envDefault("DB_QUERY_EXEC_MODE", defaultExecMode),
+
+		AdmitMaxConcurrent: envInt("ADMISSION_MAX_CONCURRENT", 0, &warnings),
+		AdmitMaxPerClient:  envInt("ADMISSION_MAX_PER_CLIENT", defaultAdmitPerClient, &warnings),
+		AdmitQueue:         envInt("ADMISSION_QUEUE", defaultAdmitQueue, &warnings),
+		AdmitWait:          envDuration("ADMISSION_WAIT", defaultAdmitWait, &warnings),
+
+		ResultCacheTTL:          envDuration("RESULT_CACHE_TTL", 0, &warnings),
+		ResultCacheSize:         envInt("RESULT_CACHE_SIZE", defaultResultCacheSize, &warnings),
+		ResultCacheTableTTLs:    parsePatternList(os.Getenv("RESULT_CACHE_TABLE_TTLS")),
+		ResultCacheScope:        envDefault("RESULT_CACHE_SCOPE", cacheScopeClient),
+		ResultCacheInvalidation: envDefault("RESULT_CACHE_INVALIDATION", cacheInvalidateTTL),
+		ResultCacheChannel:      envDefault("RESULT_CACHE_CHANNEL", defaultCacheChannel),
+
+		JobWorkers:      envInt("JOB_WORKERS", defaultJobWorkers, &warnings),
+		JobMax:          envInt("JOB_MAX", defaultJobMax, &warnings),
+		JobMaxPerClient: envInt("JOB_MAX_PER_CLIENT", defaultJobMaxPerClient, &warnings),
+		JobTimeout:      envDuration("JOB_TIMEOUT", defaultJobTimeout, &warnings),
+		JobTTL:          envDuration("JOB_RESULT_TTL", defaultJobTTL, &warnings),
+		JobMaxRows:      envInt("JOB_MAX_ROWS", defaultJobMaxRows, &warnings),
+
+		SearchFTSConfig:      envDefault("SEARCH_FTS_CONFIG", defaultFTSConfig),
+		SearchFuzzyThreshold: envFloat("SEARCH_FUZZY_THRESHOLD", defaultFuzzyThreshold, &warnings),
+		SearchWorkers:        envInt("SEARCH_WORKERS", defaultSearchWorkers, &warnings),
+		SearchBudget:         envDuration("SEARCH_TIME_BUDGET", defaultSearchBudget, &warnings),
+
+		EmbeddingProvider: envDefault("EMBEDDING_PROVIDER", embedProviderOpenAI),
+		EmbeddingModel:    envDefault("EMBEDDING_MODEL", defaultEmbeddingModel),
 	}
 
 	// Print warnings
@@ -266,6 +399,48 @@ func mustConfig() Config {
 	return cfg
 }
 
+// envDuration reads a duration, falling back to def with a warning if it does not parse.
+func envDuration(k string, def time.Duration, warnings *[]string) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		*warnings = append(*warnings, fmt.Sprintf("invalid %s '%s': %v, using default %v", k, v, err, def))
+		return def
+	}
+	return d
+}
+
+// envInt reads a non-negative integer, falling back to def with a warning if it does not parse.
+func envInt(k string, def int, warnings *[]string) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 0 {
+		*warnings = append(*warnings, fmt.Sprintf("invalid %s '%s': must be a non-negative integer, using default %d", k, v, def))
+		return def
+	}
+	return n
+}
+
+// envFloat reads a number, falling back to def with a warning if it does not parse.
+func envFloat(k string, def float64, warnings *[]string) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		*warnings = append(*warnings, fmt.Sprintf("invalid %s '%s': must be a number, using default %v", k, v, def))
+		return def
+	}
+	return f
+}
+
 func envOrDie(k string) string {
 	v := os.Getenv(k)
 	if v == "" {
@@ -280,17 +455,59 @@ func envDefault(k, def string) string {
 	return def
 }
 
+// queryExecModes maps DB_QUERY_EXEC_MODE values to pgx modes. The default,
+// exec, sends each query as an unnamed extended-protocol statement: values
+// travel as bound parameters and nothing is cached per connection, so it is
+// safe behind transaction-pooling proxies such as PgBouncer. The cache modes
+// need session pooling; simple_protocol is for proxies that only speak the
+// simple query protocol.
+var queryExecModes = map[string]pgx.QueryExecMode{
+	"exec":            pgx.QueryExecModeExec,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
+
+// poolConfig parses url with the pool settings shared by the primary and replicas.
+func poolConfig(url string, cfg Config) (*pgxpool.Config, error) {
+	conf, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	conf.MinConns = int32(defaultMinConns)
+	if cfg.DBMinConns > 0 {
+		conf.MinConns = int32(cfg.DBMinConns)
+	}
+	conf.MaxConns = int32(cfg.maxConns())
+	conf.MaxConnLifetime = cmp.Or(cfg.DBMaxConnLifetime, defaultConnLifetime)
+	conf.MaxConnIdleTime = cmp.Or(cfg.DBMaxConnIdleTime, defaultConnIdleTime)
+	conf.HealthCheckPeriod = cmp.Or(cfg.DBHealthCheckPeriod, defaultHealthCheck)
+	conf.ConnConfig.DefaultQueryExecMode = queryExecModes[cmp.Or(cfg.DBQueryExecMode, defaultExecMode)]
+	conf.ConnConfig.BuildContextWatcherHandler = cancelRequestHandler
+	return conf, nil
+}
+
+// readPool picks the pool for a read query: a healthy replica if any are
+// configured, otherwise the primary. The name is what responses report.
+func (s *Server) readPool() (*pgxpool.Pool, string) {
+	return s.replicas.pick(s.db)
+}
+
 func newServer(ctx context.Context, cfg Config) (*Server, error) {
-	conf, err := pgxpool.ParseConfig(cfg.DatabaseURL)
+	filter, err := newObjectFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := newPageSigner(cfg.PaginationSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := poolConfig(cfg.DatabaseURL, cfg)
 	if err != nil {
 		return nil, err
 	}
-	conf.MinConns = 2
-	conf.MaxConns = 8
-	conf.MaxConnLifetime = 30 * time.Minute
-	conf.MaxConnIdleTime = 5 * time.Minute
-	conf.HealthCheckPeriod = 30 * time.Second
-	conf.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
 
 	db, err := pgxpool.NewWithConfig(ctx, conf)
 	if err != nil {
@@ -303,6 +520,19 @@ func newServer(ctx context.Context, cfg Config) (*Server, error) {
 		return nil, err
 	}
 
+	replicas, err := newReplicaSet(ctx, cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	resultCache, err := newResultCache(cfg)
+	if err != nil {
+		replicas.Close()
+		db.Close()
+		return nil, err
+	}
+
 	var opts []option.RequestOption
 	if cfg.OpenAIKey != "" {
 		opts = append(opts, option.WithAPIKey(cfg.OpenAIKey))
@@ -312,12 +542,23 @@ func newServer(ctx context.Context, cfg Config) (*Server, error) {
 	}
 	llm := openai.NewClient(opts...) // value
 
+	bg, stopBG := context.WithCancel(context.Background())
 	return &Server{
-		db:    db,
-		llm:   llm,
-		model: cfg.OpenAIModel,
-		cache: &SchemaCache{ttl: cfg.SchemaTTL},
-		cfg:   cfg,
+		db:          db,
+		llm:         llm,
+		model:       cfg.OpenAIModel,
+		cache:       &SchemaCache{ttl: cfg.SchemaTTL, filter: filter, bg: bg},
+		pages:       pages,
+		requests:    &httpRequests{},
+		results:     newResultStore(cfg.ResultTTL, cfg.ResultMaxPerClient, cfg.ResultMax),
+		replicas:    replicas,
+		admission:   newAdmission(cfg.admitMaxConcurrent(), cfg.AdmitMaxPerClient, cfg.AdmitQueue, cfg.AdmitWait),
+		resultCache: resultCache,
+		jobs:        newJobStore(cfg),
+		embedder:    newEmbedder(cfg, llm),
+		cfg:         cfg,
+		bg:          bg,
+		stopBG:      stopBG,
 	}, nil
 }
 
@@ -334,7 +575,16 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		}
 	}
 
+	// Stop background workers and release held result sets before their connections go away
+	if s.stopBG != nil {
+		s.stopBG()
+	}
+	if s.results != nil {
+		s.results.closeAll()
+	}
+
 	// Close database connections
+	s.replicas.Close()
 	if s.db != nil {
 		s.db.Close()
 		log.Info().Msg("database connections closed")
@@ -390,13 +640,22 @@ func sanitizeInput(input string) error {
 
 // auditLog logs security-relevant events
 func auditLog(event, user, query, result string, success bool) {
-	log.Info().
+	auditLogVia("", event, user, query, result, success)
+}
+
+// auditLogVia is auditLog for a query served by replica ("primary" or a
+// replica name); an empty replica is left out.
+func auditLogVia(replica, event, user, query, result string, success bool) {
+	ev := log.Info().
 		Str("event", event).
 		Str("user", user).
 		Str("query", query).
 		Str("result", result).
-		Bool("success", success).
-		Msg("audit_log")
+		Bool("success", success)
+	if replica != "" {
+		ev = ev.Str("replica", replica)
+	}
+	ev.Msg("audit_log")
 }
 
 // isExpensiveQuery detects potentially expensive query patterns
@@ -455,6 +714,23 @@ func validateSQLBasic(sql string) error {
 	return nil
 }
 
+// bearerAuth requires "Authorization: Bearer <token>" when token is set.
+func bearerAuth(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if strings.TrimSpace(got) != token {
+			auditLog("auth_failed", r.RemoteAddr, "", "invalid bearer token", false)
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // requestSizeLimitMiddleware limits the size of incoming requests
 func requestSizeLimitMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -466,16 +742,20 @@ func requestSizeLimitMiddleware(next http.Handler) http.Handler {
 // ---------- MCP tool handlers ----------
 
 type askInput struct {
-	Query     string `json:"query"`
-	MaxRows   int    `json:"max_rows,omitempty"`
-	DryRun    bool   `json:"dry_run,omitempty"`
-	Page      int    `json:"page,omitempty"`       // Page number (0-based)
-	PageSize  int    `json:"page_size,omitempty"`  // Results per page
-	StreamAll bool   `json:"stream_all,omitempty"` // Auto-fetch all pages
+	Query       string `json:"query"`
+	MaxRows     int    `json:"max_rows,omitempty"`
+	DryRun      bool   `json:"dry_run,omitempty"`
+	Page        int    `json:"page,omitempty"`        // Page number (0-based)
+	PageSize    int    `json:"page_size,omitempty"`   // Results per page
+	StreamAll   bool   `json:"stream_all,omitempty"`  // Auto-fetch all pages
+	ExactCount  bool   `json:"exact_count,omitempty"` // Count the full result, not just the rows returned
+	Cursor      string `json:"cursor,omitempty"`      // Continuation token from a previous next_cursor
+	Materialize bool   `json:"materialize,omitempty"` // Hold the result server-side; read on with fetch_results
 }
 
 type askOutput struct {
 	SQL        string           `json:"sql"`
+	Columns    []columnInfo     `json:"columns,omitempty"`
 	Rows       []map[string]any `json:"rows,omitempty"`
 	Note       string           `json:"note,omitempty"`
 	Page       int              `json:"page,omitempty"`
@@ -483,20 +763,40 @@ type askOutput struct {
 	TotalCount int              `json:"total_count,omitempty"`
 	HasMore    bool             `json:"has_more"`
 	NextPage   int              `json:"next_page,omitempty"`
+	NextCursor string           `json:"next_cursor,omitempty"` // Pass as cursor to fetch the next page
+
+	ResultHandle string     `json:"result_handle,omitempty"` // Pass to fetch_results for the remaining rows
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`    // When an unused result handle is released
+
+	Replica  string     `json:"replica,omitempty"` // where the query ran, when replicas are configured
+	Cached   bool       `json:"cached,omitempty"`  // served from the result cache
+	CachedAt *time.Time `json:"cached_at,omitempty"`
 }
 
 type streamInput struct {
-	Query    string `json:"query"`
-	MaxPages int    `json:"max_pages,omitempty"` // Max pages to fetch (default 10)
-	PageSize int    `json:"page_size,omitempty"` // Results per page (default 50)
+	Query       string `json:"query"`
+	MaxPages    int    `json:"max_pages,omitempty"`   // Max pages to fetch (default 10)
+	PageSize    int    `json:"page_size,omitempty"`   // Results per page (default 50)
+	ExactCount  bool   `json:"exact_count,omitempty"` // Count the full result, not just the rows returned
+	Materialize bool   `json:"materialize,omitempty"` // Return the first page and hold the rest server-side
 }
 
 type streamOutput struct {
 	SQL        string             `json:"sql"`
+	Columns    []columnInfo       `json:"columns,omitempty"`
 	Pages      []streamPageOutput `json:"pages"`
 	TotalRows  int                `json:"total_rows"`
 	TotalPages int                `json:"total_pages"`
+	TotalExact bool               `json:"total_exact"` // false: totals only cover the fetched pages
+	HasMore    bool               `json:"has_more"`
 	Note       string             `json:"note,omitempty"`
+
+	ResultHandle string     `json:"result_handle,omitempty"` // Pass to fetch_results for the remaining rows
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+
+	Replica  string     `json:"replica,omitempty"` // where the query ran, when replicas are configured
+	Cached   bool       `json:"cached,omitempty"`  // served from the result cache
+	CachedAt *time.Time `json:"cached_at,omitempty"`
 }
 
 type streamPageOutput struct {
@@ -506,11 +806,47 @@ type streamPageOutput struct {
 
 func (s *Server) handleAsk(ctx context.Context, req *mcp.CallToolRequest, in askInput) (*mcp.CallToolResult, askOutput, error) {
 	start := time.Now()
+	ctx, stop := s.requests.withRequestCancel(ctx, req)
+	defer stop()
 	clientIP := "unknown" // MCP doesn't expose client IP directly
 
 	log.Debug().Str("tool", "ask").Str("query", strings.TrimSpace(in.Query)).
 		Int("max_rows", in.MaxRows).Bool("dry_run", in.DryRun).Int("page", in.Page).
-		Int("page_size", in.PageSize).Bool("stream_all", in.StreamAll).Str("client_ip", clientIP).Msg("request")
+		Int("page_size", in.PageSize).Bool("stream_all", in.StreamAll).Bool("exact_count", in.ExactCount).Bool("materialize", in.Materialize).Str("client_ip", clientIP).Msg("request")
+
+	// Continuation: the token carries the SQL, so the LLM is not involved
+	if in.Cursor != "" {
+		tok, err := s.pages.Verify(in.Cursor)
+		if err == nil && tok.Facet != nil {
+			err = errInvalidCursor // a search facet's
+		}
+		if err == nil {
+			err = guardReadOnly(tok.SQL)
+		}
+		if err != nil {
+			auditLog("ask_cursor_rejected", clientIP, "", err.Error(), false)
+			log.Debug().Str("tool", "ask").Err(err).Msg("cursor rejected")
+			return nil, askOutput{}, err
+		}
+		release, err := s.admit(ctx, req)
+		if err != nil {
+			auditFailure(ctx, "ask", "ask_rejected", clientIP, tok.FP, err)
+			log.Debug().Str("tool", "ask").Err(err).Msg("not admitted")
+			return nil, askOutput{}, err
+		}
+		defer release()
+
+		out, err := s.askPage(ctx, req, tok, in.ExactCount)
+		if err != nil {
+			auditFailure(ctx, "ask", "ask_page_failed", clientIP, tok.SQL, err)
+			log.Debug().Str("tool", "ask").Err(err).Dur("dur", time.Since(start)).Msg("page query failed")
+			return nil, out, err
+		}
+		auditLogVia(out.Replica, "ask_page_success", clientIP, tok.FP, fmt.Sprintf("page %d (%s): %d rows%s", tok.Page, tok.Mode, len(out.Rows), cachedNote(out.Cached)), true)
+		log.Debug().Str("tool", "ask").Str("fingerprint", tok.FP).Int("page", tok.Page).Str("mode", tok.Mode).
+			Int("row_count", len(out.Rows)).Bool("cached", out.Cached).Dur("dur", time.Since(start)).Msg("done")
+		return nil, out, nil
+	}
 
 	// Input sanitization and validation
 	if err := sanitizeInput(in.Query); err != nil {
@@ -519,7 +855,7 @@ func (s *Server) handleAsk(ctx context.Context, req *mcp.CallToolRequest, in ask
 		return nil, askOutput{}, err
 	}
 
-	schemaTxt, err := s.cache.Get(ctx, s.db)
+	schemaTxt, err := s.promptSchema(ctx, in.Query)
 	if err != nil {
 		log.Debug().Str("tool", "ask").Err(err).Msg("schema load failed")
 		return nil, askOutput{}, err
@@ -533,7 +869,7 @@ func (s *Server) handleAsk(ctx context.Context, req *mcp.CallToolRequest, in ask
 
 	sql, note, err := s.generateSQL(ctx, in.Query, schemaTxt, pageSize*10) // Generate SQL for larger limit
 	if err != nil {
-		auditLog("ask_sql_generation_failed", clientIP, in.Query, err.Error(), false)
+		auditFailure(ctx, "ask", "ask_sql_generation_failed", clientIP, in.Query, err)
 		log.Debug().Str("tool", "ask").Err(err).Msg("sql generation failed")
 		return nil, askOutput{}, err
 	}
@@ -569,13 +905,55 @@ func (s *Server) handleAsk(ctx context.Context, req *mcp.CallToolRequest, in ask
 		// Continue anyway - let the database provide the real error
 	}
 
+	release, keep, err := s.admitKeep(ctx, req)
+	if err != nil {
+		auditFailure(ctx, "ask", "ask_rejected", clientIP, in.Query, err)
+		log.Debug().Str("tool", "ask").Err(err).Msg("not admitted")
+		return nil, askOutput{SQL: sql, Note: note}, err
+	}
+	defer release()
+
+	// Materialized results return the first page and keep the rest on a held snapshot
+	if in.Materialize {
+		mat, err := s.materialize(ctx, req, sql, pageSize, keep)
+		if err != nil {
+			auditFailure(ctx, "ask", "ask_query_failed", clientIP, sql, err)
+			log.Debug().Str("tool", "ask").Err(err).Dur("dur", time.Since(start)).Msg("materialize failed")
+			return nil, askOutput{SQL: sql, Note: note}, err
+		}
+		auditLogVia(mat.Replica, "ask_success", clientIP, in.Query, fmt.Sprintf("materialized, returned %d rows, handle %q", len(mat.Rows), mat.Handle), true)
+		log.Debug().Str("tool", "ask").Int("row_count", len(mat.Rows)).Str("handle", mat.Handle).Dur("dur", time.Since(start)).Msg("done")
+		return nil, askOutput{
+			SQL: sql, Columns: mat.Columns, Rows: mat.Rows, Note: note, PageSize: pageSize,
+			HasMore: mat.Handle != "", ResultHandle: mat.Handle, ExpiresAt: mat.ExpiresAt, Replica: mat.Replica,
+		}, nil
+	}
+
+	// An explicit page size without stream_all returns one page and a continuation token
+	if in.PageSize > 0 && !in.StreamAll {
+		tok := pageToken{SQL: sql, Page: in.Page, Size: pageSize, Offset: max(in.Page, 0) * pageSize}
+		out, err := s.askPage(ctx, req, tok, in.ExactCount)
+		out.Note = note
+		if err != nil {
+			auditFailure(ctx, "ask", "ask_query_failed", clientIP, sql, err)
+			log.Debug().Str("tool", "ask").Err(err).Dur("dur", time.Since(start)).Msg("query failed")
+			return nil, out, err
+		}
+		auditLogVia(out.Replica, "ask_success", clientIP, in.Query, fmt.Sprintf("page %d: %d rows%s", in.Page, len(out.Rows), cachedNote(out.Cached)), true)
+		log.Debug().Str("tool", "ask").Int("page", in.Page).Int("row_count", len(out.Rows)).
+			Bool("has_more", out.HasMore).Bool("cached", out.Cached).Dur("dur", time.Since(start)).Msg("done")
+		return nil, out, nil
+	}
+
 	// Automatically stream all results
 	maxPages := 20 // Auto-stream up to 20 pages (1000 results with default page size)
 	if in.MaxRows > 0 {
 		maxPages = (in.MaxRows + pageSize - 1) / pageSize // Calculate pages needed
 	}
 
-	pages, totalRows, err := s.runStreamingQuery(ctx, sql, maxPages, pageSize)
+	res, cachedAt, err := cachedQuery(s, req, "stream", sql, nil, nil, []any{maxPages, pageSize, in.ExactCount}, func() (*streamResult, error) {
+		return s.runStreamingQuery(ctx, sql, maxPages, pageSize, in.ExactCount, progressFunc(ctx, req, "ask"))
+	})
 	if err != nil {
 		// If query failed due to column errors, try to provide a helpful response
 		if strings.Contains(err.Error(), "column") && strings.Contains(err.Error(), "does not exist") {
@@ -635,43 +1013,107 @@ func (s *Server) handleAsk(ctx context.Context, req *mcp.CallToolRequest, in ask
 			}, nil
 		}
 
-		auditLog("ask_query_failed", clientIP, sql, err.Error(), false)
+		auditFailure(ctx, "ask", "ask_query_failed", clientIP, sql, err)
 		log.Debug().Str("tool", "ask").Err(err).Dur("dur", time.Since(start)).Msg("query failed")
 		return nil, askOutput{SQL: sql}, err
 	}
 
 	// Flatten all pages into single result
 	var allRows []map[string]any
-	for _, page := range pages {
+	for _, page := range res.Pages {
 		allRows = append(allRows, page.Rows...)
 	}
 
-	auditLog("ask_success", clientIP, in.Query, fmt.Sprintf("streamed %d rows across %d pages", totalRows, len(pages)), true)
-	log.Debug().Str("tool", "ask").Int("total_rows", totalRows).Int("pages", len(pages)).
-		Int("returned_rows", len(allRows)).Dur("dur", time.Since(start)).Msg("done")
+	auditLogVia(res.Replica, "ask_success", clientIP, in.Query, fmt.Sprintf("streamed %d rows across %d pages%s", res.Rows, len(res.Pages), cachedNote(cachedAt != nil)), true)
+	log.Debug().Str("tool", "ask").Int("total_rows", res.Total).Int("pages", len(res.Pages)).
+		Int("returned_rows", len(allRows)).Bool("cached", cachedAt != nil).Dur("dur", time.Since(start)).Msg("done")
 
-	return nil, askOutput{
-		SQL:  sql,
-		Rows: allRows,
-		Note: fmt.Sprintf("%s (streamed %d pages)", note, len(pages)),
-	}, nil
+	out := askOutput{
+		SQL:      sql,
+		Columns:  res.Columns,
+		Rows:     allRows,
+		Replica:  res.Replica,
+		Note:     fmt.Sprintf("%s (streamed %d pages)", note, len(res.Pages)),
+		HasMore:  res.HasMore,
+		Cached:   cachedAt != nil,
+		CachedAt: cachedAt,
+	}
+	if res.Exact {
+		out.TotalCount = res.Total
+	}
+	return nil, out, nil
 }
 
 type searchInput struct {
 	Q     string `json:"q"`
-	Limit int    `json:"limit,omitempty"`
+	Limit int    `json:"limit,omitempty"` // rows, or with facets hits per facet (default 50, with facets 5)
+	Mode  string `json:"mode,omitempty"`  // text (ILIKE substring, default), fulltext (ranked, websearch syntax) or fuzzy (pg_trgm similarity)
+
+	Threshold *float64 `json:"threshold,omitempty"` // fuzzy only: minimum similarity, 0-1 (default SEARCH_FUZZY_THRESHOLD, 0.3); 0 matches any shared trigram
+	Match     string   `json:"match,omitempty"`     // text only: substring (default), prefix, exact, case_sensitive or regex
+	Typed     bool     `json:"typed,omitempty"`     // text only: also compare a UUID, number or date in q with columns of that type
+	searchScope
+
+	Facets bool   `json:"facets,omitempty"` // group hits by table and column, with match counts
+	Cursor string `json:"cursor,omitempty"` // a facet's next_cursor; the rest of the input is ignored
 }
 
 type searchOutput struct {
-	SQL  string           `json:"sql"`
-	Rows []map[string]any `json:"rows"`
+	Queries  []searchQuery      `json:"queries"` // the SQL of each table with rows in the result
+	Columns  []columnInfo       `json:"columns,omitempty"`
+	Rows     []map[string]any   `json:"rows"`
+	Replica  string             `json:"replica,omitempty"` // where the queries ran, when replicas are configured
+	Cached   bool               `json:"cached,omitempty"`  // every table's result came from the result cache
+	CachedAt *time.Time         `json:"cached_at,omitempty"`
+	Notes    []string           `json:"notes,omitempty"`  // e.g. columns searched without a suitable index
+	Facets   []searchTableFacet `json:"facets,omitempty"` // with facets, the hits instead of rows
+
+	TablesSearched int                `json:"tables_searched"`
+	TablesSkipped  []string           `json:"tables_skipped,omitempty"`   // not started within the time budget
+	TablesTimedOut []string           `json:"tables_timed_out,omitempty"` // stopped by the time budget or query timeout
+	TablesFailed   []searchTableError `json:"tables_failed,omitempty"`
 }
 
 func (s *Server) handleSearch(ctx context.Context, req *mcp.CallToolRequest, in searchInput) (*mcp.CallToolResult, searchOutput, error) {
 	start := time.Now()
+	ctx, stop := s.requests.withRequestCancel(ctx, req)
+	defer stop()
 	clientIP := "unknown" // MCP doesn't expose client IP directly
 
-	log.Debug().Str("tool", "search").Str("q", strings.TrimSpace(in.Q)).Int("limit", in.Limit).Str("client_ip", clientIP).Msg("request")
+	log.Debug().Str("tool", "search").Str("q", strings.TrimSpace(in.Q)).Int("limit", in.Limit).Str("mode", in.Mode).Str("match", in.Match).Bool("typed", in.Typed).
+		Bool("facets", in.Facets).Bool("cursor", in.Cursor != "").Str("client_ip", clientIP).Msg("request")
+
+	// Continuation of one facet: the token carries its SQL and parameters
+	if in.Cursor != "" {
+		tok, err := s.pages.Verify(in.Cursor)
+		if err == nil && (len(tok.Facet) != 3 || tok.Mode != pageModeOffset) {
+			err = errInvalidCursor // not a search facet's
+		}
+		if err != nil {
+			auditLog("search_cursor_rejected", clientIP, "", err.Error(), false)
+			log.Debug().Str("tool", "search").Err(err).Msg("cursor rejected")
+			return nil, searchOutput{}, err
+		}
+		release, err := s.admit(ctx, req)
+		if err != nil {
+			auditFailure(ctx, "search", "search_rejected", clientIP, tok.FP, err)
+			log.Debug().Str("tool", "search").Err(err).Msg("not admitted")
+			return nil, searchOutput{}, err
+		}
+		defer release()
+
+		out, err := s.searchFacetPage(ctx, req, tok)
+		if err != nil {
+			auditFailure(ctx, "search", "search_page_failed", clientIP, tok.SQL, err)
+			log.Debug().Str("tool", "search").Err(err).Dur("dur", time.Since(start)).Msg("page query failed")
+			return nil, out, err
+		}
+		rows := len(out.Facets[0].Columns[0].Rows)
+		auditLogVia(out.Replica, "search_page_success", clientIP, tok.FP, fmt.Sprintf("facet %s page %d: %d rows%s", strings.Join(tok.Facet, "."), tok.Page, rows, cachedNote(out.Cached)), true)
+		log.Debug().Str("tool", "search").Str("fingerprint", tok.FP).Strs("facet", tok.Facet).Int("page", tok.Page).
+			Int("row_count", rows).Bool("cached", out.Cached).Dur("dur", time.Since(start)).Msg("done")
+		return nil, out, nil
+	}
 
 	// Input sanitization and validation
 	if err := sanitizeInput(in.Q); err != nil {
@@ -680,31 +1122,92 @@ func (s *Server) handleSearch(ctx context.Context, req *mcp.CallToolRequest, in
 		return nil, searchOutput{}, err
 	}
 	limit := minNonZero(in.Limit, 50)
-	sql, err := s.buildSearchSQL(ctx, in.Q, limit)
+	if in.Facets && in.Limit <= 0 {
+		limit = defaultFacetLimit
+	}
+	var (
+		searches []tableSearch
+		notes    []string
+		settings map[string]string // transaction-local settings for the queries
+	)
+	filter, err := newSearchFilter(in.searchScope)
+	if err == nil && in.Mode != "" && in.Mode != searchModeText && (in.Match != "" || in.Typed) {
+		err = fmt.Errorf("match and typed only apply to mode %s", searchModeText)
+	}
+	if err == nil {
+		switch in.Mode {
+		case "", searchModeText:
+			searches, notes, err = s.buildSearchSQL(ctx, in.Q, limit, in.Match, in.Typed, filter)
+		case searchModeFullText:
+			searches, notes, err = s.buildFullTextSQL(ctx, in.Q, limit, filter)
+		case searchModeFuzzy:
+			var threshold float64
+			if threshold, err = s.fuzzyThreshold(in.Threshold); err != nil {
+				break
+			}
+			searches, notes, err = s.buildFuzzySQL(ctx, in.Q, limit, filter)
+			settings = map[string]string{"pg_trgm.similarity_threshold": strconv.FormatFloat(threshold, 'f', -1, 64)}
+		default:
+			err = fmt.Errorf("unknown search mode %q: use %s, %s or %s", in.Mode, searchModeText, searchModeFullText, searchModeFuzzy)
+		}
+	}
 	if err != nil {
 		auditLog("search_sql_build_failed", clientIP, in.Q, err.Error(), false)
 		log.Debug().Str("tool", "search").Err(err).Msg("build sql failed")
 		return nil, searchOutput{}, err
 	}
-	log.Debug().Str("tool", "search").Str("sql", sql).Msg("generated sql")
+	// Facets take every column's best hits, so the run keeps them all
+	runLimit := limit
+	if in.Facets {
+		runLimit = 0
+		for i, ts := range searches {
+			searches[i].sql = facetSearchSQL(ts, limit)
+			runLimit += len(ts.parts) * limit
+		}
+	}
+	log.Debug().Str("tool", "search").Int("tables", len(searches)).Msg("generated sql")
+
+	release, err := s.admit(ctx, req)
+	if err != nil {
+		auditFailure(ctx, "search", "search_rejected", clientIP, in.Q, err)
+		log.Debug().Str("tool", "search").Err(err).Msg("not admitted")
+		return nil, searchOutput{}, err
+	}
+	defer release()
 
-	rows, err := s.runReadOnlyQuery(ctx, sql, limit)
+	run, err := s.runSearch(ctx, req, searches, settings, runLimit)
 	if err != nil {
-		auditLog("search_query_failed", clientIP, sql, err.Error(), false)
+		auditFailure(ctx, "search", "search_query_failed", clientIP, in.Q, err)
 		log.Debug().Str("tool", "search").Err(err).Dur("dur", time.Since(start)).Msg("query failed")
-		return nil, searchOutput{SQL: sql}, err
+		return nil, searchOutput{}, err
+	}
+	out := searchOutput{
+		Queries: run.queries, Columns: run.columns, Rows: run.rows, Replica: run.replica,
+		Cached: run.cachedAt != nil, CachedAt: run.cachedAt, Notes: notes,
+		TablesSearched: run.searched, TablesSkipped: run.skipped, TablesTimedOut: run.timedOut, TablesFailed: run.failed,
 	}
-	auditLog("search_success", clientIP, in.Q, fmt.Sprintf("returned %d rows", len(rows)), true)
-	log.Debug().Str("tool", "search").Int("row_count", len(rows)).Dur("dur", time.Since(start)).Msg("done")
-	return nil, searchOutput{SQL: sql, Rows: rows}, nil
+	if in.Facets {
+		if out.Facets, err = s.searchFacets(run, searches, settings, limit); err != nil {
+			log.Debug().Str("tool", "search").Err(err).Msg("cursor signing failed")
+			return nil, searchOutput{}, err
+		}
+		out.Columns, out.Rows = facetHitColumns(run.columns), []map[string]any{}
+	}
+	auditLogVia(run.replica, "search_success", clientIP, in.Q, fmt.Sprintf("returned %d rows from %d tables%s", len(run.rows), run.searched, cachedNote(out.Cached)), true)
+	log.Debug().Str("tool", "search").Int("row_count", len(run.rows)).Int("tables", run.searched).Int("facet_tables", len(out.Facets)).
+		Int("skipped", len(run.skipped)).Int("timed_out", len(run.timedOut)).Int("failed", len(run.failed)).
+		Str("replica", run.replica).Bool("cached", out.Cached).Dur("dur", time.Since(start)).Msg("done")
+	return nil, out, nil
 }
 
 func (s *Server) handleStream(ctx context.Context, req *mcp.CallToolRequest, in streamInput) (*mcp.CallToolResult, streamOutput, error) {
 	start := time.Now()
+	ctx, stop := s.requests.withRequestCancel(ctx, req)
+	defer stop()
 	clientIP := "unknown"
 
 	log.Debug().Str("tool", "stream").Str("query", strings.TrimSpace(in.Query)).
-		Int("max_pages", in.MaxPages).Int("page_size", in.PageSize).Str("client_ip", clientIP).Msg("request")
+		Int("max_pages", in.MaxPages).Int("page_size", in.PageSize).Bool("exact_count", in.ExactCount).Bool("materialize", in.Materialize).Str("client_ip", clientIP).Msg("request")
 
 	// Input sanitization and validation
 	if err := sanitizeInput(in.Query); err != nil {
@@ -713,7 +1216,7 @@ func (s *Server) handleStream(ctx context.Context, req *mcp.CallToolRequest, in
 		return nil, streamOutput{}, err
 	}
 
-	schemaTxt, err := s.cache.Get(ctx, s.db)
+	schemaTxt, err := s.promptSchema(ctx, in.Query)
 	if err != nil {
 		log.Debug().Str("tool", "stream").Err(err).Msg("schema load failed")
 		return nil, streamOutput{}, err
@@ -725,7 +1228,7 @@ func (s *Server) handleStream(ctx context.Context, req *mcp.CallToolRequest, in
 
 	sql, note, err := s.generateSQL(ctx, in.Query, schemaTxt, pageSize*maxPages)
 	if err != nil {
-		auditLog("stream_sql_generation_failed", clientIP, in.Query, err.Error(), false)
+		auditFailure(ctx, "stream", "stream_sql_generation_failed", clientIP, in.Query, err)
 		log.Debug().Str("tool", "stream").Err(err).Msg("sql generation failed")
 		return nil, streamOutput{}, err
 	}
@@ -736,26 +1239,66 @@ func (s *Server) handleStream(ctx context.Context, req *mcp.CallToolRequest, in
 		return nil, streamOutput{SQL: sql}, err
 	}
 
+	release, keep, err := s.admitKeep(ctx, req)
+	if err != nil {
+		auditFailure(ctx, "stream", "stream_rejected", clientIP, in.Query, err)
+		log.Debug().Str("tool", "stream").Err(err).Msg("not admitted")
+		return nil, streamOutput{SQL: sql}, err
+	}
+	defer release()
+
+	if in.Materialize {
+		mat, err := s.materialize(ctx, req, sql, pageSize, keep)
+		if err != nil {
+			auditFailure(ctx, "stream", "stream_query_failed", clientIP, sql, err)
+			log.Debug().Str("tool", "stream").Err(err).Dur("dur", time.Since(start)).Msg("materialize failed")
+			return nil, streamOutput{SQL: sql}, err
+		}
+		auditLogVia(mat.Replica, "stream_success", clientIP, in.Query, fmt.Sprintf("materialized, returned %d rows, handle %q", len(mat.Rows), mat.Handle), true)
+		log.Debug().Str("tool", "stream").Int("row_count", len(mat.Rows)).Str("handle", mat.Handle).Dur("dur", time.Since(start)).Msg("done")
+		return nil, streamOutput{
+			SQL:          sql,
+			Columns:      mat.Columns,
+			Pages:        []streamPageOutput{{Page: 0, Rows: mat.Rows}},
+			TotalRows:    len(mat.Rows),
+			TotalPages:   1,
+			TotalExact:   mat.Handle == "",
+			HasMore:      mat.Handle != "",
+			Note:         note,
+			ResultHandle: mat.Handle,
+			ExpiresAt:    mat.ExpiresAt,
+			Replica:      mat.Replica,
+		}, nil
+	}
+
 	// Get all pages
-	pages, totalRows, err := s.runStreamingQuery(ctx, sql, maxPages, pageSize)
+	res, cachedAt, err := cachedQuery(s, req, "stream", sql, nil, nil, []any{maxPages, pageSize, in.ExactCount}, func() (*streamResult, error) {
+		return s.runStreamingQuery(ctx, sql, maxPages, pageSize, in.ExactCount, progressFunc(ctx, req, "stream"))
+	})
 	if err != nil {
-		auditLog("stream_query_failed", clientIP, sql, err.Error(), false)
+		auditFailure(ctx, "stream", "stream_query_failed", clientIP, sql, err)
 		log.Debug().Str("tool", "stream").Err(err).Dur("dur", time.Since(start)).Msg("query failed")
 		return nil, streamOutput{SQL: sql}, err
 	}
 
-	totalPages := (totalRows + pageSize - 1) / pageSize // Ceiling division
+	totalPages := (res.Total + pageSize - 1) / pageSize // Ceiling division
 
-	auditLog("stream_success", clientIP, in.Query, fmt.Sprintf("returned %d rows in %d pages", totalRows, len(pages)), true)
-	log.Debug().Str("tool", "stream").Int("total_rows", totalRows).Int("pages", len(pages)).
-		Dur("dur", time.Since(start)).Msg("done")
+	auditLogVia(res.Replica, "stream_success", clientIP, in.Query, fmt.Sprintf("returned %d rows in %d pages%s", res.Rows, len(res.Pages), cachedNote(cachedAt != nil)), true)
+	log.Debug().Str("tool", "stream").Int("total_rows", res.Total).Int("pages", len(res.Pages)).
+		Bool("cached", cachedAt != nil).Dur("dur", time.Since(start)).Msg("done")
 
 	return nil, streamOutput{
 		SQL:        sql,
-		Pages:      pages,
-		TotalRows:  totalRows,
+		Columns:    res.Columns,
+		Pages:      res.Pages,
+		TotalRows:  res.Total,
 		TotalPages: totalPages,
+		TotalExact: res.Exact,
+		HasMore:    res.HasMore,
 		Note:       note,
+		Replica:    res.Replica,
+		Cached:     cachedAt != nil,
+		CachedAt:   cachedAt,
 	}, nil
 }
 
@@ -774,10 +1317,27 @@ func guardReadOnly(sql string) error {
 	return nil
 }
 
-func (s *Server) runReadOnlyQuery(ctx context.Context, sql string, limit int) ([]map[string]any, error) {
+// queryResult is a complete result set with the replica it was read from.
+type queryResult struct {
+	Columns []columnInfo
+	Rows    []map[string]any
+	Replica string // "" when no replicas are configured
+}
+
+// runReadOnlyQuery runs sql with args bound to its placeholders in a
+// read-only transaction, adding LIMIT limit if sql has none.
+func (s *Server) runReadOnlyQuery(ctx context.Context, sql string, limit int, args ...any) (*queryResult, error) {
+	return s.runReadOnlyQueryWith(ctx, nil, sql, limit, args...)
+}
+
+// runReadOnlyQueryWith is runReadOnlyQuery with settings applied to the
+// transaction first, for queries that depend on a setting such as
+// pg_trgm.similarity_threshold.
+func (s *Server) runReadOnlyQueryWith(ctx context.Context, settings map[string]string, sql string, limit int, args ...any) (*queryResult, error) {
 	ctxTO, cancel := context.WithTimeout(ctx, s.cfg.QueryTO)
 	defer cancel()
-	conn, err := s.db.Acquire(ctxTO)
+	db, replica := s.readPool()
+	conn, err := db.Acquire(ctxTO)
 	if err != nil {
 		return nil, err
 	}
@@ -789,55 +1349,80 @@ func (s *Server) runReadOnlyQuery(ctx context.Context, sql string, limit int) ([
 	}
 	defer tx.Rollback(ctxTO)
 
+	for name, value := range settings {
+		if _, err := tx.Exec(ctxTO, "SELECT set_config($1, $2, true)", name, value); err != nil {
+			return nil, err
+		}
+	}
 	if !regexp.MustCompile(`(?is)\bLIMIT\s+\d+`).MatchString(sql) {
 		sql = fmt.Sprintf("WITH q AS (%s) SELECT * FROM q LIMIT %d", sql, limit)
 	}
-	rows, err := tx.Query(ctxTO, sql)
+	rows, err := tx.Query(ctxTO, sql, args...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	flds := rows.FieldDescriptions()
-	out := make([]map[string]any, 0, 16)
-	for rows.Next() {
-		vals, err := rows.Values()
-		if err != nil {
-			return nil, err
-		}
-		row := make(map[string]any, len(flds))
-		for i, f := range flds {
-			row[string(f.Name)] = vals[i]
-		}
-		out = append(out, row)
+	out, flds, err := scanRows(rows, 16)
+	if err != nil {
+		return nil, err
 	}
-	if err := rows.Err(); err != nil {
+	cols, err := describeColumns(ctxTO, tx, flds)
+	if err != nil {
 		return nil, err
 	}
 	if err := tx.Commit(ctxTO); err != nil {
 		return nil, err
 	}
-	return out, nil
+	return &queryResult{Columns: cols, Rows: out, Replica: replica}, nil
 }
 
-// PaginatedResult holds pagination information
-type PaginatedResult struct {
-	Rows       []map[string]any
-	Page       int
-	PageSize   int
-	TotalCount int
-	HasMore    bool
-	NextPage   int
+// streamCursor is the name of the server-side cursor used for streaming.
+// Each stream runs in its own transaction, so a fixed name is safe.
+const streamCursor = "pgmcp_stream"
+
+// streamResult is what runStreamingQuery cut from a single execution of the query.
+type streamResult struct {
+	Replica string // "" when no replicas are configured
+	Columns []columnInfo
+	Pages   []streamPageOutput
+	Rows    int  // rows returned in Pages
+	Total   int  // exact row count if requested, otherwise equal to Rows
+	Exact   bool // Total is the full result size rather than what was fetched
+	HasMore bool // rows remain beyond the fetched pages
 }
 
-func (s *Server) runPaginatedQuery(ctx context.Context, sql string, page, pageSize int) (*PaginatedResult, error) {
-	// First, get total count
-	countSQL := fmt.Sprintf("WITH query AS (%s) SELECT COUNT(*) FROM query", sql)
+// cursorSQL prepares a generated statement for DECLARE ... CURSOR FOR, which
+// does not accept a trailing semicolon.
+func cursorSQL(sql string) string {
+	return strings.TrimRight(strings.TrimSpace(sql), "; \t\r\n")
+}
 
-	ctxTO, cancel := context.WithTimeout(ctx, s.cfg.QueryTO)
+// execStatement runs a generated statement that takes no parameters, such as
+// DECLARE ... CURSOR FOR. pgx sends parameterless Execs over the simple
+// protocol, which runs every statement in the string; sending it as an
+// unnamed extended-protocol statement instead makes the server reject
+// anything after the first. Pools configured for simple_protocol keep it.
+func execStatement(ctx context.Context, tx pgx.Tx, sql string) error {
+	conn := tx.Conn()
+	if conn.Config().DefaultQueryExecMode == pgx.QueryExecModeSimpleProtocol {
+		_, err := tx.Exec(ctx, sql)
+		return err
+	}
+	return conn.PgConn().ExecParams(ctx, sql, nil, nil, nil, nil).Read().Err
+}
+
+// runStreamingQuery executes sql once behind a server-side cursor and FETCHes
+// up to maxPages pages of pageSize rows. With exactCount the cursor is
+// scrollable and the whole result is counted with MOVE before the first
+// page, which is cheaper than a separate COUNT(*) but still reads every
+// row; otherwise a single extra row is probed to report whether more
+// remain. progress, if set, is called after each page, with the rows that
+// will be fetched in all when the count is known and 0 otherwise.
+func (s *Server) runStreamingQuery(ctx context.Context, sql string, maxPages, pageSize int, exactCount bool, progress func(fetched, total int)) (*streamResult, error) {
+	ctxTO, cancel := context.WithTimeout(ctx, s.cfg.QueryTO*time.Duration(maxPages))
 	defer cancel()
 
-	conn, err := s.db.Acquire(ctxTO)
+	db, replica := s.readPool()
+	conn, err := db.Acquire(ctxTO)
 	if err != nil {
 		return nil, err
 	}
@@ -849,178 +1434,173 @@ func (s *Server) runPaginatedQuery(ctx context.Context, sql string, page, pageSi
 	}
 	defer tx.Rollback(ctxTO)
 
-	var totalCount int
-	if err := tx.QueryRow(ctxTO, countSQL).Scan(&totalCount); err != nil {
-		return nil, fmt.Errorf("failed to get total count: %w", err)
+	scroll := "NO SCROLL"
+	if exactCount {
+		scroll = "SCROLL"
 	}
-
-	// Get paginated data
-	offset := page * pageSize
-	paginatedSQL := fmt.Sprintf("WITH query AS (%s) SELECT * FROM query LIMIT %d OFFSET %d", sql, pageSize, offset)
-
-	rows, err := tx.Query(ctxTO, paginatedSQL)
-	if err != nil {
+	if err := execStatement(ctxTO, tx, fmt.Sprintf("DECLARE %s %s CURSOR FOR %s", streamCursor, scroll, cursorSQL(sql))); err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	flds := rows.FieldDescriptions()
-	out := make([]map[string]any, 0, pageSize)
-	for rows.Next() {
-		vals, err := rows.Values()
+	res := &streamResult{Exact: exactCount, Replica: replica}
+	total := 0 // rows that will be fetched, for progress; 0 when unknown
+	if exactCount {
+		tag, err := tx.Exec(ctxTO, "MOVE FORWARD ALL FROM "+streamCursor)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to count rows: %w", err)
 		}
-		row := make(map[string]any, len(flds))
-		for i, f := range flds {
-			row[string(f.Name)] = vals[i]
+		if _, err := tx.Exec(ctxTO, "MOVE ABSOLUTE 0 FROM "+streamCursor); err != nil {
+			return nil, err
 		}
-		out = append(out, row)
+		res.Total = int(tag.RowsAffected())
+		total = min(res.Total, maxPages*pageSize)
 	}
+	fetchSQL := fmt.Sprintf("FETCH FORWARD %d FROM %s", pageSize, streamCursor)
+	exhausted := false
+	for page := 0; page < maxPages; page++ {
+		pageRows, flds, err := fetchRows(ctxTO, tx, fetchSQL, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		if page == 0 {
+			if res.Columns, err = describeColumns(ctxTO, tx, flds); err != nil {
+				return nil, err
+			}
+		}
+		if len(pageRows) == 0 {
+			exhausted = true
+			break
+		}
+		res.Pages = append(res.Pages, streamPageOutput{Page: page, Rows: pageRows})
+		res.Rows += len(pageRows)
+		if progress != nil {
+			progress(res.Rows, total)
+		}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+		// Stop if no more rows
+		if len(pageRows) < pageSize {
+			exhausted = true
+			break
+		}
+	}
+	switch {
+	case exactCount:
+		res.HasMore = res.Total > res.Rows
+	case !exhausted:
+		res.Total = res.Rows
+		probe, _, err := fetchRows(ctxTO, tx, "FETCH FORWARD 1 FROM "+streamCursor, 1)
+		if err != nil {
+			return nil, err
+		}
+		res.HasMore = len(probe) > 0
+	default:
+		res.Total = res.Rows
 	}
 
 	if err := tx.Commit(ctxTO); err != nil {
 		return nil, err
 	}
+	return res, nil
+}
 
-	hasMore := offset+len(out) < totalCount
-	nextPage := page + 1
-	if !hasMore {
-		nextPage = 0
+// fetchRows runs a FETCH (or any query) and collects the rows as maps.
+func fetchRows(ctx context.Context, tx pgx.Tx, sql string, capHint int) ([]map[string]any, []pgconn.FieldDescription, error) {
+	rows, err := tx.Query(ctx, sql)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	return &PaginatedResult{
-		Rows:       out,
-		Page:       page,
-		PageSize:   pageSize,
-		TotalCount: totalCount,
-		HasMore:    hasMore,
-		NextPage:   nextPage,
-	}, nil
+	return scanRows(rows, capHint)
 }
 
-func (s *Server) runStreamingQuery(ctx context.Context, sql string, maxPages, pageSize int) ([]streamPageOutput, int, error) {
-	// First, get total count
-	countSQL := fmt.Sprintf("WITH query AS (%s) SELECT COUNT(*) FROM query", sql)
+// likeEscaper escapes the LIKE wildcards and the escape character itself.
+// Backslash is the default LIKE escape, so patterns need no ESCAPE clause.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
 
-	ctxTO, cancel := context.WithTimeout(ctx, s.cfg.QueryTO*time.Duration(maxPages))
-	defer cancel()
+// containsPattern is a LIKE pattern matching q anywhere in a value.
+func containsPattern(q string) string {
+	return "%" + likeEscaper.Replace(q) + "%"
+}
 
-	conn, err := s.db.Acquire(ctxTO)
+// buildSearchSQL returns a query per table matching q against its text
+// columns as match asks, with q and the table and column labels as bound
+// parameters. Shorter values score higher, as the match covers more of them.
+// With typed set, a q that reads as a UUID, number or date is also compared
+// for equality with columns of that type, scoring as a full match, and an
+// email address is matched against whole values unless match says
+// otherwise. Identifiers are quoted by pgx.Identifier, so nothing the
+// caller or the catalog supplies is spliced into the SQL text unquoted.
+func (s *Server) buildSearchSQL(ctx context.Context, q string, limit int, match string, typed bool, f *searchFilter) ([]tableSearch, []string, error) {
+	var notes []string
+	kind := ""
+	if typed {
+		kind = classifySearchText(q)
+	}
+	if kind == searchTypeEmail && match == "" {
+		match = searchMatchExact
+		notes = append(notes, "q is an email address, so text columns were matched on their whole value, ignoring case")
+	}
+	tm, err := newTextMatch(match, q)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
-	defer conn.Release()
-
-	tx, err := conn.BeginTx(ctxTO, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	model, err := s.cache.Model(ctx, s.db)
 	if err != nil {
-		return nil, 0, err
-	}
-	defer tx.Rollback(ctxTO)
-
-	var totalCount int
-	if err := tx.QueryRow(ctxTO, countSQL).Scan(&totalCount); err != nil {
-		return nil, 0, fmt.Errorf("failed to get total count: %w", err)
+		return nil, nil, err
 	}
 
-	// Calculate actual pages to fetch
-	totalPages := (totalCount + pageSize - 1) / pageSize
-	pagesToFetch := minNonZero(maxPages, totalPages)
-
-	var pages []streamPageOutput
-
-	// Fetch pages
-	for page := 0; page < pagesToFetch; page++ {
-		offset := page * pageSize
-		paginatedSQL := fmt.Sprintf("WITH query AS (%s) SELECT * FROM query LIMIT %d OFFSET %d", sql, pageSize, offset)
-
-		rows, err := tx.Query(ctxTO, paginatedSQL)
-		if err != nil {
-			return nil, 0, err
+	var out []tableSearch
+	var typedColumns []string
+	for _, t := range model.Searchable() {
+		if !f.allowTable(t) {
+			continue
 		}
-
-		flds := rows.FieldDescriptions()
-		pageRows := make([]map[string]any, 0, pageSize)
-		for rows.Next() {
-			vals, err := rows.Values()
-			if err != nil {
-				rows.Close()
-				return nil, 0, err
-			}
-			row := make(map[string]any, len(flds))
-			for i, f := range flds {
-				row[string(f.Name)] = vals[i]
+		args := []any{tm.param, t.Schema + "." + t.Name}
+		key := rowKeySQL(t, &args)
+		value := "" // placeholder of q for typed comparisons, bound on first use
+		bindValue := func() string {
+			if value == "" {
+				args = append(args, q)
+				value = fmt.Sprintf("$%d::text", len(args))
 			}
-			pageRows = append(pageRows, row)
+			return value
 		}
-		rows.Close()
-
-		if err := rows.Err(); err != nil {
-			return nil, 0, err
+		var parts []string
+		for _, c := range t.Columns {
+			if !f.allowColumn(t, c.Name) {
+				continue
+			}
+			col := pgx.Identifier{c.Name}.Sanitize()
+			var score, cond string
+			if c.IsText() {
+				score, cond = tm.score(col), fmt.Sprintf("%s %s $1::text", col, tm.op)
+			} else if cond = typedCondition(kind, q, c, col, bindValue); cond != "" {
+				score = "1::float8"
+				typedColumns = append(typedColumns, t.Schema+"."+t.Name+"."+c.Name)
+			} else {
+				continue
+			}
+			args = append(args, c.Name)
+			parts = append(parts, fmt.Sprintf(
+				`SELECT $2::text AS source_table, $%d::text AS column, %s AS score, LEFT(CAST(%s AS text), 240) AS match_text, %s AS row_key FROM %s WHERE %s`,
+				len(args), score, col, key, pgx.Identifier{t.Schema, t.Name}.Sanitize(), cond,
+			))
 		}
-
-		pages = append(pages, streamPageOutput{
-			Page: page,
-			Rows: pageRows,
-		})
-
-		// Stop if no more rows
-		if len(pageRows) < pageSize {
-			break
+		if len(parts) > 0 {
+			out = append(out, tableSearch{table: t, sql: unionSearchSQL(parts, limit), args: args, parts: parts})
 		}
 	}
-
-	if err := tx.Commit(ctxTO); err != nil {
-		return nil, 0, err
-	}
-
-	return pages, totalCount, nil
-}
-
-func (s *Server) buildSearchSQL(ctx context.Context, q string, limit int) (string, error) {
-	const meta = `
-SELECT table_schema, table_name, column_name
-FROM information_schema.columns
-WHERE data_type IN ('text','character varying','character','citext')
-  AND table_schema NOT IN ('pg_catalog','information_schema')
-ORDER BY table_schema, table_name, ordinal_position;
-`
-	ctxTO, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-	rows, err := s.db.Query(ctxTO, meta)
-	if err != nil {
-		return "", err
+	if len(out) == 0 {
+		return nil, nil, errors.New("no searchable columns")
 	}
-	defer rows.Close()
-
-	type col struct{ s, t, c string }
-	var cols []col
-	for rows.Next() {
-		var c col
-		if err := rows.Scan(&c.s, &c.t, &c.c); err != nil {
-			return "", err
-		}
-		cols = append(cols, c)
-	}
-	if len(cols) == 0 {
-		return "", errors.New("no searchable columns")
-	}
-	like := strings.ReplaceAll(q, "'", "''")
-	var parts []string
-	for _, c := range cols {
-		parts = append(parts, fmt.Sprintf(
-			`SELECT '%s.%s' AS source_table, '%s' AS column, LEFT(CAST("%s" AS text), 240) AS match_text FROM "%s"."%s" WHERE "%s" ILIKE '%%%s%%'`,
-			c.s, c.t, c.c, c.c, c.s, c.t, c.c, like,
-		))
-		if len(parts) >= 60 {
-			break
-		}
+	switch {
+	case kind == "" || kind == searchTypeEmail:
+	case len(typedColumns) == 0:
+		notes = append(notes, fmt.Sprintf("q reads as a %s, but no searched column has a matching type", kind))
+	default:
+		notes = append(notes, fmt.Sprintf("q reads as a %s and was also compared with %d columns of a matching type: %s",
+			kind, len(typedColumns), columnList(typedColumns)))
 	}
-	sql := "WITH u AS (\n" + strings.Join(parts, "\nUNION ALL\n") + fmt.Sprintf("\n) SELECT * FROM u LIMIT %d", limit)
-	return sql, nil
+	return out, notes, nil
 }
 
 func (s *Server) generateSQL(ctx context.Context, question, schema string, maxRows int) (string, string, error) {
@@ -1091,6 +1671,15 @@ func (s *Server) generateSQL(ctx context.Context, question, schema string, maxRo
 	- Example: If table A has column X, but you need column Y from table B, look for FK A.some_id -> B.id
 	- Use ONLY the foreign key relationships explicitly shown in the schema summary
 	- When multiple JOIN paths exist, choose the most direct one with fewest tables
+	- If "JOIN HINTS" are listed after the schema, they are the shortest FK paths between the tables in the question; use them as written
+
+	Constraints, Enums and Indexes:
+	- Columns marked NOT NULL never need IS NULL checks; DEFAULT shows the value used when none is given
+	- "UNIQUE" lines list column sets that identify at most one row; prefer them for exact lookups
+	- "CHECK" lines show the domain rules a column obeys; never filter on values that violate them
+	- "ENUM" lines list every valid label of an enum type; only compare enum columns with those labels, spelled exactly
+	- "INDEX" lines show indexed columns; prefer filtering, joining and sorting on indexed columns
+	- Do not wrap indexed columns in functions (e.g. lower(col)) unless the INDEX line uses the same expression
 	
 	Performance Guidelines (CRITICAL):
 	- PREFER single-table queries when possible
@@ -1100,7 +1689,7 @@ func (s *Server) generateSQL(ctx context.Context, question, schema string, maxRo
 	- If a question requires more than 2 JOINs, simplify to a single-table approximation
 	- NEVER assume columns exist in the wrong table - always verify against schema first
 
-	MANDATORY: Study this schema summary carefully before writing SQL. It shows all tables, columns, and foreign key relationships:
+	MANDATORY: Study this schema summary carefully before writing SQL. It shows all tables, columns, constraints, enums, indexes and foreign key relationships:
 	
 	` + schema + `
 	
@@ -1162,9 +1751,18 @@ func main() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("init failed")
 	}
+	// Load the schema up front so filter exclusions are logged at startup
+	if _, err := srv.cache.Model(ctx, srv.db); err != nil {
+		log.Warn().Err(err).Msg("initial schema load failed; retrying on first request")
+	}
+
 	impl := &mcp.Implementation{Name: "pgmcp-go", Version: "0.3.0"}
 
-	server := mcp.NewServer(impl, nil)
+	server := mcp.NewServer(impl, &mcp.ServerOptions{
+		CompletionHandler:  srv.handleComplete,
+		SubscribeHandler:   srv.handleSubscribe,
+		UnsubscribeHandler: func(context.Context, *mcp.UnsubscribeRequest) error { return nil },
+	})
 
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "ask",
@@ -1172,12 +1770,66 @@ func main() {
 	}, srv.handleAsk)
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "search",
-		Description: "Search free text across all tables/columns (ILIKE).",
+		Description: "Search free text across all tables, views and materialized views. mode=text (default) matches substrings with ILIKE; mode=fulltext uses tsvector columns or to_tsvector with websearch_to_tsquery syntax, ranked by ts_rank with ts_headline snippets; mode=fuzzy tolerates misspellings using pg_trgm similarity above threshold. schemas/tables/columns and their exclude_ lists narrow any mode; in text mode, match picks substring, prefix, exact, case_sensitive or regex, and typed also matches a UUID, number or date against columns of that type. With facets, hits are grouped by table and column with match counts, limit hits each, and a facet's next_cursor passed as cursor pages through that facet alone. Each hit outside a view has a row_uri for get_row.",
 	}, srv.handleSearch)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "semantic_search",
+		Description: "Find rows whose pgvector embeddings are nearest to the meaning of q, e.g. products similar to 'noise-cancelling headphones'. Embeds q and orders each vector column by l2 (<->) or cosine (<=>) distance; returns each row with its distance and a row_uri.",
+	}, srv.handleSemanticSearch)
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "stream",
 		Description: "Stream large result sets by automatically fetching all pages. Returns complete results progressively.",
 	}, srv.handleStream)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "find_join_path",
+		Description: "Find the shortest foreign key join path between two or more tables. Composite keys are joined on all their columns.",
+	}, srv.handleFindJoinPath)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "list_tables",
+		Description: "List tables with row estimates, on-disk size and comments, straight from the catalog. Optional schema and name filter.",
+	}, srv.handleListTables)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "describe_table",
+		Description: "Describe one table: columns with types, nullability, defaults and comments, keys, constraints, indexes, and inbound and outbound foreign keys.",
+	}, srv.handleDescribeTable)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "get_row",
+		Description: "Fetch one complete row by the row_uri of a search hit, or by schema, table and primary key values (ctid, plus tableoid for partitioned tables, for tables and materialized views without a primary key).",
+	}, srv.handleGetRow)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "fetch_results",
+		Description: "Fetch the next rows of a result materialized by ask or stream with materialize=true. All pages come from the same snapshot.",
+	}, srv.handleFetchResults)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "close_results",
+		Description: "Release a materialized result handle early. Handles also close when drained or after their TTL.",
+	}, srv.handleCloseResults)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "submit_query",
+		Description: "Run a long analytics question in the background. Returns a job_id at once (or waits up to wait_seconds); poll job_status and read rows with job_result.",
+	}, srv.handleSubmitQuery)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "job_status",
+		Description: "Report a submitted job's state and rows fetched so far. wait_seconds long-polls until the job finishes, sending progress notifications.",
+	}, srv.handleJobStatus)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "job_result",
+		Description: "Read the rows of a finished job, paged by offset. Results are kept for a limited time after the job finishes.",
+	}, srv.handleJobResult)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "cancel_job",
+		Description: "Cancel a queued or running job, or discard a finished job's result.",
+	}, srv.handleCancelJob)
+	srv.registerResources(server)
+	srv.cache.onChange = func(old, cur *SchemaModel) {
+		srv.resultCache.flush(time.Now())
+		srv.notifySchemaChanged(server, old, cur)
+	}
+	go srv.cache.Watch(srv.bg, srv.db, cfg.SchemaPoll)
+	go srv.results.Run(srv.bg)
+	go srv.replicas.Run(srv.bg)
+	go srv.resultCache.Run(srv.bg, srv.db)
+	go srv.jobs.Run(srv.bg, srv.runJob)
 
 	// --- Streamable HTTP transport ---
 	addr := envDefault("HTTP_ADDR", ":8080")
@@ -1185,25 +1837,14 @@ func main() {
 	bearer := strings.TrimSpace(os.Getenv("AUTH_BEARER"))
 
 	base := mcp.NewStreamableHTTPHandler(func(r *http.Request) *mcp.Server { return server }, nil)
-	var handler http.Handler = base
-	if bearer != "" {
-		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-			if strings.TrimSpace(got) != bearer {
-				auditLog("auth_failed", r.RemoteAddr, "", "invalid bearer token", false)
-				w.WriteHeader(http.StatusUnauthorized)
-				_, _ = w.Write([]byte("unauthorized"))
-				return
-			}
-			base.ServeHTTP(w, r)
-		})
-	}
+	handler := bearerAuth(bearer, srv.requests.trackRequests(base))
 
 	// Apply middleware
 	handler = requestSizeLimitMiddleware(handler)
 
 	mux := http.NewServeMux()
 	mux.Handle(path, handler)
+	mux.Handle("/stats", bearerAuth(bearer, http.HandlerFunc(srv.handleStats)))
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(200)
 		_, _ = w.Write([]byte("ok"))
@@ -0,0 +1,467 @@
+// server/pagination.go
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	pageTokenVersion = 1
+	pageTokenTTL     = 24 * time.Hour // continuation tokens older than this are rejected
+	minSecretLen     = 16
+
+	pageModeOffset = "offset"
+	pageModeKeyset = "keyset"
+)
+
+var errInvalidCursor = errors.New("invalid or expired cursor")
+
+// pageToken is the state carried by a continuation token. It holds the SQL
+// itself so that the next page can be fetched without the LLM, plus either
+// an offset or a keyset position. ask and search facets sign the same
+// tokens, told apart by Facet.
+type pageToken struct {
+	V      int      `json:"v"`
+	SQL    string   `json:"sql"`
+	FP     string   `json:"fp"` // SQL fingerprint
+	Page   int      `json:"page"`
+	Size   int      `json:"size"`
+	Mode   string   `json:"mode,omitempty"`
+	Offset int      `json:"off,omitempty"`   // rows returned before this page
+	Keys   []string `json:"keys,omitempty"`  // output columns of the ORDER BY
+	Types  []string `json:"types,omitempty"` // their PostgreSQL type names
+	Desc   bool     `json:"desc,omitempty"`
+	Last   []string `json:"last,omitempty"` // key values of the last row returned, as text
+	Ties   int      `json:"ties,omitempty"` // rows already returned that share Last
+	Issued int64    `json:"iat"`
+
+	// Search facet pages: the schema, table and column of the facet, and
+	// what SQL's placeholders and transaction settings are bound to
+	Facet    []string          `json:"facet,omitempty"`
+	Args     []string          `json:"args,omitempty"`
+	Settings map[string]string `json:"set,omitempty"`
+}
+
+// sqlFingerprint identifies a statement in tokens and audit logs.
+func sqlFingerprint(sql string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(sql)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// pageSigner signs and verifies continuation tokens. Tokens are
+// base64url(JSON) + "." + base64url(HMAC-SHA256), so they are opaque to
+// clients and cannot be altered to run different SQL.
+type pageSigner struct {
+	key []byte
+}
+
+// newPageSigner uses secret as the HMAC key, or a random key when empty.
+// A random key means tokens do not survive a restart and are not accepted
+// by other replicas of the server.
+func newPageSigner(secret string) (*pageSigner, error) {
+	if secret != "" {
+		return &pageSigner{key: []byte(secret)}, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	log.Info().Msg("PAGINATION_SECRET not set - continuation tokens are only valid for this process")
+	return &pageSigner{key: key}, nil
+}
+
+func (p *pageSigner) mac(payload string) []byte {
+	h := hmac.New(sha256.New, p.key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+func (p *pageSigner) Sign(tok pageToken) (string, error) {
+	tok.V = pageTokenVersion
+	tok.FP = sqlFingerprint(tok.SQL)
+	if tok.Issued == 0 {
+		tok.Issued = time.Now().Unix()
+	}
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(p.mac(payload)), nil
+}
+
+func (p *pageSigner) Verify(s string) (pageToken, error) {
+	var tok pageToken
+	payload, sig, ok := strings.Cut(strings.TrimSpace(s), ".")
+	if !ok {
+		return tok, errInvalidCursor
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, p.mac(payload)) {
+		return tok, errInvalidCursor
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return tok, errInvalidCursor
+	}
+	if err := json.Unmarshal(b, &tok); err != nil {
+		return tok, errInvalidCursor
+	}
+	if tok.V != pageTokenVersion || tok.FP != sqlFingerprint(tok.SQL) || tok.Size <= 0 {
+		return tok, errInvalidCursor
+	}
+	if time.Since(time.Unix(tok.Issued, 0)) > pageTokenTTL {
+		return tok, errInvalidCursor
+	}
+	return tok, nil
+}
+
+// ---------- keyset detection ----------
+
+// trailingOrderBy captures a top-level ORDER BY at the end of a statement,
+// optionally followed by LIMIT/OFFSET.
+var trailingOrderBy = regexp.MustCompile(`(?is)\bORDER\s+BY\s+([^()]+?)(?:\s+LIMIT\s+\d+)?(?:\s+OFFSET\s+\d+)?\s*;?\s*$`)
+
+var orderItem = regexp.MustCompile(`(?i)^(?:(?:"[^"]+"|[a-z_][a-z0-9_$]*)\.)?("(?:[^"]|"")+"|[a-z_][a-z0-9_$]*)(?:\s+(ASC|DESC))?$`)
+
+// parseOrderBy returns the column names of a simple ORDER BY list and its
+// direction. Expressions, ordinals, NULLS FIRST/LAST and mixed directions
+// are not keyset-able and report ok=false.
+func parseOrderBy(sql string) (cols []string, desc bool, ok bool) {
+	m := trailingOrderBy.FindStringSubmatch(sql)
+	if m == nil {
+		return nil, false, false
+	}
+	for i, item := range strings.Split(m[1], ",") {
+		p := orderItem.FindStringSubmatch(strings.TrimSpace(item))
+		if p == nil {
+			return nil, false, false
+		}
+		name := p[1]
+		if strings.HasPrefix(name, `"`) {
+			name = strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+		} else {
+			name = strings.ToLower(name)
+		}
+		d := strings.EqualFold(p[2], "DESC")
+		if i > 0 && d != desc {
+			return nil, false, false
+		}
+		desc = d
+		cols = append(cols, name)
+	}
+	return cols, desc, true
+}
+
+// keysetTypes are the key types whose decoded values round-trip exactly
+// through their text form, keyed by OID.
+var keysetTypes = map[uint32]string{
+	20: "int8", 21: "int2", 23: "int4", 25: "text", 1043: "varchar",
+	1700: "numeric", 1082: "date", 1114: "timestamp", 1184: "timestamptz", 2950: "uuid",
+}
+
+// keyText renders a decoded key value as PostgreSQL text input.
+func keyText(v any, typ string) (string, bool) {
+	switch x := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return x, true
+	case int16:
+		return strconv.FormatInt(int64(x), 10), true
+	case int32:
+		return strconv.FormatInt(int64(x), 10), true
+	case int64:
+		return strconv.FormatInt(x, 10), true
+	case [16]byte:
+		return fmt.Sprintf("%x-%x-%x-%x-%x", x[0:4], x[4:6], x[6:8], x[8:10], x[10:16]), true
+	case time.Time:
+		switch typ {
+		case "date":
+			return x.Format("2006-01-02"), true
+		case "timestamp":
+			return x.Format("2006-01-02 15:04:05.999999"), true
+		default:
+			return x.Format("2006-01-02 15:04:05.999999Z07:00"), true
+		}
+	case driver.Valuer: // pgtype.Numeric and friends
+		dv, err := x.Value()
+		if s, ok := dv.(string); ok && err == nil && s != "NaN" {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// planKeyset describes the page query and maps the ORDER BY columns of
+// tok.SQL onto its result fields. It only succeeds when every key is a
+// plain NOT NULL table column of a supported type, since NULLs would fall
+// outside the row comparison. On success tok carries the keys, and every
+// page (including the first) is ordered by them so all pages agree.
+func planKeyset(ctx context.Context, tx pgx.Tx, tok *pageToken) bool {
+	cols, desc, ok := parseOrderBy(tok.SQL)
+	if !ok {
+		return false
+	}
+	sd, err := tx.Conn().PgConn().Prepare(ctx, "", fmt.Sprintf("SELECT * FROM (%s) q", cursorSQL(tok.SQL)), nil)
+	if err != nil {
+		return false
+	}
+	var types []string
+	for _, c := range cols {
+		found := -1
+		for i, f := range sd.Fields {
+			if f.Name == c {
+				if found >= 0 {
+					return false // ambiguous output name
+				}
+				found = i
+			}
+		}
+		if found < 0 {
+			return false
+		}
+		f := sd.Fields[found]
+		typ, supported := keysetTypes[f.DataTypeOID]
+		if !supported || f.TableOID == 0 {
+			return false
+		}
+		var notNull bool
+		if err := tx.QueryRow(ctx, "SELECT attnotnull FROM pg_attribute WHERE attrelid = $1 AND attnum = $2",
+			f.TableOID, f.TableAttributeNumber).Scan(&notNull); err != nil || !notNull {
+			return false
+		}
+		types = append(types, typ)
+	}
+	tok.Keys, tok.Types, tok.Desc = cols, types, desc
+	return true
+}
+
+// pageSQL builds the statement for the page described by tok, fetching one
+// extra row to learn whether another page exists. Keyset pages seek past
+// Last and skip the rows that tie with it; everything else uses OFFSET.
+func pageSQL(tok pageToken) (string, []any) {
+	inner := cursorSQL(tok.SQL)
+	if len(tok.Keys) == 0 {
+		return fmt.Sprintf("SELECT * FROM (%s) q LIMIT %d OFFSET %d", inner, tok.Size+1, tok.Offset), nil
+	}
+	cols := make([]string, len(tok.Keys))
+	order := make([]string, len(tok.Keys))
+	dir, cmp := "ASC", ">="
+	if tok.Desc {
+		dir, cmp = "DESC", "<="
+	}
+	for i, k := range tok.Keys {
+		cols[i] = "q." + pgx.Identifier{k}.Sanitize()
+		order[i] = cols[i] + " " + dir
+	}
+	if tok.Mode != pageModeKeyset {
+		return fmt.Sprintf("SELECT * FROM (%s) q ORDER BY %s LIMIT %d OFFSET %d",
+			inner, strings.Join(order, ", "), tok.Size+1, tok.Offset), nil
+	}
+	params := make([]string, len(tok.Keys))
+	args := make([]any, len(tok.Keys))
+	for i := range tok.Keys {
+		params[i] = fmt.Sprintf("$%d::text::%s", i+1, tok.Types[i])
+		args[i] = tok.Last[i]
+	}
+	return fmt.Sprintf("SELECT * FROM (%s) q WHERE (%s) %s (%s) ORDER BY %s LIMIT %d OFFSET %d",
+		inner, strings.Join(cols, ", "), cmp, strings.Join(params, ", "), strings.Join(order, ", "), tok.Size+1, tok.Ties), args
+}
+
+// ---------- page execution ----------
+
+// PaginatedResult holds pagination information
+type PaginatedResult struct {
+	Replica    string // "" when no replicas are configured
+	Columns    []columnInfo
+	Rows       []map[string]any
+	Page       int
+	PageSize   int
+	TotalCount int // only set when an exact count was requested
+	HasMore    bool
+	NextPage   int
+	Next       *pageToken // position of the following page, nil on the last page
+}
+
+// runPaginatedQuery fetches the page described by tok in a read-only
+// transaction. On the first page the query's ORDER BY is checked for keyset
+// use, so later pages seek by key instead of rescanning with OFFSET.
+func (s *Server) runPaginatedQuery(ctx context.Context, tok pageToken, exactCount bool) (*PaginatedResult, error) {
+	ctxTO, cancel := context.WithTimeout(ctx, s.cfg.QueryTO)
+	defer cancel()
+
+	db, replica := s.readPool()
+	conn, err := db.Acquire(ctxTO)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	tx, err := conn.BeginTx(ctxTO, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctxTO)
+
+	if tok.Mode == "" {
+		tok.Mode = pageModeOffset
+		planKeyset(ctxTO, tx, &tok)
+	}
+
+	q, args := pageSQL(tok)
+	rows, err := tx.Query(ctxTO, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	m := rows.Conn().TypeMap()
+	flds := append([]pgconn.FieldDescription(nil), rows.FieldDescriptions()...)
+	// raw keeps the decoded values for the continuation key
+	var raw [][]any
+	var encoded []map[string]any
+	for rows.Next() {
+		vals, err := rows.Values()
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		raw = append(raw, vals)
+		encoded = append(encoded, encodeRow(m, flds, vals, rows.RawValues()))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	res := &PaginatedResult{Page: tok.Page, PageSize: tok.Size, Rows: []map[string]any{}, Replica: replica}
+	if len(raw) > tok.Size {
+		raw, encoded = raw[:tok.Size], encoded[:tok.Size]
+		res.HasMore = true
+	}
+	if len(encoded) > 0 {
+		res.Rows = encoded
+	}
+	if res.Columns, err = describeColumns(ctxTO, tx, flds); err != nil {
+		return nil, err
+	}
+
+	if res.HasMore {
+		next := nextPageToken(tok, flds, raw)
+		res.Next = &next
+		res.NextPage = tok.Page + 1
+	}
+
+	if exactCount {
+		if err := tx.QueryRow(ctxTO, fmt.Sprintf("SELECT COUNT(*) FROM (%s) q", cursorSQL(tok.SQL))).Scan(&res.TotalCount); err != nil {
+			return nil, fmt.Errorf("failed to get total count: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctxTO); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// nextPageToken derives the position after the rows just returned. Keyed
+// queries continue from the last row's key; if that key cannot be rendered
+// as text the next page falls back to OFFSET under the same ordering.
+func nextPageToken(tok pageToken, flds []pgconn.FieldDescription, raw [][]any) pageToken {
+	next := tok
+	next.Page, next.Offset, next.Issued = tok.Page+1, tok.Offset+len(raw), 0
+	next.Mode, next.Last, next.Ties = pageModeOffset, nil, 0
+	if len(tok.Keys) == 0 || len(raw) == 0 {
+		return next
+	}
+
+	idx := make([]int, 0, len(tok.Keys))
+	for _, k := range tok.Keys {
+		for i, f := range flds {
+			if f.Name == k {
+				idx = append(idx, i)
+				break
+			}
+		}
+	}
+	if len(idx) != len(tok.Keys) {
+		return next
+	}
+	keyOf := func(vals []any) (string, []string, bool) {
+		out := make([]string, len(idx))
+		for i, j := range idx {
+			t, ok := keyText(vals[j], tok.Types[i])
+			if !ok {
+				return "", nil, false
+			}
+			out[i] = t
+		}
+		return strings.Join(out, "\x00"), out, true
+	}
+	lastID, last, ok := keyOf(raw[len(raw)-1])
+	if !ok {
+		return next
+	}
+	// Rows sharing the last key are skipped with OFFSET on the next page
+	ties := 0
+	for i := len(raw) - 1; i >= 0; i-- {
+		if id, _, ok := keyOf(raw[i]); !ok || id != lastID {
+			break
+		}
+		ties++
+	}
+	if ties == len(raw) && tok.Mode == pageModeKeyset && strings.Join(tok.Last, "\x00") == lastID {
+		ties += tok.Ties
+	}
+	next.Mode, next.Last, next.Ties = pageModeKeyset, last, ties
+	return next
+}
+
+// askPage runs one page for the ask tool, or takes it from the result
+// cache, and signs the token for the next.
+func (s *Server) askPage(ctx context.Context, req *mcp.CallToolRequest, tok pageToken, exactCount bool) (askOutput, error) {
+	shape := tok
+	shape.SQL, shape.FP, shape.Issued = "", "", 0
+	res, cachedAt, err := cachedQuery(s, req, "page", tok.SQL, nil, nil, []any{shape, exactCount}, func() (*PaginatedResult, error) {
+		return s.runPaginatedQuery(ctx, tok, exactCount)
+	})
+	if err != nil {
+		return askOutput{SQL: tok.SQL}, err
+	}
+	out := askOutput{
+		SQL:        tok.SQL,
+		Replica:    res.Replica,
+		Columns:    res.Columns,
+		Rows:       res.Rows,
+		Page:       res.Page,
+		PageSize:   res.PageSize,
+		TotalCount: res.TotalCount,
+		HasMore:    res.HasMore,
+		NextPage:   res.NextPage,
+		Cached:     cachedAt != nil,
+		CachedAt:   cachedAt,
+	}
+	if res.Next != nil {
+		if out.NextCursor, err = s.pages.Sign(*res.Next); err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestPageTokenSignVerify(t *testing.T) {
+	signer, err := newPageSigner("0123456789abcdef-test")
+	if err != nil {
+		t.Fatalf("newPageSigner: %v", err)
+	}
+	tok := pageToken{SQL: "SELECT id FROM users ORDER BY id", Page: 1, Size: 10, Mode: pageModeKeyset,
+		Keys: []string{"id"}, Types: []string{"int4"}, Last: []string{"10"}}
+	s, err := signer.Sign(tok)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	got, err := signer.Verify(s)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got.SQL != tok.SQL || got.Last[0] != "10" || got.FP != sqlFingerprint(tok.SQL) {
+		t.Fatalf("round trip mismatch: %+v", got)
+	}
+
+	// Any change to the payload invalidates the signature
+	payload, sig, _ := strings.Cut(s, ".")
+	tampered := payload[:len(payload)-2] + "AA." + sig
+	if _, err := signer.Verify(tampered); err == nil {
+		t.Fatalf("tampered token accepted")
+	}
+	other, _ := newPageSigner("another-secret-of-16")
+	if _, err := other.Verify(s); err == nil {
+		t.Fatalf("token accepted under a different key")
+	}
+	if _, err := signer.Verify("garbage"); err == nil {
+		t.Fatalf("garbage token accepted")
+	}
+
+	tok.Issued = time.Now().Add(-pageTokenTTL - time.Minute).Unix()
+	old, _ := signer.Sign(tok)
+	if _, err := signer.Verify(old); err == nil {
+		t.Fatalf("expired token accepted")
+	}
+}
+
+func TestParseOrderBy(t *testing.T) {
+	tests := []struct {
+		sql  string
+		cols []string
+		desc bool
+		ok   bool
+	}{
+		{"SELECT id FROM users ORDER BY id", []string{"id"}, false, true},
+		{"SELECT * FROM t ORDER BY t.created_at DESC, t.id DESC LIMIT 50;", []string{"created_at", "id"}, true, true},
+		{`SELECT * FROM "Book" b ORDER BY b."Title" ASC`, []string{"Title"}, false, true},
+		{"SELECT * FROM t ORDER BY a ASC, b DESC", nil, false, false},
+		{"SELECT * FROM t ORDER BY lower(name)", nil, false, false},
+		{"SELECT * FROM t ORDER BY 1", nil, false, false},
+		{"SELECT * FROM t ORDER BY a NULLS FIRST", nil, false, false},
+		{"SELECT * FROM t", nil, false, false},
+	}
+	for _, tt := range tests {
+		cols, desc, ok := parseOrderBy(tt.sql)
+		if ok != tt.ok || desc != tt.desc || strings.Join(cols, ",") != strings.Join(tt.cols, ",") {
+			t.Fatalf("parseOrderBy(%q) = %v %v %v, want %v %v %v", tt.sql, cols, desc, ok, tt.cols, tt.desc, tt.ok)
+		}
+	}
+}
+
+func TestPageSQL(t *testing.T) {
+	q, args := pageSQL(pageToken{SQL: "SELECT id FROM users;", Size: 10, Offset: 20})
+	if q != "SELECT * FROM (SELECT id FROM users) q LIMIT 11 OFFSET 20" || args != nil {
+		t.Fatalf("offset page: %q %v", q, args)
+	}
+
+	tok := pageToken{SQL: "SELECT * FROM t ORDER BY a DESC, b DESC", Size: 5, Mode: pageModeKeyset,
+		Keys: []string{"a", "b"}, Types: []string{"timestamptz", "int8"}, Desc: true, Last: []string{"2024-01-01 00:00:00Z", "7"}, Ties: 2}
+	q, args = pageSQL(tok)
+	want := `WHERE (q."a", q."b") <= ($1::text::timestamptz, $2::text::int8) ORDER BY q."a" DESC, q."b" DESC LIMIT 6 OFFSET 2`
+	if !strings.HasSuffix(q, want) || len(args) != 2 || args[1] != "7" {
+		t.Fatalf("keyset page: %q %v", q, args)
+	}
+
+	// Keys without a position yet: ordered by the keys, positioned by offset
+	tok.Mode, tok.Last, tok.Offset = pageModeOffset, nil, 5
+	if q, _ = pageSQL(tok); !strings.HasSuffix(q, `q ORDER BY q."a" DESC, q."b" DESC LIMIT 6 OFFSET 5`) {
+		t.Fatalf("keyed offset page: %q", q)
+	}
+}
+
+func TestNextPageToken(t *testing.T) {
+	flds := []pgconn.FieldDescription{{Name: "id"}, {Name: "grp"}}
+	tok := pageToken{SQL: "SELECT id, grp FROM t ORDER BY grp", Size: 3, Mode: pageModeOffset, Keys: []string{"grp"}, Types: []string{"int4"}}
+	raw := [][]any{{int32(1), int32(1)}, {int32(2), int32(2)}, {int32(3), int32(2)}}
+
+	next := nextPageToken(tok, flds, raw)
+	if next.Mode != pageModeKeyset || next.Last[0] != "2" || next.Ties != 2 || next.Offset != 3 || next.Page != 1 {
+		t.Fatalf("unexpected next token: %+v", next)
+	}
+
+	// A page made entirely of ties accumulates onto the previous tie count
+	raw = [][]any{{int32(4), int32(2)}, {int32(5), int32(2)}, {int32(6), int32(2)}}
+	if n2 := nextPageToken(next, flds, raw); n2.Ties != 5 || n2.Offset != 6 {
+		t.Fatalf("ties not accumulated: %+v", n2)
+	}
+
+	// Without keys the next page is a plain offset
+	tok.Keys, tok.Types = nil, nil
+	if n := nextPageToken(tok, flds, raw); n.Mode != pageModeOffset || n.Offset != 3 {
+		t.Fatalf("expected offset continuation: %+v", n)
+	}
+}
+
+func TestKeyText(t *testing.T) {
+	ts := time.Date(2024, 3, 5, 10, 30, 0, 123000000, time.UTC)
+	tests := []struct {
+		v    any
+		typ  string
+		want string
+	}{
+		{int64(42), "int8", "42"},
+		{"abc", "text", "abc"},
+		{ts, "date", "2024-03-05"},
+		{ts, "timestamp", "2024-03-05 10:30:00.123"},
+		{ts, "timestamptz", "2024-03-05 10:30:00.123Z"},
+		{[16]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 1, 2, 3, 4, 5, 6, 7, 8}, "uuid", "12345678-9abc-def0-0102-030405060708"},
+	}
+	for _, tt := range tests {
+		if got, ok := keyText(tt.v, tt.typ); !ok || got != tt.want {
+			t.Fatalf("keyText(%v, %s) = %q %v, want %q", tt.v, tt.typ, got, ok, tt.want)
+		}
+	}
+	if _, ok := keyText(nil, "int4"); ok {
+		t.Fatalf("NULL keys must not be keyset-able")
+	}
+}
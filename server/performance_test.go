@@ -272,12 +272,13 @@ func TestMemoryUsage(t *testing.T) {
 		sql := "SELECT id, name, value FROM large_test_table ORDER BY id"
 
 		start := time.Now()
-		pages, totalCount, err := srv.runStreamingQuery(ctx, sql, 10, 100) // 1000 rows
+		res, err := srv.runStreamingQuery(ctx, sql, 10, 100, true, nil) // 1000 rows
 		duration := time.Since(start)
 
 		if err != nil {
 			t.Fatalf("runStreamingQuery: %v", err)
 		}
+		pages, totalCount := res.Pages, res.Total
 
 		if totalCount < 1000 {
 			t.Fatalf("expected at least 1000 records, got %d", totalCount)
@@ -0,0 +1,35 @@
+// server/progress.go
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/rs/zerolog/log"
+)
+
+// progressFunc returns a callback that sends notifications/progress for
+// the request, or nil when the client did not ask for progress. fetched is
+// the number of rows read so far and total the number that will be read,
+// or 0 when that is not known, in which case no total is sent.
+func progressFunc(ctx context.Context, req *mcp.CallToolRequest, tool string) func(fetched, total int) {
+	if req == nil || req.Session == nil || req.Params == nil {
+		return nil
+	}
+	token := req.Params.GetProgressToken()
+	if token == nil {
+		return nil
+	}
+	return func(fetched, total int) {
+		err := req.Session.NotifyProgress(ctx, &mcp.ProgressNotificationParams{
+			ProgressToken: token,
+			Message:       fmt.Sprintf("%s: %d rows fetched", tool, fetched),
+			Progress:      float64(fetched),
+			Total:         float64(total),
+		})
+		if err != nil {
+			log.Debug().Str("tool", tool).Err(err).Msg("progress notification failed")
+		}
+	}
+}
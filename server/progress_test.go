@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestProgressFuncWithoutToken(t *testing.T) {
+	if progressFunc(context.Background(), nil, "ask") != nil {
+		t.Fatalf("nil request should not report progress")
+	}
+	req := &mcp.CallToolRequest{Params: &mcp.CallToolParamsRaw{Name: "ask"}}
+	if progressFunc(context.Background(), req, "ask") != nil {
+		t.Fatalf("request without session or token should not report progress")
+	}
+}
+
+func TestProgressNotifications(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "test"}, nil)
+	mcp.AddTool(server, &mcp.Tool{Name: "pages"}, func(ctx context.Context, req *mcp.CallToolRequest, _ struct{}) (*mcp.CallToolResult, struct{}, error) {
+		if progress := progressFunc(ctx, req, "pages"); progress != nil {
+			for fetched := 50; fetched <= 150; fetched += 50 {
+				progress(fetched, 1000)
+			}
+		}
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: "ok"}}}, struct{}{}, nil
+	})
+
+	var mu sync.Mutex
+	var got []*mcp.ProgressNotificationParams
+	done := make(chan struct{})
+	client := mcp.NewClient(&mcp.Implementation{Name: "test-client"}, &mcp.ClientOptions{
+		ProgressNotificationHandler: func(_ context.Context, req *mcp.ProgressNotificationClientRequest) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, req.Params)
+			if len(got) == 3 {
+				close(done)
+			}
+		},
+	})
+
+	ct, st := mcp.NewInMemoryTransports()
+	ss, err := server.Connect(ctx, st, nil)
+	if err != nil {
+		t.Fatalf("server connect: %v", err)
+	}
+	defer ss.Close()
+	cs, err := client.Connect(ctx, ct, nil)
+	if err != nil {
+		t.Fatalf("client connect: %v", err)
+	}
+	defer cs.Close()
+
+	// SetProgressToken does not allocate Meta, so set the token directly
+	params := &mcp.CallToolParams{Name: "pages", Arguments: map[string]any{}, Meta: mcp.Meta{"progressToken": "tok-1"}}
+	if _, err := cs.CallTool(ctx, params); err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for progress, got %d notifications", len(got))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, p := range got {
+		if p.ProgressToken != "tok-1" || p.Progress != float64(50*(i+1)) || p.Total != 1000 {
+			t.Fatalf("notification %d mismatch: %+v", i, p)
+		}
+	}
+}
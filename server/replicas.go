@@ -0,0 +1,211 @@
+// server/replicas.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultReplicaMaxLag = 10 * time.Second
+	replicaCheckInterval = 5 * time.Second
+	replicaCheckTimeout  = 3 * time.Second
+	primaryName          = "primary"
+	replicaLagUnknown    = time.Duration(-1)
+
+	// A WAL receiver that has heard nothing from the primary for this long
+	// is treated as disconnected. Senders send a keepalive at least every
+	// wal_sender_timeout/2, 30s by default.
+	replicaReceiverTimeout = time.Minute
+)
+
+// replicaStatusSQL reads what replicaStatus.lag needs. pg_stat_wal_receiver
+// has no row when the receiver is not running, and shows its status only to
+// superusers and members of pg_read_all_stats; both read as not streaming.
+const replicaStatusSQL = `
+SELECT pg_is_in_recovery(),
+  coalesce((SELECT status = 'streaming' FROM pg_stat_wal_receiver), false),
+  (SELECT extract(epoch FROM now() - last_msg_receipt_time)::float8 FROM pg_stat_wal_receiver),
+  coalesce(pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn(), false),
+  extract(epoch FROM now() - pg_last_xact_replay_timestamp())::float8`
+
+// replicaStatus is a standby's replication state as of a lag check.
+type replicaStatus struct {
+	inRecovery bool
+	streaming  bool     // the WAL receiver is connected and streaming
+	receiptAge *float64 // seconds since the receiver last heard from the primary
+	caughtUp   bool     // everything received has been replayed
+	replayAge  *float64 // seconds since the last replayed transaction committed
+}
+
+// lag reports how far behind the primary the standby is. A standby that is
+// streaming, has heard from the primary recently and has replayed
+// everything it received counts as current even if the last replayed
+// transaction is old, which is normal on an idle primary. Otherwise, as when
+// the receiver has disconnected and the received WAL is simply all there is,
+// the lag is the age of the last replayed transaction. A server that is not
+// in recovery is never behind.
+func (st replicaStatus) lag() time.Duration {
+	switch {
+	case !st.inRecovery:
+		return 0
+	case st.streaming && st.caughtUp && st.receiptAge != nil && *st.receiptAge < replicaReceiverTimeout.Seconds():
+		return 0
+	case st.replayAge == nil:
+		// Nothing replayed yet, so there is no way to tell how far behind it is
+		return replicaLagUnknown
+	}
+	return time.Duration(*st.replayAge * float64(time.Second)).Round(time.Millisecond)
+}
+
+// replica is one read replica pool and its last known lag.
+type replica struct {
+	name string // host:port/database, for responses and logs
+	pool *pgxpool.Pool
+
+	mu      sync.Mutex
+	healthy bool
+	lag     time.Duration // replicaLagUnknown if the last check failed
+	checked time.Time
+}
+
+// replicaSet load-balances reads across the replicas that are within
+// maxLag of the primary. A nil set has no replicas.
+type replicaSet struct {
+	replicas []*replica
+	maxLag   time.Duration
+	next     atomic.Uint64
+}
+
+// replicaName identifies a replica without its credentials.
+func replicaName(conf *pgxpool.Config) string {
+	return fmt.Sprintf("%s:%d/%s", conf.ConnConfig.Host, conf.ConnConfig.Port, conf.ConnConfig.Database)
+}
+
+// newReplicaSet opens a pool per replica URL with the same settings as the primary. Replicas start unhealthy and are
+// admitted by the first lag check, which runs before this returns.
+func newReplicaSet(ctx context.Context, cfg Config) (*replicaSet, error) {
+	if len(cfg.ReplicaURLs) == 0 {
+		return nil, nil
+	}
+	maxLag := cfg.ReplicaMaxLag
+	if maxLag == 0 {
+		maxLag = defaultReplicaMaxLag
+	}
+	rs := &replicaSet{maxLag: maxLag}
+	for _, u := range cfg.ReplicaURLs {
+		conf, err := poolConfig(u, cfg)
+		if err != nil {
+			rs.Close()
+			return nil, fmt.Errorf("replica %q: %w", u, err)
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, conf)
+		if err != nil {
+			rs.Close()
+			return nil, fmt.Errorf("replica %s: %w", replicaName(conf), err)
+		}
+		rs.replicas = append(rs.replicas, &replica{name: replicaName(conf), pool: pool, lag: replicaLagUnknown})
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+	defer cancel()
+	rs.checkAll(checkCtx)
+	return rs, nil
+}
+
+// pick returns the next healthy replica in round-robin order, or primary
+// when none is healthy. The name is empty when no replicas are configured.
+func (rs *replicaSet) pick(primary *pgxpool.Pool) (*pgxpool.Pool, string) {
+	if rs == nil || len(rs.replicas) == 0 {
+		return primary, ""
+	}
+	healthy := make([]*replica, 0, len(rs.replicas))
+	for _, r := range rs.replicas {
+		r.mu.Lock()
+		if r.healthy {
+			healthy = append(healthy, r)
+		}
+		r.mu.Unlock()
+	}
+	if len(healthy) == 0 {
+		return primary, primaryName
+	}
+	r := healthy[rs.next.Add(1)%uint64(len(healthy))]
+	return r.pool, r.name
+}
+
+// record updates r after a lag check; lag is replicaLagUnknown if the check failed.
+func (rs *replicaSet) record(r *replica, lag time.Duration, err error) {
+	healthy := err == nil && lag != replicaLagUnknown && lag <= rs.maxLag
+	r.mu.Lock()
+	was := r.healthy
+	r.healthy, r.lag, r.checked = healthy, lag, time.Now()
+	r.mu.Unlock()
+
+	switch {
+	case was && !healthy:
+		ev := log.Warn().Str("replica", r.name).Dur("max_lag", rs.maxLag)
+		if err != nil {
+			ev = ev.Err(err)
+		} else if lag != replicaLagUnknown {
+			ev = ev.Dur("lag", lag)
+		}
+		ev.Msg("replica removed from rotation")
+	case !was && healthy:
+		log.Info().Str("replica", r.name).Dur("lag", lag).Msg("replica in rotation")
+	}
+}
+
+func (rs *replicaSet) check(ctx context.Context, r *replica) {
+	ctxTO, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+	defer cancel()
+	var st replicaStatus
+	if err := r.pool.QueryRow(ctxTO, replicaStatusSQL).Scan(&st.inRecovery, &st.streaming, &st.receiptAge, &st.caughtUp, &st.replayAge); err != nil {
+		rs.record(r, replicaLagUnknown, err)
+		return
+	}
+	rs.record(r, st.lag(), nil)
+}
+
+func (rs *replicaSet) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range rs.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			rs.check(ctx, r)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// Run re-checks replica lag until ctx is done.
+func (rs *replicaSet) Run(ctx context.Context) {
+	if rs == nil {
+		return
+	}
+	t := time.NewTicker(replicaCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			rs.checkAll(ctx)
+		}
+	}
+}
+
+func (rs *replicaSet) Close() {
+	if rs == nil {
+		return
+	}
+	for _, r := range rs.replicas {
+		r.pool.Close()
+	}
+}
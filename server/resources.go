@@ -0,0 +1,267 @@
+// server/resources.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	schemaResourceURI     = "pgmcp://schema"
+	tableResourceTemplate = "pgmcp://schema/{schema}/{table}"
+	rowResourcePrefix     = "pgmcp://row/"
+	rowResourceTemplate   = "pgmcp://row/{schema}/{table}/{key}"
+	maxCompletionValues   = 100 // MCP caps completion responses at 100 values
+)
+
+var (
+	schemaResource = &mcp.Resource{
+		URI:         schemaResourceURI,
+		Name:        "schema",
+		Title:       "Database schema overview",
+		Description: "Every table with its columns, keys, foreign keys, constraints, indexes and enum types, one line per object.",
+		MIMEType:    "text/plain",
+	}
+	tableResource = &mcp.ResourceTemplate{
+		URITemplate: tableResourceTemplate,
+		Name:        "table",
+		Title:       "Table details",
+		Description: "Columns, keys, foreign keys, indexes, comments and row estimate of a single table.",
+		MIMEType:    "application/json",
+	}
+	rowResource = &mcp.ResourceTemplate{
+		URITemplate: rowResourceTemplate,
+		Name:        "row",
+		Title:       "Table row",
+		Description: "One row, identified by its primary key (or ctid, plus tableoid when partitioned, for tables and materialized views without one), as returned in search results' row_uri.",
+		MIMEType:    "application/json",
+	}
+)
+
+// registerResources adds the schema resource and the table and row templates to server.
+func (s *Server) registerResources(server *mcp.Server) {
+	server.AddResource(schemaResource, s.handleSchemaResource)
+	server.AddResourceTemplate(tableResource, s.handleTableResource)
+	server.AddResourceTemplate(rowResource, s.handleRowResource)
+}
+
+// notifySchemaChanged tells connected sessions that the schema moved on.
+// The SDK only broadcasts resources/list_changed when a resource is
+// (re)registered, so re-adding the schema resource, which the SDK swaps in
+// place under its lock, sends exactly one. Subscribers additionally get
+// resources/updated for each affected URI.
+func (s *Server) notifySchemaChanged(server *mcp.Server, old, cur *SchemaModel) {
+	server.AddResource(schemaResource, s.handleSchemaResource)
+
+	ctx := context.Background()
+	_ = server.ResourceUpdated(ctx, &mcp.ResourceUpdatedNotificationParams{URI: schemaResourceURI})
+	for _, t := range changedTables(old, cur) {
+		_ = server.ResourceUpdated(ctx, &mcp.ResourceUpdatedNotificationParams{URI: tableResourceURI(t.Schema, t.Name)})
+	}
+}
+
+// handleSubscribe accepts subscriptions to the schema resource and to table resources.
+func (s *Server) handleSubscribe(ctx context.Context, req *mcp.SubscribeRequest) error {
+	uri := req.Params.URI
+	if uri == schemaResourceURI {
+		return nil
+	}
+	if _, _, ok := parseTableResourceURI(uri); ok {
+		return nil
+	}
+	return mcp.ResourceNotFoundError(uri)
+}
+
+// tableResourceURI returns the concrete resource URI for a table. Names
+// are escaped down to unreserved characters, as the template's variables
+// match nothing else.
+func tableResourceURI(schema, table string) string {
+	return "pgmcp://schema/" + escapeURIComponent(schema) + "/" + escapeURIComponent(table)
+}
+
+// parseTableResourceURI extracts the schema and table names from a table resource URI.
+func parseTableResourceURI(uri string) (schema, table string, ok bool) {
+	rest, found := strings.CutPrefix(uri, schemaResourceURI+"/")
+	if !found {
+		return "", "", false
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	schema, err1 := url.PathUnescape(parts[0])
+	table, err2 := url.PathUnescape(parts[1])
+	if err1 != nil || err2 != nil || schema == "" || table == "" {
+		return "", "", false
+	}
+	return schema, table, true
+}
+
+// rowResourceURI returns the resource URI of the row of schema.table with
+// the given key, e.g. pgmcp://row/public/orders/id%3D42. The key is encoded
+// as a query string and then escaped as a whole, since the template's
+// {key} only matches unreserved characters and percent escapes.
+func rowResourceURI(schema, table string, key map[string]string) string {
+	vals := url.Values{}
+	for k, v := range key {
+		vals.Set(k, v)
+	}
+	return rowResourcePrefix + escapeURIComponent(schema) + "/" + escapeURIComponent(table) + "/" + escapeURIComponent(vals.Encode())
+}
+
+// parseRowResourceURI extracts the schema, table and key from a row resource URI.
+func parseRowResourceURI(uri string) (schema, table string, key map[string]string, ok bool) {
+	rest, found := strings.CutPrefix(uri, rowResourcePrefix)
+	if !found {
+		return "", "", nil, false
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 {
+		return "", "", nil, false
+	}
+	schema, err1 := url.PathUnescape(parts[0])
+	table, err2 := url.PathUnescape(parts[1])
+	rawKey, err3 := url.PathUnescape(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil || schema == "" || table == "" {
+		return "", "", nil, false
+	}
+	vals, err := url.ParseQuery(rawKey)
+	if err != nil || len(vals) == 0 {
+		return "", "", nil, false
+	}
+	key = make(map[string]string, len(vals))
+	for k, v := range vals {
+		if len(v) != 1 {
+			return "", "", nil, false
+		}
+		key[k] = v[0]
+	}
+	return schema, table, key, true
+}
+
+// escapeURIComponent percent-encodes everything but unreserved characters.
+func escapeURIComponent(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func (s *Server) handleSchemaResource(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+	model, err := s.cache.Model(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	return &mcp.ReadResourceResult{Contents: []*mcp.ResourceContents{{
+		URI:      schemaResourceURI,
+		MIMEType: "text/plain",
+		Text:     model.RenderPrompt(),
+	}}}, nil
+}
+
+func (s *Server) handleTableResource(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+	uri := req.Params.URI
+	schema, table, ok := parseTableResourceURI(uri)
+	if !ok {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+	model, err := s.cache.Model(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	t := model.Table(schema, table)
+	if t == nil {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &mcp.ReadResourceResult{Contents: []*mcp.ResourceContents{{
+		URI:      uri,
+		MIMEType: "application/json",
+		Text:     string(b),
+	}}}, nil
+}
+
+func (s *Server) handleRowResource(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+	uri := req.Params.URI
+	schema, table, key, ok := parseRowResourceURI(uri)
+	if !ok {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+	release, err := s.admit(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	res, err := s.fetchRow(ctx, schema, table, key)
+	if errors.Is(err, errRowNotFound) {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(res.Rows[0], "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &mcp.ReadResourceResult{Contents: []*mcp.ResourceContents{{
+		URI:      uri,
+		MIMEType: "application/json",
+		Text:     string(b),
+	}}}, nil
+}
+
+// handleComplete completes the {schema} and {table} parameters of the table resource template.
+func (s *Server) handleComplete(ctx context.Context, req *mcp.CompleteRequest) (*mcp.CompleteResult, error) {
+	res := &mcp.CompleteResult{Completion: mcp.CompletionResultDetails{Values: []string{}}}
+	p := req.Params
+	if p.Ref == nil || p.Ref.Type != "ref/resource" || p.Ref.URI != tableResourceTemplate {
+		return res, nil
+	}
+	model, err := s.cache.Model(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var schemaArg string
+	if p.Context != nil {
+		schemaArg = p.Context.Arguments["schema"]
+	}
+	seen := map[string]bool{}
+	var values []string
+	for _, t := range model.Tables {
+		var v string
+		switch p.Argument.Name {
+		case "schema":
+			v = t.Schema
+		case "table":
+			if schemaArg != "" && t.Schema != schemaArg {
+				continue
+			}
+			v = t.Name
+		default:
+			return res, nil
+		}
+		if seen[v] || !strings.HasPrefix(strings.ToLower(v), strings.ToLower(p.Argument.Value)) {
+			continue
+		}
+		seen[v] = true
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	res.Completion.Total = len(values)
+	if len(values) > maxCompletionValues {
+		values = values[:maxCompletionValues]
+		res.Completion.HasMore = true
+	}
+	if values != nil {
+		res.Completion.Values = values
+	}
+	return res, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/yosida95/uritemplate/v3"
+)
+
+func TestTableResourceURIRoundTrip(t *testing.T) {
+	tests := []struct{ schema, table string }{
+		{"public", "users"},
+		{"public", "Categories"},
+		{"sales data", "order/items"},
+		{"a$b", "x:y+z&w=v@q"},
+	}
+	for _, tt := range tests {
+		uri := tableResourceURI(tt.schema, tt.table)
+		s, tbl, ok := parseTableResourceURI(uri)
+		if !ok || s != tt.schema || tbl != tt.table {
+			t.Fatalf("round trip of %s.%s via %q gave %q.%q ok=%v", tt.schema, tt.table, uri, s, tbl, ok)
+		}
+		// The SDK only routes URIs its template matches
+		if !uritemplate.MustNew(tableResourceTemplate).Regexp().MatchString(uri) {
+			t.Fatalf("%q does not match %s", uri, tableResourceTemplate)
+		}
+	}
+	if got := tableResourceURI("a$b", "c"); got != "pgmcp://schema/a%24b/c" {
+		t.Fatalf("got %q", got)
+	}
+
+	for _, bad := range []string{"pgmcp://schema", "pgmcp://schema/public", "pgmcp://schema/a/b/c", "file:///etc/passwd"} {
+		if _, _, ok := parseTableResourceURI(bad); ok {
+			t.Fatalf("parseTableResourceURI accepted %q", bad)
+		}
+	}
+}
+
+func TestRowResourceURIRoundTrip(t *testing.T) {
+	tests := []struct {
+		schema, table string
+		key           map[string]string
+	}{
+		{"public", "orders", map[string]string{"id": "42"}},
+		{"public", "order_items", map[string]string{"order_id": "1", "item_id": "2"}},
+		{"sales data", "a/b", map[string]string{"code": "x&y=z %2F+"}},
+		{"public", "logs", map[string]string{"ctid": "(0,7)"}},
+	}
+	for _, tt := range tests {
+		uri := rowResourceURI(tt.schema, tt.table, tt.key)
+		s, tbl, key, ok := parseRowResourceURI(uri)
+		if !ok || s != tt.schema || tbl != tt.table || !reflect.DeepEqual(key, tt.key) {
+			t.Fatalf("round trip via %q gave %q.%q %v ok=%v", uri, s, tbl, key, ok)
+		}
+		// The SDK only routes URIs its template matches
+		if !uritemplate.MustNew(rowResourceTemplate).Regexp().MatchString(uri) {
+			t.Fatalf("%q does not match %s", uri, rowResourceTemplate)
+		}
+	}
+	if got := rowResourceURI("public", "order_items", map[string]string{"order_id": "1", "item_id": "2"}); got != "pgmcp://row/public/order_items/item_id%3D2%26order_id%3D1" {
+		t.Fatalf("got %q", got)
+	}
+
+	for _, bad := range []string{"pgmcp://row/public/orders", "pgmcp://row/public/orders/", "pgmcp://row/a/b/c/d", "pgmcp://row/public/orders/id%3D1%26id%3D2", tableResourceURI("public", "orders")} {
+		if _, _, _, ok := parseRowResourceURI(bad); ok {
+			t.Fatalf("parseRowResourceURI accepted %q", bad)
+		}
+	}
+}
+
+func TestHandleTableResource(t *testing.T) {
+	srv := serverWithModel(testSchemaModel())
+	ctx := context.Background()
+
+	uri := tableResourceURI("public", "order_items")
+	res, err := srv.handleTableResource(ctx, &mcp.ReadResourceRequest{Params: &mcp.ReadResourceParams{URI: uri}})
+	if err != nil {
+		t.Fatalf("handleTableResource: %v", err)
+	}
+	if len(res.Contents) != 1 || res.Contents[0].MIMEType != "application/json" {
+		t.Fatalf("unexpected contents: %+v", res.Contents)
+	}
+	var tbl Table
+	if err := json.Unmarshal([]byte(res.Contents[0].Text), &tbl); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if tbl.Name != "order_items" || len(tbl.Columns) != 4 || tbl.PrimaryKey == nil {
+		t.Fatalf("unexpected table: %+v", tbl)
+	}
+
+	_, err = srv.handleTableResource(ctx, &mcp.ReadResourceRequest{Params: &mcp.ReadResourceParams{URI: tableResourceURI("public", "missing")}})
+	if err == nil {
+		t.Fatalf("expected not-found error for missing table")
+	}
+}
+
+func TestHandleSchemaResource(t *testing.T) {
+	srv := serverWithModel(testSchemaModel())
+	res, err := srv.handleSchemaResource(context.Background(), &mcp.ReadResourceRequest{Params: &mcp.ReadResourceParams{URI: schemaResourceURI}})
+	if err != nil {
+		t.Fatalf("handleSchemaResource: %v", err)
+	}
+	if !strings.Contains(res.Contents[0].Text, "TABLE public.order_items(") {
+		t.Fatalf("overview missing table line:\n%s", res.Contents[0].Text)
+	}
+}
+
+func TestHandleComplete(t *testing.T) {
+	srv := serverWithModel(testSchemaModel())
+	ctx := context.Background()
+	complete := func(arg, value string, args map[string]string) []string {
+		t.Helper()
+		params := &mcp.CompleteParams{
+			Ref:      &mcp.CompleteReference{Type: "ref/resource", URI: tableResourceTemplate},
+			Argument: mcp.CompleteParamsArgument{Name: arg, Value: value},
+		}
+		if args != nil {
+			params.Context = &mcp.CompleteContext{Arguments: args}
+		}
+		res, err := srv.handleComplete(ctx, &mcp.CompleteRequest{Params: params})
+		if err != nil {
+			t.Fatalf("handleComplete: %v", err)
+		}
+		return res.Completion.Values
+	}
+
+	if got := complete("schema", "pu", nil); len(got) != 1 || got[0] != "public" {
+		t.Fatalf("schema completion = %v", got)
+	}
+	if got := complete("table", "", map[string]string{"schema": "public"}); len(got) != 2 || got[0] != "Categories" {
+		t.Fatalf("table completion = %v", got)
+	}
+	if got := complete("table", "cat", nil); len(got) != 1 || got[0] != "Categories" {
+		t.Fatalf("case-insensitive table completion = %v", got)
+	}
+	if got := complete("table", "", map[string]string{"schema": "other"}); len(got) != 0 {
+		t.Fatalf("expected no tables in unknown schema, got %v", got)
+	}
+}
+
+func TestNotifySchemaChanged(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv := serverWithModel(testSchemaModel())
+	server := mcp.NewServer(&mcp.Implementation{Name: "test"}, nil)
+	srv.registerResources(server)
+
+	var listChanged atomic.Int32
+	changed := make(chan struct{}, 8)
+	client := mcp.NewClient(&mcp.Implementation{Name: "test-client"}, &mcp.ClientOptions{
+		ResourceListChangedHandler: func(context.Context, *mcp.ResourceListChangedRequest) {
+			listChanged.Add(1)
+			changed <- struct{}{}
+		},
+	})
+	ct, st := mcp.NewInMemoryTransports()
+	ss, err := server.Connect(ctx, st, nil)
+	if err != nil {
+		t.Fatalf("server connect: %v", err)
+	}
+	defer ss.Close()
+	cs, err := client.Connect(ctx, ct, nil)
+	if err != nil {
+		t.Fatalf("client connect: %v", err)
+	}
+	defer cs.Close()
+
+	srv.notifySchemaChanged(server, testSchemaModel(), testSchemaModel())
+	select {
+	case <-changed:
+	case <-ctx.Done():
+		t.Fatal("no resources/list_changed received")
+	}
+	// Notifications sent before this round trip have arrived by its end
+	res, err := cs.ListResources(ctx, nil)
+	if err != nil {
+		t.Fatalf("ListResources: %v", err)
+	}
+	if n := listChanged.Load(); n != 1 {
+		t.Fatalf("got %d list_changed notifications, want 1", n)
+	}
+	if len(res.Resources) != 1 || res.Resources[0].URI != schemaResourceURI {
+		t.Fatalf("resources after the change: %+v", res.Resources)
+	}
+	tmpl, err := cs.ListResourceTemplates(ctx, nil)
+	if err != nil || len(tmpl.ResourceTemplates) != 2 {
+		t.Fatalf("templates after the change: %+v %v", tmpl, err)
+	}
+}
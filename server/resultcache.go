@@ -0,0 +1,645 @@
+// server/resultcache.go
+package main
+
+import (
+	"cmp"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultResultCacheSize = 256
+	defaultCacheChannel    = "pgmcp_cache"
+	cacheStatsInterval     = 5 * time.Second
+	cacheListenRetry       = 5 * time.Second
+	cacheExplainMax        = 2 // EXPLAINs run at once to find what a result read
+)
+
+// RESULT_CACHE_SCOPE values. Client-scoped entries are only served to the
+// session that stored them.
+const (
+	cacheScopeClient = "client"
+	cacheScopeShared = "shared"
+)
+
+// RESULT_CACHE_INVALIDATION values. With ttl, entries only expire.
+const (
+	cacheInvalidateTTL    = "ttl"
+	cacheInvalidateStats  = "stats"
+	cacheInvalidateNotify = "notify"
+)
+
+// tableTTL overrides the cache TTL for results that read matching tables.
+type tableTTL struct {
+	pattern objectPattern
+	ttl     time.Duration
+}
+
+// parseTableTTLs parses RESULT_CACHE_TABLE_TTLS entries of the form
+// pattern=duration, where pattern is a table pattern as in TABLE_INCLUDE.
+func parseTableTTLs(entries []string) ([]tableTTL, error) {
+	out := make([]tableTTL, 0, len(entries))
+	for _, e := range entries {
+		pat, dur, ok := strings.Cut(e, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not pattern=duration", e)
+		}
+		p, err := parseObjectPattern(strings.TrimSpace(pat))
+		if err != nil {
+			return nil, err
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(dur))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid duration in %q", e)
+		}
+		out = append(out, tableTTL{pattern: p, ttl: d})
+	}
+	return out, nil
+}
+
+type cacheEntry struct {
+	key     string
+	value   any
+	tables  []string // schema.table of every relation the query read
+	stored  time.Time
+	expires time.Time
+}
+
+// resultCache is a bounded LRU of query results keyed by normalized SQL,
+// parameters, result shape and, unless shared, the client. An entry lives
+// for the smallest TTL of the tables it read and can be dropped early when
+// one of them changes.
+type resultCache struct {
+	ttl          time.Duration
+	tableTTLs    []tableTTL
+	size         int
+	shared       bool
+	invalidation string
+	channel      string
+
+	mu      sync.Mutex
+	lru     *list.List // *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	changed map[string]time.Time // when each table was last seen to change
+	flushed time.Time
+
+	explains chan struct{} // one token per running queryTables
+
+	hits, misses, evictions, invalidated, skipped atomic.Int64
+}
+
+// newResultCache returns nil when caching is disabled (RESULT_CACHE_TTL is 0).
+func newResultCache(cfg Config) (*resultCache, error) {
+	if cfg.ResultCacheTTL <= 0 {
+		return nil, nil
+	}
+	ttls, err := parseTableTTLs(cfg.ResultCacheTableTTLs)
+	if err != nil {
+		return nil, err
+	}
+	size := cfg.ResultCacheSize
+	if size == 0 {
+		size = defaultResultCacheSize
+	}
+	channel := cfg.ResultCacheChannel
+	if channel == "" {
+		channel = defaultCacheChannel
+	}
+	return &resultCache{
+		ttl:          cfg.ResultCacheTTL,
+		tableTTLs:    ttls,
+		size:         size,
+		shared:       cfg.ResultCacheScope == cacheScopeShared,
+		invalidation: cfg.ResultCacheInvalidation,
+		channel:      channel,
+		lru:          list.New(),
+		entries:      map[string]*list.Element{},
+		changed:      map[string]time.Time{},
+		explains:     make(chan struct{}, cacheExplainMax),
+	}, nil
+}
+
+// key identifies a result. kind and shape tell apart the different ways
+// the same statement is run (one page, several pages, how many rows).
+func (c *resultCache) key(req *mcp.CallToolRequest, kind, sql string, args []any, shape any) string {
+	scope := cacheScopeShared
+	if !c.shared {
+		scope = requestIdentity(req)
+	}
+	b, _ := json.Marshal([]any{scope, kind, normalizeSQL(sql), args, shape})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// ttlFor is the lifetime of a result reading tables: the smallest TTL among
+// them, each taken from the first matching RESULT_CACHE_TABLE_TTLS entry or
+// the default.
+func (c *resultCache) ttlFor(tables []string) time.Duration {
+	ttl := c.ttl
+	for _, t := range tables {
+		schema, name, _ := strings.Cut(t, ".")
+		for _, o := range c.tableTTLs {
+			if o.pattern.matchTable(schema, name) {
+				if o.ttl < ttl {
+					ttl = o.ttl
+				}
+				break
+			}
+		}
+	}
+	return ttl
+}
+
+func (c *resultCache) get(key string, now time.Time) (any, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, time.Time{}, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !now.Before(e.expires) {
+		c.removeLocked(el)
+		c.misses.Add(1)
+		return nil, time.Time{}, false
+	}
+	c.lru.MoveToFront(el)
+	c.hits.Add(1)
+	return e.value, e.stored, true
+}
+
+// put caches v for a query that started at ran and read tables. It is
+// skipped if any of those tables was seen to change since ran, since the
+// result may predate the change, or if their TTL is zero.
+func (c *resultCache) put(key string, v any, tables []string, ran time.Time) bool {
+	ttl := c.ttlFor(tables)
+	if ttl <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.flushed.Before(ran) {
+		return false
+	}
+	for _, t := range tables {
+		if !c.changed[t].Before(ran) {
+			return false
+		}
+	}
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+	}
+	now := time.Now()
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: v, tables: tables, stored: ran, expires: now.Add(ttl)})
+	for c.lru.Len() > c.size {
+		c.removeLocked(c.lru.Back())
+		c.evictions.Add(1)
+	}
+	return true
+}
+
+func (c *resultCache) removeLocked(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// invalidate drops every result that read table (schema.table) and keeps
+// results of queries already running from being stored.
+func (c *resultCache) invalidate(table string, now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changed[table] = now
+	n := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if slices.Contains(el.Value.(*cacheEntry).tables, table) {
+			c.removeLocked(el)
+			n++
+		}
+		el = next
+	}
+	c.invalidated.Add(int64(n))
+	return n
+}
+
+// flush drops everything, e.g. after a schema change or a gap in
+// invalidation events.
+func (c *resultCache) flush(now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidated.Add(int64(c.lru.Len()))
+	c.lru.Init()
+	clear(c.entries)
+	c.flushed = now
+}
+
+// resultCacheStats is the cache part of /stats.
+type resultCacheStats struct {
+	Entries      int    `json:"entries"`
+	Capacity     int    `json:"capacity"`
+	Hits         int64  `json:"hits"`
+	Misses       int64  `json:"misses"`
+	Evictions    int64  `json:"evictions"`
+	Invalidated  int64  `json:"invalidated"`
+	Skipped      int64  `json:"skipped"` // results not cached because their tables could not be found in time
+	Invalidation string `json:"invalidation"`
+}
+
+func (c *resultCache) stats() resultCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return resultCacheStats{
+		Entries:      c.lru.Len(),
+		Capacity:     c.size,
+		Hits:         c.hits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		Invalidated:  c.invalidated.Load(),
+		Skipped:      c.skipped.Load(),
+		Invalidation: cmp.Or(c.invalidation, cacheInvalidateTTL),
+	}
+}
+
+// Run keeps cached results in step with the database in the configured
+// invalidation mode until ctx is done.
+func (c *resultCache) Run(ctx context.Context, db *pgxpool.Pool) {
+	if c == nil {
+		return
+	}
+	switch c.invalidation {
+	case cacheInvalidateStats:
+		c.watchStats(ctx, db)
+	case cacheInvalidateNotify:
+		c.listen(ctx, db)
+	}
+}
+
+// tableChangesSQL counts writes per table. n_live_tup catches TRUNCATE,
+// which does not add to the tuple counters.
+const tableChangesSQL = `
+SELECT schemaname || '.' || relname, n_tup_ins + n_tup_upd + n_tup_del, n_live_tup
+FROM pg_catalog.pg_stat_user_tables`
+
+// watchStats polls pg_stat_user_tables and invalidates results reading
+// tables whose counters moved. Backends report their counts shortly after
+// each transaction, so a result can outlive a write by a few seconds plus
+// the poll interval.
+func (c *resultCache) watchStats(ctx context.Context, db *pgxpool.Pool) {
+	t := time.NewTicker(cacheStatsInterval)
+	defer t.Stop()
+	last := map[string][2]int64{}
+	for {
+		counts, err := tableChanges(ctx, db)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			// Writes may go unnoticed until the next successful poll
+			log.Warn().Err(err).Msg("result cache: table statistics check failed")
+			c.flush(time.Now())
+		case err == nil:
+			now := time.Now()
+			for name, n := range counts {
+				if prev, ok := last[name]; ok && prev != n {
+					if dropped := c.invalidate(name, now); dropped > 0 {
+						log.Debug().Str("table", name).Int("dropped", dropped).Msg("result cache invalidated")
+					}
+				}
+			}
+			last = counts
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func tableChanges(ctx context.Context, db *pgxpool.Pool) (map[string][2]int64, error) {
+	ctxTO, cancel := context.WithTimeout(ctx, cacheStatsInterval)
+	defer cancel()
+	rows, err := db.Query(ctxTO, tableChangesSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string][2]int64{}
+	for rows.Next() {
+		var name string
+		var writes, live int64
+		if err := rows.Scan(&name, &writes, &live); err != nil {
+			return nil, err
+		}
+		out[name] = [2]int64{writes, live}
+	}
+	return out, rows.Err()
+}
+
+// listen invalidates results on NOTIFY. The payload is the schema-qualified
+// table that changed (an unqualified name means public); an empty payload
+// or "*" drops everything. The listener has its own connection outside the
+// pool and reconnects if it is lost, flushing the cache since
+// notifications may have been missed.
+func (c *resultCache) listen(ctx context.Context, db *pgxpool.Pool) {
+	for {
+		err := c.listenOnce(ctx, db)
+		if ctx.Err() != nil {
+			return
+		}
+		c.flush(time.Now())
+		log.Warn().Err(err).Str("channel", c.channel).Msg("result cache listener disconnected; retrying")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cacheListenRetry):
+		}
+	}
+}
+
+func (c *resultCache) listenOnce(ctx context.Context, db *pgxpool.Pool) error {
+	conn, err := pgx.ConnectConfig(ctx, db.Config().ConnConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{c.channel}.Sanitize()); err != nil {
+		return err
+	}
+	// Anything stored before LISTEN took effect could have missed a notification
+	c.flush(time.Now())
+	log.Info().Str("channel", c.channel).Msg("result cache listening for invalidations")
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		c.notified(n.Payload, time.Now())
+	}
+}
+
+func (c *resultCache) notified(payload string, now time.Time) {
+	table := strings.TrimSpace(payload)
+	switch {
+	case table == "" || table == "*":
+		c.flush(now)
+		log.Debug().Msg("result cache flushed by notification")
+		return
+	case !strings.Contains(table, "."):
+		table = "public." + table
+	}
+	if dropped := c.invalidate(table, now); dropped > 0 {
+		log.Debug().Str("table", table).Int("dropped", dropped).Msg("result cache invalidated")
+	}
+}
+
+// queryTables lists the tables sql reads, as schema.table, from its plan.
+// Views are expanded by the planner, so their base tables are listed.
+func queryTables(ctx context.Context, db *pgxpool.Pool, sql string, args []any) ([]string, error) {
+	var plan []byte
+	err := pgx.BeginTxFunc(ctx, db, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, "EXPLAIN (VERBOSE, FORMAT JSON) "+cursorSQL(sql), args...).Scan(&plan)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return planRelations(plan)
+}
+
+// planRelations collects the relations scanned anywhere in an EXPLAIN
+// (VERBOSE, FORMAT JSON) plan.
+func planRelations(plan []byte) ([]string, error) {
+	var doc any
+	if err := json.Unmarshal(plan, &doc); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if rel, ok := v["Relation Name"].(string); ok {
+				schema, _ := v["Schema"].(string)
+				seen[schema+"."+rel] = true
+			}
+			for _, c := range v {
+				walk(c)
+			}
+		case []any:
+			for _, c := range v {
+				walk(c)
+			}
+		}
+	}
+	walk(doc)
+	out := make([]string, 0, len(seen))
+	for t := range seen {
+		out = append(out, t)
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// cachedQuery returns run's result from the result cache when there is one
+// for this request, and otherwise runs it and caches the result. tables
+// lists what sql reads, as schema.table, when the caller knows; nil has it
+// looked up from the plan. cachedAt is when a cached result was produced,
+// nil for a fresh one.
+func cachedQuery[T any](s *Server, req *mcp.CallToolRequest, kind, sql string, args []any, tables []string, shape any, run func() (T, error)) (v T, cachedAt *time.Time, err error) {
+	c := s.resultCache
+	if c == nil {
+		v, err = run()
+		return v, nil, err
+	}
+	key := c.key(req, kind, sql, args, shape)
+	if hit, at, ok := c.get(key, time.Now()); ok {
+		return hit.(T), &at, nil
+	}
+	ran := time.Now()
+	if v, err = run(); err == nil {
+		s.cacheResult(key, sql, args, tables, v, ran)
+	}
+	return v, nil, err
+}
+
+// cachedNote marks cached results in audit messages.
+func cachedNote(cached bool) string {
+	if cached {
+		return " (cached)"
+	}
+	return ""
+}
+
+// cacheResult stores v as the result of sql, which read tables. When they
+// are not known it finds them from the plan in the background, so a miss
+// costs the caller no extra round trip. Those lookups run on a read pool,
+// at most cacheExplainMax at once and each under an admission slot; a
+// result that finds no room for one is not cached.
+func (s *Server) cacheResult(key, sql string, args []any, tables []string, v any, ran time.Time) {
+	c := s.resultCache
+	if tables != nil {
+		c.put(key, v, tables, ran)
+		return
+	}
+	select {
+	case c.explains <- struct{}{}:
+	default:
+		c.skipped.Add(1)
+		return
+	}
+	free, ok := s.tryHold()
+	if !ok {
+		<-c.explains
+		c.skipped.Add(1)
+		return
+	}
+	go func() {
+		defer func() { <-c.explains }()
+		defer free()
+		ctx, cancel := context.WithTimeout(s.bg, s.cfg.QueryTO)
+		defer cancel()
+		db, _ := s.readPool()
+		tables, err := queryTables(ctx, db, sql, args)
+		if err != nil {
+			log.Debug().Err(err).Msg("result not cached: could not determine its tables")
+			return
+		}
+		s.resultCache.put(key, v, tables, ran)
+	}()
+}
+
+// normalizeSQL canonicalizes sql for cache keys. Comments are dropped, runs
+// of whitespace become one space, unquoted text is lower-cased (PostgreSQL
+// folds unquoted identifiers and keywords anyway) and trailing semicolons
+// are removed. Quoted strings, identifiers and dollar-quoted bodies are
+// kept byte for byte.
+func normalizeSQL(sql string) string {
+	var b strings.Builder
+	b.Grow(len(sql))
+	space := false
+	emit := func(s string) {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(s)
+	}
+	for i := 0; i < len(sql); {
+		ch := sql[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f':
+			space = true
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			space = true
+			i += end
+		case strings.HasPrefix(sql[i:], "/*"):
+			// Block comments nest in PostgreSQL
+			depth, j := 0, i
+			for j < len(sql) {
+				if strings.HasPrefix(sql[j:], "/*") {
+					depth++
+					j += 2
+				} else if strings.HasPrefix(sql[j:], "*/") {
+					depth--
+					j += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					j++
+				}
+			}
+			space = true
+			i = j
+		case ch == '\'' || ch == '"':
+			escapes := ch == '\'' && i > 0 && (sql[i-1] == 'e' || sql[i-1] == 'E') && (i < 2 || !isIdentByte(sql[i-2]))
+			j := quotedEnd(sql, i, ch, escapes)
+			emit(sql[i:j])
+			i = j
+		case ch == '$' && (i == 0 || !isIdentByte(sql[i-1])):
+			if tag := dollarTag(sql[i:]); tag != "" {
+				j := len(sql)
+				if k := strings.Index(sql[i+len(tag):], tag); k >= 0 {
+					j = i + len(tag) + k + len(tag)
+				}
+				emit(sql[i:j])
+				i = j
+				continue
+			}
+			emit("$")
+			i++
+		case 'A' <= ch && ch <= 'Z':
+			emit(string(ch + 'a' - 'A'))
+			i++
+		default:
+			emit(sql[i : i+1])
+			i++
+		}
+	}
+	return strings.TrimRight(b.String(), "; ")
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// quotedEnd returns the index just past the quoted token starting at
+// sql[i], or len(sql) if it is unterminated. A doubled quote is an escaped
+// quote; with escapes (E'...' strings) so is a backslash-escaped one.
+func quotedEnd(sql string, i int, q byte, escapes bool) int {
+	for j := i + 1; j < len(sql); j++ {
+		switch {
+		case escapes && sql[j] == '\\':
+			j++
+		case sql[j] == q:
+			if j+1 < len(sql) && sql[j+1] == q {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(sql)
+}
+
+// dollarTag returns the opening $tag$ of a dollar-quoted string at the start
+// of s, or "" if there is none. Tags cannot start with a digit, which keeps
+// $1 parameters out.
+func dollarTag(s string) string {
+	for j := 1; j < len(s); j++ {
+		c := s[j]
+		switch {
+		case c == '$':
+			return s[:j+1]
+		case c >= '0' && c <= '9':
+			if j == 1 {
+				return ""
+			}
+		case c == '_' || c >= 0x80 || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z'):
+		default:
+			return ""
+		}
+	}
+	return ""
+}
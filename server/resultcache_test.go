@@ -0,0 +1,167 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	same := [][2]string{
+		{"SELECT  id\n\tFROM users;", "select id from users"},
+		{"select id -- the key\nfrom Users ;;", "select id from users"},
+		{"SELECT /* outer /* nested */ still */ 1", "select 1"},
+		{`SELECT 'It''s  A' FROM "Mixed  Case"`, `select 'It''s  A' from "Mixed  Case"`},
+		{`SELECT E'a\'  B' AS x`, `select e'a\'  B' as x`},
+		{"SELECT $tag$ Keep  THIS $tag$, $1", "select $tag$ Keep  THIS $tag$, $1"},
+		{"SELECT a$b FROM t", "select a$b from t"},
+	}
+	for _, tt := range same {
+		if got := normalizeSQL(tt[0]); got != tt[1] {
+			t.Errorf("normalizeSQL(%q) = %q, want %q", tt[0], got, tt[1])
+		}
+	}
+	// Different literals must never share a key
+	if normalizeSQL(`SELECT E'\'' || 'A'`) == normalizeSQL(`SELECT E'\'' || 'a'`) {
+		t.Errorf("literals after an escaped quote were folded")
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	c, err := newResultCache(Config{
+		ResultCacheTTL:       time.Minute,
+		ResultCacheSize:      2,
+		ResultCacheTableTTLs: []string{"public.audit_*=0", "countries=1h", "public.orders=5s"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Second)
+
+	if !c.put("a", 1, []string{"public.orders", "public.countries"}, past) {
+		t.Fatalf("put a failed")
+	}
+	if v, at, ok := c.get("a", time.Now()); !ok || v != 1 || !at.Equal(past) {
+		t.Fatalf("get a = %v %v %v", v, at, ok)
+	}
+	// The shortest table TTL applies
+	if _, _, ok := c.get("a", time.Now().Add(6*time.Second)); ok {
+		t.Fatalf("a should expire with public.orders' 5s TTL")
+	}
+	if c.put("b", 2, []string{"public.audit_log"}, past) {
+		t.Fatalf("results reading a zero-TTL table should not be cached")
+	}
+
+	// Least recently used goes first
+	c.put("x", 1, nil, past)
+	c.put("y", 2, nil, past)
+	c.get("x", time.Now())
+	c.put("z", 3, []string{"public.users"}, past)
+	if _, _, ok := c.get("y", time.Now()); ok {
+		t.Fatalf("y should have been evicted")
+	}
+	if st := c.stats(); st.Entries != 2 || st.Evictions != 1 {
+		t.Fatalf("stats = %+v", st)
+	}
+
+	// Invalidation drops readers of the table and keeps older runs out
+	if n := c.invalidate("public.users", time.Now()); n != 1 {
+		t.Fatalf("invalidate dropped %d", n)
+	}
+	if c.put("z", 3, []string{"public.users"}, past) {
+		t.Fatalf("a result that started before the change should not be stored")
+	}
+	if !c.put("z", 3, []string{"public.users"}, time.Now().Add(time.Millisecond)) {
+		t.Fatalf("a result that started after the change should be stored")
+	}
+
+	c.notified("*", time.Now())
+	if st := c.stats(); st.Entries != 0 {
+		t.Fatalf("notify * should flush, have %d entries", st.Entries)
+	}
+}
+
+func TestResultCacheNotified(t *testing.T) {
+	c, _ := newResultCache(Config{ResultCacheTTL: time.Minute})
+	past := time.Now().Add(-time.Second)
+	c.put("a", 1, []string{"public.items"}, past)
+	c.put("b", 2, []string{"sales.items"}, past)
+	c.notified(" items ", time.Now())
+	if _, _, ok := c.get("a", time.Now()); ok {
+		t.Fatalf("unqualified payload should invalidate public.items")
+	}
+	if _, _, ok := c.get("b", time.Now()); !ok {
+		t.Fatalf("sales.items should be untouched")
+	}
+}
+
+func TestCacheResultLookups(t *testing.T) {
+	c, _ := newResultCache(Config{ResultCacheTTL: time.Minute})
+	s := &Server{resultCache: c}
+	past := time.Now().Add(-time.Second)
+
+	// Known tables are stored straight away
+	s.cacheResult("a", "SELECT 1", nil, []string{"public.items"}, 1, past)
+	if _, _, ok := c.get("a", time.Now()); !ok {
+		t.Fatal("result with known tables not cached")
+	}
+
+	// With every lookup slot taken, others are skipped instead of queued
+	for range cacheExplainMax {
+		c.explains <- struct{}{}
+	}
+	s.cacheResult("b", "SELECT 2", nil, nil, 2, past)
+	if _, _, ok := c.get("b", time.Now()); ok || c.stats().Skipped != 1 {
+		t.Fatalf("lookup not skipped: %+v", c.stats())
+	}
+	if len(c.explains) != cacheExplainMax {
+		t.Fatal("skipped lookup changed the lookup slots")
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	client, _ := newResultCache(Config{ResultCacheTTL: time.Minute})
+	if client.key(nil, "search", "SELECT 1", nil, 10) == client.key(nil, "search", "SELECT 1", nil, 20) {
+		t.Fatalf("shape should be part of the key")
+	}
+	if client.key(nil, "search", "SELECT 1", []any{"a"}, 10) == client.key(nil, "search", "SELECT 1", []any{"b"}, 10) {
+		t.Fatalf("args should be part of the key")
+	}
+	if client.key(nil, "page", "SELECT  1;", nil, 10) != client.key(nil, "page", "select 1", nil, 10) {
+		t.Fatalf("equivalent SQL should share a key")
+	}
+	shared, _ := newResultCache(Config{ResultCacheTTL: time.Minute, ResultCacheScope: cacheScopeShared})
+	if shared.key(nil, "page", "SELECT 1", nil, 10) == client.key(nil, "page", "SELECT 1", nil, 10) {
+		t.Fatalf("shared keys should not carry the client identity")
+	}
+}
+
+func TestParseTableTTLs(t *testing.T) {
+	got, err := parseTableTTLs([]string{"public.orders=5s", " /^audit_/ = 0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ttl != 5*time.Second || got[1].ttl != 0 || !got[1].pattern.matchTable("x", "audit_log") {
+		t.Fatalf("got %+v", got)
+	}
+	for _, bad := range []string{"orders", "orders=soon", "orders=-1s", "/(/=1s"} {
+		if _, err := parseTableTTLs([]string{bad}); err == nil {
+			t.Errorf("%q should not parse", bad)
+		}
+	}
+}
+
+func TestPlanRelations(t *testing.T) {
+	plan := `[{"Plan": {"Node Type": "Hash Join", "Plans": [
+		{"Node Type": "Seq Scan", "Relation Name": "orders", "Schema": "public", "Alias": "o"},
+		{"Node Type": "Hash", "Plans": [{"Node Type": "Index Scan", "Relation Name": "users", "Schema": "public"}]},
+		{"Node Type": "Seq Scan", "Relation Name": "orders", "Schema": "public", "Alias": "o2"}
+	]}}]`
+	got, err := planRelations([]byte(plan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"public.orders", "public.users"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,400 @@
+// server/results.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultResultTTL          = 5 * time.Minute
+	defaultResultMaxPerClient = 2
+	defaultResultMax          = 4
+	resultCursor              = "pgmcp_result"
+	resultReapInterval        = 15 * time.Second
+)
+
+var errHandleNotFound = errors.New("result handle not found or expired")
+
+// requestIdentity names the caller for per-client limits: the MCP session
+// when there is one, otherwise a shared anonymous identity.
+func requestIdentity(req *mcp.CallToolRequest) string {
+	if req != nil && req.Session != nil {
+		if id := req.Session.ID(); id != "" {
+			return "session:" + id
+		}
+	}
+	return "anonymous"
+}
+
+// resultHandle is a materialized result: a pooled connection held in a
+// REPEATABLE READ read-only transaction with an open cursor, so every page
+// comes from the same snapshot however long the client takes to read it.
+type resultHandle struct {
+	id      string
+	owner   string
+	sql     string
+	replica string // "" when no replicas are configured
+	created time.Time
+
+	mu        sync.Mutex // serializes fetches and close on the connection
+	conn      *pgxpool.Conn
+	tx        pgx.Tx
+	columns   []columnInfo     // described on the first fetch
+	pending   []map[string]any // look-ahead row(s) read to detect the end
+	fetched   int
+	exhausted bool
+	closed    bool
+	expires   time.Time
+	free      func() // gives up the admission slot the handle holds, if any
+}
+
+// resultStore tracks open handles and enforces the TTL and limits.
+type resultStore struct {
+	mu         sync.Mutex
+	handles    map[string]*resultHandle
+	ttl        time.Duration
+	maxPerUser int
+	max        int
+}
+
+func newResultStore(ttl time.Duration, maxPerUser, max int) *resultStore {
+	if ttl == 0 {
+		ttl = defaultResultTTL
+	}
+	if maxPerUser == 0 {
+		maxPerUser = defaultResultMaxPerClient
+	}
+	if max == 0 {
+		max = defaultResultMax
+	}
+	return &resultStore{handles: map[string]*resultHandle{}, ttl: ttl, maxPerUser: maxPerUser, max: max}
+}
+
+// reserve checks the limits for owner and registers h, so concurrent opens
+// cannot overshoot them while the transaction is being set up.
+func (rs *resultStore) reserve(h *resultHandle) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if len(rs.handles) >= rs.max {
+		return fmt.Errorf("too many open result handles on the server (max %d); try again later", rs.max)
+	}
+	n := 0
+	for _, o := range rs.handles {
+		if o.owner == h.owner {
+			n++
+		}
+	}
+	if n >= rs.maxPerUser {
+		return fmt.Errorf("too many open result handles (max %d per client); release one with close_results", rs.maxPerUser)
+	}
+	rs.handles[h.id] = h
+	return nil
+}
+
+func (rs *resultStore) get(id, owner string) (*resultHandle, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	h, ok := rs.handles[id]
+	if !ok || h.owner != owner {
+		return nil, errHandleNotFound
+	}
+	return h, nil
+}
+
+func (rs *resultStore) remove(id string) {
+	rs.mu.Lock()
+	delete(rs.handles, id)
+	rs.mu.Unlock()
+}
+
+// open materializes sql for owner. The returned handle is positioned at the
+// first row.
+func (rs *resultStore) open(ctx context.Context, db *pgxpool.Pool, replica, owner, sql string, timeout time.Duration) (*resultHandle, error) {
+	idb := make([]byte, 16)
+	if _, err := rand.Read(idb); err != nil {
+		return nil, err
+	}
+	h := &resultHandle{id: hex.EncodeToString(idb), owner: owner, sql: sql, replica: replica, created: time.Now()}
+	h.expires = h.created.Add(rs.ttl)
+	if err := rs.reserve(h); err != nil {
+		return nil, err
+	}
+
+	ctxTO, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := func() error {
+		conn, err := db.Acquire(ctxTO)
+		if err != nil {
+			return err
+		}
+		tx, err := conn.BeginTx(ctxTO, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+		if err != nil {
+			conn.Release()
+			return err
+		}
+		h.conn, h.tx = conn, tx
+		return execStatement(ctxTO, tx, fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR %s", resultCursor, cursorSQL(sql)))
+	}()
+	if err != nil {
+		rs.remove(h.id)
+		h.release(context.Background())
+		return nil, err
+	}
+	return h, nil
+}
+
+// handlePage is what one fetch read from a handle, with the handle's state
+// as of that fetch, so callers need not read it without h.mu.
+type handlePage struct {
+	rows    []map[string]any
+	columns []columnInfo
+	fetched int  // rows read from the handle so far, these included
+	more    bool // whether more rows remain
+}
+
+// fetch returns up to n rows and whether more remain, renewing the TTL.
+func (h *resultHandle) fetch(ctx context.Context, n int, ttl, timeout time.Duration) (handlePage, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return handlePage{}, errHandleNotFound
+	}
+	ctxTO, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	out := h.pending
+	h.pending = nil
+	if !h.exhausted && len(out) <= n {
+		want := n - len(out) + 1 // one extra row tells us whether the result continues
+		rows, flds, err := fetchRows(ctxTO, h.tx, fmt.Sprintf("FETCH FORWARD %d FROM %s", want, resultCursor), want)
+		if err != nil {
+			return handlePage{}, err
+		}
+		if h.columns == nil {
+			if h.columns, err = describeColumns(ctxTO, h.tx, flds); err != nil {
+				return handlePage{}, err
+			}
+		}
+		if len(rows) < want {
+			h.exhausted = true
+		}
+		out = append(out, rows...)
+	}
+	if len(out) > n {
+		h.pending = out[n:]
+		out = out[:n]
+	}
+	h.fetched += len(out)
+	h.expires = time.Now().Add(ttl)
+	return handlePage{rows: out, columns: h.columns, fetched: h.fetched, more: len(h.pending) > 0}, nil
+}
+
+// release ends the transaction and returns the connection to the pool.
+func (h *resultHandle) release(ctx context.Context) {
+	if h.closed {
+		return
+	}
+	h.closed = true
+	if h.tx != nil {
+		ctxTO, cancel := context.WithTimeout(ctx, 5*time.Second)
+		_ = h.tx.Rollback(ctxTO)
+		cancel()
+	}
+	if h.conn != nil {
+		h.conn.Release()
+	}
+	if h.free != nil {
+		h.free()
+	}
+	h.pending = nil
+}
+
+// close releases the handle and forgets it.
+func (rs *resultStore) close(id string) {
+	rs.mu.Lock()
+	h, ok := rs.handles[id]
+	delete(rs.handles, id)
+	rs.mu.Unlock()
+	if ok {
+		h.mu.Lock()
+		h.release(context.Background())
+		h.mu.Unlock()
+	}
+}
+
+// reap closes handles past their TTL. Handles busy in a fetch are skipped
+// and picked up on the next pass.
+func (rs *resultStore) reap(now time.Time) int {
+	rs.mu.Lock()
+	var expired []*resultHandle
+	for id, h := range rs.handles {
+		if !h.mu.TryLock() {
+			continue
+		}
+		if now.After(h.expires) {
+			expired = append(expired, h)
+			delete(rs.handles, id)
+		} else {
+			h.mu.Unlock()
+		}
+	}
+	rs.mu.Unlock()
+	for _, h := range expired {
+		h.release(context.Background())
+		h.mu.Unlock()
+		log.Debug().Str("handle", h.id).Str("owner", h.owner).Int("fetched", h.fetched).Msg("result handle expired")
+	}
+	return len(expired)
+}
+
+// Run reaps expired handles until ctx is done, then closes the rest.
+func (rs *resultStore) Run(ctx context.Context) {
+	t := time.NewTicker(resultReapInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			rs.closeAll()
+			return
+		case now := <-t.C:
+			rs.reap(now)
+		}
+	}
+}
+
+func (rs *resultStore) closeAll() {
+	rs.mu.Lock()
+	ids := make([]string, 0, len(rs.handles))
+	for id := range rs.handles {
+		ids = append(ids, id)
+	}
+	rs.mu.Unlock()
+	for _, id := range ids {
+		rs.close(id)
+	}
+}
+
+// materialized is the first page of a materialized result.
+type materialized struct {
+	Columns   []columnInfo
+	Rows      []map[string]any
+	Handle    string // "" when the first page already held everything
+	ExpiresAt *time.Time
+	Replica   string
+}
+
+// materialize opens a handle for sql and reads its first page. The handle
+// is closed straight away when the first page already holds everything.
+func (s *Server) materialize(ctx context.Context, req *mcp.CallToolRequest, sql string, n int, keep func() func()) (materialized, error) {
+	db, replica := s.readPool()
+	h, err := s.results.open(ctx, db, replica, requestIdentity(req), sql, s.cfg.QueryTO)
+	if err != nil {
+		return materialized{}, err
+	}
+	page, err := h.fetch(ctx, n, s.results.ttl, s.cfg.QueryTO)
+	if err != nil || !page.more {
+		s.results.close(h.id)
+		return materialized{Columns: page.columns, Rows: page.rows, Replica: replica}, err
+	}
+	h.mu.Lock()
+	if h.closed {
+		// Expired already; the request's slot stays with the request
+		h.mu.Unlock()
+		return materialized{}, errHandleNotFound
+	}
+	h.free = keep()
+	h.mu.Unlock()
+	return materialized{Columns: page.columns, Rows: page.rows, Handle: h.id, ExpiresAt: h.expiry(), Replica: replica}, nil
+}
+
+func (h *resultHandle) expiry() *time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	exp := h.expires
+	return &exp
+}
+
+// ---------- fetch_results / close_results tools ----------
+
+type fetchResultsInput struct {
+	Handle  string `json:"result_handle"`
+	MaxRows int    `json:"max_rows,omitempty"` // rows to return (default 50)
+}
+
+type fetchResultsOutput struct {
+	Columns   []columnInfo     `json:"columns,omitempty"`
+	Rows      []map[string]any `json:"rows"`
+	Fetched   int              `json:"fetched"` // rows read from the handle so far
+	HasMore   bool             `json:"has_more"`
+	Handle    string           `json:"result_handle,omitempty"` // unset once the result is drained
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"`
+	Replica   string           `json:"replica,omitempty"` // where the snapshot is held, when replicas are configured
+}
+
+func (s *Server) handleFetchResults(ctx context.Context, req *mcp.CallToolRequest, in fetchResultsInput) (*mcp.CallToolResult, fetchResultsOutput, error) {
+	start := time.Now()
+	ctx, stop := s.requests.withRequestCancel(ctx, req)
+	defer stop()
+	owner := requestIdentity(req)
+	log.Debug().Str("tool", "fetch_results").Str("handle", in.Handle).Int("max_rows", in.MaxRows).Str("identity", owner).Msg("request")
+
+	h, err := s.results.get(in.Handle, owner)
+	if err != nil {
+		auditLog("fetch_results_failed", owner, in.Handle, err.Error(), false)
+		return nil, fetchResultsOutput{}, err
+	}
+	n := pageSize
+	if in.MaxRows > 0 {
+		n = min(in.MaxRows, s.cfg.MaxRows)
+	}
+	page, err := h.fetch(ctx, n, s.results.ttl, s.cfg.QueryTO)
+	if err != nil {
+		// A failed FETCH aborts the transaction, so the handle is unusable
+		s.results.close(h.id)
+		auditFailure(ctx, "fetch_results", "fetch_results_failed", owner, h.sql, err)
+		log.Debug().Str("tool", "fetch_results").Err(err).Dur("dur", time.Since(start)).Msg("fetch failed")
+		return nil, fetchResultsOutput{}, err
+	}
+	out := fetchResultsOutput{Columns: page.columns, Rows: page.rows, Fetched: page.fetched, HasMore: page.more, Replica: h.replica}
+	if page.more {
+		out.Handle, out.ExpiresAt = h.id, h.expiry()
+	} else {
+		s.results.close(h.id)
+	}
+	auditLogVia(h.replica, "fetch_results_success", owner, h.id, fmt.Sprintf("returned %d rows (%d total)", len(page.rows), page.fetched), true)
+	log.Debug().Str("tool", "fetch_results").Int("row_count", len(page.rows)).Bool("has_more", page.more).Dur("dur", time.Since(start)).Msg("done")
+	return nil, out, nil
+}
+
+type closeResultsInput struct {
+	Handle string `json:"result_handle"`
+}
+
+type closeResultsOutput struct {
+	Closed bool `json:"closed"`
+}
+
+func (s *Server) handleCloseResults(ctx context.Context, req *mcp.CallToolRequest, in closeResultsInput) (*mcp.CallToolResult, closeResultsOutput, error) {
+	owner := requestIdentity(req)
+	log.Debug().Str("tool", "close_results").Str("handle", in.Handle).Str("identity", owner).Msg("request")
+
+	h, err := s.results.get(in.Handle, owner)
+	if err != nil {
+		// Already gone (closed, drained or expired) - nothing to release
+		return nil, closeResultsOutput{Closed: false}, nil
+	}
+	s.results.close(h.id)
+	auditLog("close_results", owner, h.id, fmt.Sprintf("released after %d rows", h.fetched), true)
+	return nil, closeResultsOutput{Closed: true}, nil
+}
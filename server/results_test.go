@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestIdentity(t *testing.T) {
+	if got := requestIdentity(nil); got != "anonymous" {
+		t.Fatalf("nil request identity = %q", got)
+	}
+}
+
+func TestResultStoreLimits(t *testing.T) {
+	rs := newResultStore(time.Minute, 2, 3)
+
+	add := func(id, owner string) error {
+		return rs.reserve(&resultHandle{id: id, owner: owner, expires: time.Now().Add(time.Minute)})
+	}
+	if err := add("a1", "alice"); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if err := add("a2", "alice"); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if err := add("a3", "alice"); err == nil || !strings.Contains(err.Error(), "per client") {
+		t.Fatalf("expected per-client limit, got %v", err)
+	}
+	if err := add("b1", "bob"); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if err := add("c1", "carol"); err == nil || !strings.Contains(err.Error(), "on the server") {
+		t.Fatalf("expected server-wide limit, got %v", err)
+	}
+
+	// Handles are private to their owner
+	if _, err := rs.get("a1", "bob"); err != errHandleNotFound {
+		t.Fatalf("bob should not see alice's handle, got %v", err)
+	}
+	if h, err := rs.get("a1", "alice"); err != nil || h.id != "a1" {
+		t.Fatalf("get: %v", err)
+	}
+
+	rs.close("a1")
+	if _, err := rs.get("a1", "alice"); err != errHandleNotFound {
+		t.Fatalf("closed handle still visible")
+	}
+	if err := add("a3", "alice"); err != nil {
+		t.Fatalf("closing should free a slot: %v", err)
+	}
+}
+
+func TestResultStoreReap(t *testing.T) {
+	rs := newResultStore(0, 0, 0)
+	if rs.ttl != defaultResultTTL || rs.max != defaultResultMax || rs.maxPerUser != defaultResultMaxPerClient {
+		t.Fatalf("zero config should use defaults: %+v", rs)
+	}
+	now := time.Now()
+	old := &resultHandle{id: "old", owner: "x", expires: now.Add(-time.Second)}
+	fresh := &resultHandle{id: "fresh", owner: "y", expires: now.Add(time.Minute)}
+	_ = rs.reserve(old)
+	_ = rs.reserve(fresh)
+
+	// A handle in the middle of a fetch is left for the next pass
+	old.mu.Lock()
+	if n := rs.reap(now); n != 0 {
+		t.Fatalf("busy handle reaped")
+	}
+	old.mu.Unlock()
+
+	if n := rs.reap(now); n != 1 {
+		t.Fatalf("expected 1 handle reaped, got %d", n)
+	}
+	if !old.closed || fresh.closed {
+		t.Fatalf("wrong handle reaped: old=%v fresh=%v", old.closed, fresh.closed)
+	}
+	if _, err := old.fetch(context.Background(), 10, time.Minute, time.Second); err != errHandleNotFound {
+		t.Fatalf("fetch on reaped handle: %v", err)
+	}
+}
+
+func TestResultHandleFetchPage(t *testing.T) {
+	rows := []map[string]any{{"id": 1}, {"id": 2}, {"id": 3}}
+	cols := []columnInfo{{Name: "id", Type: "int4"}}
+	// Exhausted with rows pending, so fetch never touches the database
+	h := &resultHandle{id: "h", pending: rows, columns: cols, exhausted: true}
+
+	page, err := h.fetch(context.Background(), 2, time.Minute, time.Second)
+	if err != nil || len(page.rows) != 2 || page.fetched != 2 || !page.more || len(page.columns) != 1 {
+		t.Fatalf("first page: %+v %v", page, err)
+	}
+	page, err = h.fetch(context.Background(), 2, time.Minute, time.Second)
+	if err != nil || len(page.rows) != 1 || page.fetched != 3 || page.more {
+		t.Fatalf("last page: %+v %v", page, err)
+	}
+}
@@ -0,0 +1,160 @@
+// server/rows.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/rs/zerolog/log"
+)
+
+var errRowNotFound = errors.New("row not found")
+
+// rowKeyColumns lists the columns that identify a row of t: its primary
+// key, or ctid when it has none. A ctid is only unique within one
+// partition, so rows of a partitioned table add the partition's tableoid.
+func rowKeyColumns(t *Table) []string {
+	switch {
+	case t.PrimaryKey != nil:
+		return t.PrimaryKey.Columns
+	case t.Kind == tableKindPartitioned:
+		return []string{"tableoid", "ctid"}
+	}
+	return []string{"ctid"}
+}
+
+// rowSQL returns a query for the row of t with the given key, which must
+// name exactly the columns rowKeyColumns lists. Key values are bound as text
+// and cast to the column's type, so the primary key index can be used.
+func rowSQL(t *Table, key map[string]string) (string, []any, error) {
+	if t.Kind == tableKindView {
+		return "", nil, fmt.Errorf("rows of view %s.%s have no key", t.Schema, t.Name)
+	}
+	cols := rowKeyColumns(t)
+	if len(key) != len(cols) {
+		return "", nil, fmt.Errorf("key of %s.%s must give %s", t.Schema, t.Name, strings.Join(cols, ", "))
+	}
+	var args []any
+	var conds []string
+	for _, name := range cols {
+		v, ok := key[name]
+		if !ok {
+			return "", nil, fmt.Errorf("key of %s.%s must give %s", t.Schema, t.Name, strings.Join(cols, ", "))
+		}
+		args = append(args, v)
+		if t.PrimaryKey == nil {
+			if name == "tableoid" {
+				conds = append(conds, fmt.Sprintf("tableoid = CAST($%d::text AS oid)", len(args)))
+			} else {
+				conds = append(conds, fmt.Sprintf("ctid = $%d::tid", len(args)))
+			}
+			continue
+		}
+		c := t.Column(name)
+		if c == nil {
+			return "", nil, fmt.Errorf("primary key column %q of %s.%s not found", name, t.Schema, t.Name)
+		}
+		conds = append(conds, fmt.Sprintf("%s = CAST($%d::text AS %s)", pgx.Identifier{name}.Sanitize(), len(args), c.Type))
+	}
+	var sel []string
+	for _, c := range t.Columns {
+		sel = append(sel, pgx.Identifier{c.Name}.Sanitize())
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s WHERE %s LIMIT 1",
+		strings.Join(sel, ", "), pgx.Identifier{t.Schema, t.Name}.Sanitize(), strings.Join(conds, " AND "))
+	return sql, args, nil
+}
+
+// modelRowSQL is rowSQL for schema.table as m knows it. Search hits on
+// materialized views carry row URIs too, so views are looked up as well.
+func modelRowSQL(m *SchemaModel, schema, table string, key map[string]string) (string, []any, error) {
+	t := m.Relation(schema, table)
+	if t == nil {
+		return "", nil, fmt.Errorf("table %s.%s not found", schema, table)
+	}
+	return rowSQL(t, key)
+}
+
+// fetchRow reads one row of schema.table by its key. It returns
+// errRowNotFound when no row has that key, e.g. because it was deleted, or
+// because it was updated and the key is a ctid.
+func (s *Server) fetchRow(ctx context.Context, schema, table string, key map[string]string) (*queryResult, error) {
+	model, err := s.cache.Model(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	sql, args, err := modelRowSQL(model, schema, table, key)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.runReadOnlyQuery(ctx, sql, 1, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Rows) == 0 {
+		return nil, errRowNotFound
+	}
+	return res, nil
+}
+
+// ---------- get_row tool ----------
+
+type getRowInput struct {
+	URI    string            `json:"uri,omitempty"`    // a search hit's row_uri
+	Schema string            `json:"schema,omitempty"` // or schema, table and key
+	Table  string            `json:"table,omitempty"`
+	Key    map[string]string `json:"key,omitempty"` // primary key column -> value, or {"ctid": ...}
+}
+
+type getRowOutput struct {
+	URI     string         `json:"uri"`
+	Columns []columnInfo   `json:"columns"`
+	Row     map[string]any `json:"row"`
+	Replica string         `json:"replica,omitempty"`
+}
+
+func (s *Server) handleGetRow(ctx context.Context, req *mcp.CallToolRequest, in getRowInput) (*mcp.CallToolResult, getRowOutput, error) {
+	start := time.Now()
+	ctx, stop := s.requests.withRequestCancel(ctx, req)
+	defer stop()
+	user := requestIdentity(req)
+
+	log.Debug().Str("tool", "get_row").Str("uri", in.URI).Str("schema", in.Schema).Str("table", in.Table).Msg("request")
+
+	schema, table, key := in.Schema, in.Table, in.Key
+	if in.URI != "" {
+		var ok bool
+		if schema, table, key, ok = parseRowResourceURI(in.URI); !ok {
+			return nil, getRowOutput{}, fmt.Errorf("%q is not a row URI", in.URI)
+		}
+	} else if table == "" || len(key) == 0 {
+		return nil, getRowOutput{}, errors.New("give uri, or table and key")
+	}
+	if schema == "" {
+		schema = "public"
+	}
+	uri := rowResourceURI(schema, table, key)
+
+	release, err := s.admit(ctx, req)
+	if err != nil {
+		auditFailure(ctx, "get_row", "get_row_rejected", user, uri, err)
+		log.Debug().Str("tool", "get_row").Err(err).Msg("not admitted")
+		return nil, getRowOutput{}, err
+	}
+	defer release()
+
+	res, err := s.fetchRow(ctx, schema, table, key)
+	if err != nil {
+		auditFailure(ctx, "get_row", "get_row_failed", user, uri, err)
+		log.Debug().Str("tool", "get_row").Err(err).Dur("dur", time.Since(start)).Msg("fetch failed")
+		return nil, getRowOutput{}, err
+	}
+	auditLogVia(res.Replica, "get_row_success", user, uri, "returned 1 row", true)
+	log.Debug().Str("tool", "get_row").Str("replica", res.Replica).Dur("dur", time.Since(start)).Msg("done")
+	return nil, getRowOutput{URI: uri, Columns: res.Columns, Row: res.Rows[0], Replica: res.Replica}, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRowSQL(t *testing.T) {
+	m := testSchemaModel()
+	items := m.Table("public", "order_items")
+	sql, args, err := rowSQL(items, map[string]string{"item_id": "2", "order_id": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `SELECT "order_id", "item_id", "quantity", "status" FROM "public"."order_items" WHERE "order_id" = CAST($1::text AS integer) AND "item_id" = CAST($2::text AS integer) LIMIT 1`; sql != want {
+		t.Fatalf("sql = %s", sql)
+	}
+	if !reflect.DeepEqual(args, []any{"1", "2"}) {
+		t.Fatalf("args = %q", args)
+	}
+
+	for _, key := range []map[string]string{
+		{"order_id": "1"},
+		{"order_id": "1", "quantity": "2"},
+		{"ctid": "(0,1)"},
+	} {
+		if _, _, err := rowSQL(items, key); err == nil || !strings.Contains(err.Error(), "must give order_id, item_id") {
+			t.Fatalf("key %v: got %v", key, err)
+		}
+	}
+
+	logs := &Table{Schema: "public", Name: "logs", Columns: []Column{{Name: "msg", Type: "text", BaseType: "text", Position: 1}}}
+	sql, args, err = rowSQL(logs, map[string]string{"ctid": "(0,7)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(sql, `FROM "public"."logs" WHERE ctid = $1::tid LIMIT 1`) || !reflect.DeepEqual(args, []any{"(0,7)"}) {
+		t.Fatalf("sql = %s, args = %q", sql, args)
+	}
+}
+
+func TestRowSQLRelations(t *testing.T) {
+	m := testSchemaModel()
+	totals := &Table{Schema: "public", Name: "totals", Kind: tableKindMatview, Columns: []Column{{Name: "label", Type: "text", BaseType: "text", Position: 1}}}
+	recent := &Table{Schema: "public", Name: "recent", Kind: tableKindView, Columns: []Column{{Name: "note", Type: "text", BaseType: "text", Position: 1}}}
+	events := &Table{Schema: "public", Name: "events", Kind: tableKindPartitioned, Columns: []Column{{Name: "msg", Type: "text", BaseType: "text", Position: 1}}}
+	m.Views = []*Table{totals, recent}
+	m.Tables = append(m.Tables, events)
+
+	// A materialized view hit's row_uri leads back to its row
+	var args []any
+	if key := rowKeySQL(totals, &args); key != `jsonb_build_object('ctid', CAST(ctid AS text))` {
+		t.Fatalf("matview row key = %s", key)
+	}
+	schema, table, key, ok := parseRowResourceURI(rowResourceURI("public", "totals", map[string]string{"ctid": "(0,3)"}))
+	if !ok {
+		t.Fatal("matview row_uri does not parse")
+	}
+	sql, args, err := modelRowSQL(m, schema, table, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(sql, `FROM "public"."totals" WHERE ctid = $1::tid LIMIT 1`) || !reflect.DeepEqual(args, []any{"(0,3)"}) {
+		t.Fatalf("sql = %s, args = %q", sql, args)
+	}
+
+	if _, _, err := modelRowSQL(m, "public", "recent", map[string]string{"ctid": "(0,1)"}); err == nil || !strings.Contains(err.Error(), "no key") {
+		t.Fatalf("view row: %v", err)
+	}
+	if _, _, err := modelRowSQL(m, "public", "nope", map[string]string{"ctid": "(0,1)"}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("missing table: %v", err)
+	}
+
+	// A ctid is only unique within a partition
+	if key := rowKeySQL(events, &args); !strings.Contains(key, `'tableoid', CAST(tableoid AS text), 'ctid'`) {
+		t.Fatalf("partitioned row key = %s", key)
+	}
+	sql, args, err = modelRowSQL(m, "public", "events", map[string]string{"tableoid": "16401", "ctid": "(0,1)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(sql, `WHERE tableoid = CAST($1::text AS oid) AND ctid = $2::tid LIMIT 1`) || !reflect.DeepEqual(args, []any{"16401", "(0,1)"}) {
+		t.Fatalf("sql = %s, args = %q", sql, args)
+	}
+	if _, _, err := modelRowSQL(m, "public", "events", map[string]string{"ctid": "(0,1)"}); err == nil {
+		t.Fatal("partitioned row without tableoid accepted")
+	}
+}
@@ -0,0 +1,762 @@
+// server/schema.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// SchemaModel is a typed snapshot of the user-visible catalog. It is loaded
+// once per cache refresh and shared by the prompt, search and introspection.
+// Views only feed search, so the prompt and introspection see tables alone.
+type SchemaModel struct {
+	Tables   []*Table   `json:"tables"`
+	Views    []*Table   `json:"views,omitempty"` // views and materialized views, columns only
+	Enums    []EnumType `json:"enums,omitempty"`
+	LoadedAt time.Time  `json:"loaded_at"`
+}
+
+// Table kinds other than an ordinary table, which has none.
+const (
+	tableKindPartitioned = "partitioned table"
+	tableKindView        = "view"
+	tableKindMatview     = "materialized view"
+)
+
+type Table struct {
+	Schema      string       `json:"schema"`
+	Name        string       `json:"name"`
+	Kind        string       `json:"kind,omitempty"`
+	Comment     string       `json:"comment,omitempty"`
+	RowEstimate int64        `json:"row_estimate"` // pg_class.reltuples; -1 if never analyzed
+	Columns     []Column     `json:"columns"`
+	PrimaryKey  *Constraint  `json:"primary_key,omitempty"`
+	Uniques     []Constraint `json:"unique_constraints,omitempty"`
+	Checks      []Check      `json:"check_constraints,omitempty"`
+	Indexes     []Index      `json:"indexes,omitempty"`
+	ForeignKeys []ForeignKey `json:"foreign_keys,omitempty"`
+}
+
+type Column struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`                  // format_type(), e.g. "character varying(64)"
+	BaseType   string `json:"base_type"`             // pg_type.typname, e.g. "varchar"
+	TypeSchema string `json:"type_schema,omitempty"` // schema of BaseType, e.g. "pg_catalog"
+	NotNull    bool   `json:"not_null"`
+	Default    string `json:"default,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	Position   int    `json:"position"`
+}
+
+// Constraint is a named primary key or unique constraint.
+type Constraint struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+}
+
+type Check struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"` // e.g. "((quantity > 0))"
+}
+
+type Index struct {
+	Name       string   `json:"name"`
+	Unique     bool     `json:"unique"`
+	Primary    bool     `json:"primary"`
+	Method     string   `json:"method"`
+	Keys       []string `json:"keys"` // columns or expressions, in index order
+	Definition string   `json:"definition"`
+	Constraint bool     `json:"backs_constraint"` // created by a PK/UNIQUE/EXCLUDE constraint
+}
+
+// ForeignKey keeps composite keys together: Columns[i] references RefColumns[i].
+type ForeignKey struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	RefSchema  string   `json:"ref_schema"`
+	RefTable   string   `json:"ref_table"`
+	RefColumns []string `json:"ref_columns"`
+}
+
+type EnumType struct {
+	Schema string   `json:"schema"`
+	Name   string   `json:"name"`
+	Labels []string `json:"labels"`
+}
+
+// QualifiedName returns schema.table with identifiers quoted only when needed.
+func (t *Table) QualifiedName() string {
+	return quoteIdent(t.Schema) + "." + quoteIdent(t.Name)
+}
+
+// Column returns the named column, or nil.
+func (t *Table) Column(name string) *Column {
+	for i := range t.Columns {
+		if t.Columns[i].Name == name {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// IsText reports whether the column holds character data.
+func (c Column) IsText() bool {
+	switch c.BaseType {
+	case "text", "varchar", "bpchar", "citext":
+		return true
+	}
+	return false
+}
+
+// Searchable returns the tables and then the views search covers.
+func (m *SchemaModel) Searchable() []*Table {
+	return append(slices.Clip(m.Tables), m.Views...)
+}
+
+// Relation returns the table or view with the given schema and name, or nil.
+func (m *SchemaModel) Relation(schema, name string) *Table {
+	if t := m.Table(schema, name); t != nil {
+		return t
+	}
+	for _, v := range m.Views {
+		if v.Schema == schema && v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// Table returns the table with the given schema and name, or nil.
+func (m *SchemaModel) Table(schema, name string) *Table {
+	for _, t := range m.Tables {
+		if t.Schema == schema && t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+var plainIdent = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// quoteIdent double-quotes identifiers that Postgres would otherwise fold to
+// lower case or reject, and leaves plain lower-case names untouched.
+func quoteIdent(s string) string {
+	if plainIdent.MatchString(s) {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func quoteIdents(names []string) string {
+	out := make([]string, len(names))
+	for i, n := range names {
+		out[i] = quoteIdent(n)
+	}
+	return strings.Join(out, ", ")
+}
+
+// ---------- renderers ----------
+
+// RenderPrompt renders the compact one-line-per-object summary fed to the LLM.
+func (m *SchemaModel) RenderPrompt() string {
+	var lines []string
+	for _, t := range m.Tables {
+		qn := t.QualifiedName()
+		pk := map[string]bool{}
+		if t.PrimaryKey != nil {
+			for _, c := range t.PrimaryKey.Columns {
+				pk[c] = true
+			}
+		}
+		cols := make([]Column, len(t.Columns))
+		copy(cols, t.Columns)
+		sort.Slice(cols, func(i, j int) bool { return cols[i].Name < cols[j].Name })
+		defs := make([]string, 0, len(cols))
+		for _, c := range cols {
+			d := quoteIdent(c.Name) + " " + c.Type
+			if pk[c.Name] {
+				d += " PRIMARY KEY"
+			} else if c.NotNull {
+				d += " NOT NULL"
+			}
+			if c.Default != "" {
+				d += " DEFAULT " + c.Default
+			}
+			defs = append(defs, d)
+		}
+		lines = append(lines, "TABLE "+qn+"("+strings.Join(defs, ", ")+")")
+
+		for _, fk := range t.ForeignKeys {
+			lines = append(lines, fmt.Sprintf("FK %s(%s) -> %s.%s(%s)", qn, quoteIdents(fk.Columns),
+				quoteIdent(fk.RefSchema), quoteIdent(fk.RefTable), quoteIdents(fk.RefColumns)))
+		}
+		for _, u := range t.Uniques {
+			lines = append(lines, "UNIQUE "+qn+"("+quoteIdents(u.Columns)+")")
+		}
+		for _, ck := range t.Checks {
+			lines = append(lines, "CHECK "+qn+" "+ck.Expr)
+		}
+		for _, ix := range t.Indexes {
+			if ix.Primary {
+				continue
+			}
+			line := "INDEX " + qn + " " + quoteIdent(ix.Name)
+			if ix.Unique {
+				line += " UNIQUE"
+			}
+			lines = append(lines, line+" USING "+ix.Method+" ("+strings.Join(ix.Keys, ", ")+")")
+		}
+		if t.Comment != "" {
+			lines = append(lines, "COMMENT "+qn+": "+oneLine(t.Comment))
+		}
+		for _, c := range t.Columns {
+			if c.Comment != "" {
+				lines = append(lines, "COMMENT "+qn+"."+quoteIdent(c.Name)+": "+oneLine(c.Comment))
+			}
+		}
+	}
+	for _, e := range m.Enums {
+		labels := make([]string, len(e.Labels))
+		for i, l := range e.Labels {
+			labels[i] = quoteLiteral(l)
+		}
+		lines = append(lines, "ENUM "+quoteIdent(e.Schema)+"."+quoteIdent(e.Name)+"("+strings.Join(labels, ", ")+")")
+	}
+	sort.Strings(lines)
+
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// RenderJSON renders the model as indented JSON.
+func (m *SchemaModel) RenderJSON() (string, error) {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// RenderDDL renders the model as CREATE statements that would recreate the
+// visible structure (types, tables, constraints, indexes and comments).
+func (m *SchemaModel) RenderDDL() string {
+	var b strings.Builder
+	for _, e := range m.Enums {
+		labels := make([]string, len(e.Labels))
+		for i, l := range e.Labels {
+			labels[i] = quoteLiteral(l)
+		}
+		fmt.Fprintf(&b, "CREATE TYPE %s.%s AS ENUM (%s);\n\n", quoteIdent(e.Schema), quoteIdent(e.Name), strings.Join(labels, ", "))
+	}
+	for _, t := range m.Tables {
+		qn := t.QualifiedName()
+		var defs []string
+		for _, c := range t.Columns {
+			d := "  " + quoteIdent(c.Name) + " " + c.Type
+			if c.NotNull {
+				d += " NOT NULL"
+			}
+			if c.Default != "" {
+				d += " DEFAULT " + c.Default
+			}
+			defs = append(defs, d)
+		}
+		if t.PrimaryKey != nil {
+			defs = append(defs, fmt.Sprintf("  CONSTRAINT %s PRIMARY KEY (%s)", quoteIdent(t.PrimaryKey.Name), quoteIdents(t.PrimaryKey.Columns)))
+		}
+		for _, u := range t.Uniques {
+			defs = append(defs, fmt.Sprintf("  CONSTRAINT %s UNIQUE (%s)", quoteIdent(u.Name), quoteIdents(u.Columns)))
+		}
+		for _, ck := range t.Checks {
+			defs = append(defs, fmt.Sprintf("  CONSTRAINT %s CHECK %s", quoteIdent(ck.Name), ck.Expr))
+		}
+		fmt.Fprintf(&b, "CREATE TABLE %s (\n%s\n);\n", qn, strings.Join(defs, ",\n"))
+		for _, ix := range t.Indexes {
+			if !ix.Constraint {
+				b.WriteString(ix.Definition + ";\n")
+			}
+		}
+		if t.Comment != "" {
+			fmt.Fprintf(&b, "COMMENT ON TABLE %s IS %s;\n", qn, quoteLiteral(t.Comment))
+		}
+		for _, c := range t.Columns {
+			if c.Comment != "" {
+				fmt.Fprintf(&b, "COMMENT ON COLUMN %s.%s IS %s;\n", qn, quoteIdent(c.Name), quoteLiteral(c.Comment))
+			}
+		}
+		b.WriteByte('\n')
+	}
+	// Foreign keys last so every referenced table already exists.
+	for _, t := range m.Tables {
+		for _, fk := range t.ForeignKeys {
+			fmt.Fprintf(&b, "ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s.%s (%s);\n",
+				t.QualifiedName(), quoteIdent(fk.Name), quoteIdents(fk.Columns),
+				quoteIdent(fk.RefSchema), quoteIdent(fk.RefTable), quoteIdents(fk.RefColumns))
+		}
+	}
+	return b.String()
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// ---------- cache ----------
+
+// SchemaCache serves the last loaded schema and refreshes it in the background.
+// Callers only block on the very first load; afterwards an expired entry is
+// returned as-is while a single revalidation runs, and the model is rebuilt
+// only when the catalog fingerprint changes.
+type SchemaCache struct {
+	mu          sync.RWMutex
+	model       *SchemaModel
+	txt         string
+	fingerprint string
+	expiresAt   time.Time
+	ttl         time.Duration
+
+	// filter hides excluded schemas and tables from every consumer of the model.
+	filter *objectFilter
+
+	refreshing atomic.Bool
+	// bg scopes background revalidations so shutdown cancels them; nil means
+	// context.Background().
+	bg context.Context
+	// onChange, if set, is called after a refresh replaced a previously
+	// loaded model with one that differs from it.
+	onChange func(old, cur *SchemaModel)
+}
+
+// Get returns the prompt rendering of the cached schema, loading it if needed.
+func (c *SchemaCache) Get(ctx context.Context, db *pgxpool.Pool) (string, error) {
+	_, txt, err := c.get(ctx, db)
+	return txt, err
+}
+
+// Model returns the cached typed schema, loading it if needed.
+func (c *SchemaCache) Model(ctx context.Context, db *pgxpool.Pool) (*SchemaModel, error) {
+	m, _, err := c.get(ctx, db)
+	return m, err
+}
+
+func (c *SchemaCache) get(ctx context.Context, db *pgxpool.Pool) (*SchemaModel, string, error) {
+	c.mu.RLock()
+	if c.model != nil {
+		m, txt, stale := c.model, c.txt, !time.Now().Before(c.expiresAt)
+		c.mu.RUnlock()
+		if stale {
+			c.revalidate(db)
+		}
+		return m, txt, nil
+	}
+	c.mu.RUnlock()
+
+	// Nothing to serve yet: load synchronously, once.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.model != nil {
+		return c.model, c.txt, nil
+	}
+	fp, err := schemaFingerprint(ctx, db)
+	if err != nil {
+		return nil, "", err
+	}
+	m, err := loadSchemaModel(ctx, db)
+	if err != nil {
+		return nil, "", err
+	}
+	c.filterModel(m, true)
+	c.store(m, fp)
+	return c.model, c.txt, nil
+}
+
+// filterModel applies the include/exclude filter to a freshly loaded model
+// and logs what was hidden (at info level for the first load).
+func (c *SchemaCache) filterModel(m *SchemaModel, initial bool) {
+	excluded := c.filter.apply(m)
+	if len(excluded) == 0 {
+		return
+	}
+	ev := log.Debug()
+	if initial {
+		ev = log.Info()
+	}
+	ev.Int("count", len(excluded)).Strs("excluded", excluded).Msg("schema filters excluded objects")
+}
+
+// store installs a freshly loaded model. The caller must hold c.mu for writing.
+func (c *SchemaCache) store(m *SchemaModel, fp string) {
+	txt := m.RenderPrompt()
+	if len(txt) > schemaMaxChars {
+		txt = txt[:schemaMaxChars] + "\n-- ...truncated schema..."
+	}
+	c.model = m
+	c.txt = txt
+	c.fingerprint = fp
+	c.expiresAt = time.Now().Add(c.ttl)
+}
+
+// revalidate starts a background refresh unless one is already running.
+func (c *SchemaCache) revalidate(db *pgxpool.Pool) {
+	if !c.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer c.refreshing.Store(false)
+		parent := c.bg
+		if parent == nil {
+			parent = context.Background()
+		}
+		ctx, cancel := context.WithTimeout(parent, 30*time.Second)
+		defer cancel()
+		if _, err := c.refresh(ctx, db); err != nil {
+			log.Warn().Err(err).Msg("schema revalidation failed; serving stale schema")
+		}
+	}()
+}
+
+// refresh compares the catalog fingerprint with the cached one and reloads
+// the model only if it changed. It reports whether the model was replaced.
+func (c *SchemaCache) refresh(ctx context.Context, db *pgxpool.Pool) (bool, error) {
+	fp, err := schemaFingerprint(ctx, db)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.RLock()
+	unchanged := c.model != nil && fp == c.fingerprint
+	initial := c.model == nil
+	c.mu.RUnlock()
+	if unchanged {
+		c.mu.Lock()
+		c.expiresAt = time.Now().Add(c.ttl)
+		c.mu.Unlock()
+		return false, nil
+	}
+
+	// Build the new model without holding the lock so readers keep the old one.
+	m, err := loadSchemaModel(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	c.filterModel(m, initial)
+	c.mu.Lock()
+	old := c.model
+	c.store(m, fp)
+	onChange := c.onChange
+	c.mu.Unlock()
+
+	if old != nil && schemaChanged(old, m) {
+		log.Info().Str("fingerprint", fp).Int("tables", len(m.Tables)).Msg("schema change detected")
+		if onChange != nil {
+			onChange(old, m)
+		}
+	}
+	return true, nil
+}
+
+// Watch polls the catalog fingerprint every interval until ctx is done, so
+// migrations are picked up without waiting for the TTL to expire.
+func (c *SchemaCache) Watch(ctx context.Context, db *pgxpool.Pool, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !c.refreshing.CompareAndSwap(false, true) {
+			continue
+		}
+		pollCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		if _, err := c.refresh(pollCtx, db); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("schema change check failed")
+		}
+		cancel()
+		c.refreshing.Store(false)
+	}
+}
+
+// changedTables returns the tables that were added, dropped or altered between two models.
+// Row estimates are ignored since they drift with every ANALYZE.
+func changedTables(old, cur *SchemaModel) []*Table {
+	return changedRelations(old.Tables, cur.Tables)
+}
+
+// schemaChanged reports whether any table, view or enum type differs
+// between two filtered models, so changes to hidden objects, which still
+// move the catalog fingerprint, go unannounced.
+func schemaChanged(old, cur *SchemaModel) bool {
+	return len(changedTables(old, cur)) > 0 || len(changedRelations(old.Views, cur.Views)) > 0 ||
+		!reflect.DeepEqual(old.Enums, cur.Enums)
+}
+
+func changedRelations(old, cur []*Table) []*Table {
+	key := func(t *Table) string {
+		cp := *t
+		cp.RowEstimate = 0
+		b, _ := json.Marshal(&cp)
+		return string(b)
+	}
+	before := map[string]string{}
+	for _, t := range old {
+		before[t.Schema+"."+t.Name] = key(t)
+	}
+	var out []*Table
+	for _, t := range cur {
+		id := t.Schema + "." + t.Name
+		if k, ok := before[id]; !ok || k != key(t) {
+			out = append(out, t)
+		}
+		delete(before, id)
+	}
+	for _, t := range old {
+		if _, dropped := before[t.Schema+"."+t.Name]; dropped {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// schemaFingerprintSQL hashes the catalog rows that feed the schema model.
+// It is cheap compared to a full load and changes on any DDL we render. It
+// covers objects the schema filters hide too, so a changed fingerprint only
+// triggers a reload; refresh compares the filtered models before
+// announcing a change.
+const schemaFingerprintSQL = `
+SELECT COALESCE(md5(string_agg(x, '|' ORDER BY x)), '') FROM (
+  SELECT 'r'||c.oid||':'||n.nspname||'.'||c.relname
+  FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+  WHERE c.relkind IN ('r','p','v','m','i') AND n.nspname NOT IN ('pg_catalog','information_schema') AND n.nspname NOT LIKE 'pg_toast%'
+  UNION ALL
+  SELECT 'a'||a.attrelid||':'||a.attnum||':'||a.attname||':'||a.atttypid||':'||a.atttypmod||':'||a.attnotnull||':'||a.atthasdef
+  FROM pg_attribute a JOIN pg_class c ON c.oid = a.attrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+  WHERE c.relkind IN ('r','p','v','m') AND a.attnum > 0 AND NOT a.attisdropped AND n.nspname NOT IN ('pg_catalog','information_schema')
+  UNION ALL
+  SELECT 'f'||d.oid||':'||d.adrelid||':'||d.adnum FROM pg_attrdef d
+  UNION ALL
+  SELECT 'c'||co.oid||':'||co.conname||':'||co.contype
+  FROM pg_constraint co JOIN pg_namespace n ON n.oid = co.connamespace
+  WHERE n.nspname NOT IN ('pg_catalog','information_schema')
+  UNION ALL
+  SELECT 'e'||e.enumtypid||':'||e.enumsortorder||':'||e.enumlabel FROM pg_enum e
+  UNION ALL
+  SELECT 'd'||d.objoid||':'||d.objsubid||':'||md5(d.description)
+  FROM pg_description d WHERE d.classoid = 'pg_class'::regclass
+) s(x)`
+
+func schemaFingerprint(ctx context.Context, db *pgxpool.Pool) (string, error) {
+	ctxTO, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var fp string
+	err := db.QueryRow(ctxTO, schemaFingerprintSQL).Scan(&fp)
+	return fp, err
+}
+
+// ---------- loading ----------
+
+// loadSchema returns the compact prompt rendering of the current catalog.
+func loadSchema(ctx context.Context, db *pgxpool.Pool) (string, error) {
+	m, err := loadSchemaModel(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	return m.RenderPrompt(), nil
+}
+
+const (
+	schemaColumnsSQL = `
+SELECT n.nspname, c.relname, COALESCE(obj_description(c.oid, 'pg_class'), ''), c.reltuples::bigint,
+       a.attname, pg_catalog.format_type(a.atttypid, a.atttypmod), t.typname, tn.nspname,
+       a.attnotnull, COALESCE(pg_catalog.pg_get_expr(d.adbin, d.adrelid), ''),
+       COALESCE(col_description(c.oid, a.attnum), ''), a.attnum, c.relkind::text
+FROM pg_attribute a
+JOIN pg_class c ON a.attrelid = c.oid
+JOIN pg_namespace n ON c.relnamespace = n.oid
+JOIN pg_type t ON t.oid = a.atttypid
+JOIN pg_namespace tn ON tn.oid = t.typnamespace
+LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+WHERE a.attnum > 0 AND NOT a.attisdropped AND c.relkind IN ('r','p','v','m')
+  AND n.nspname NOT IN ('pg_catalog','information_schema')
+ORDER BY n.nspname, c.relname, a.attnum`
+
+	schemaConstraintsSQL = `
+SELECT n.nspname, c.relname, co.conname, co.contype::text,
+       ARRAY(SELECT a.attname::text FROM unnest(co.conkey) WITH ORDINALITY AS k(attnum, pos)
+             JOIN pg_attribute a ON a.attrelid = co.conrelid AND a.attnum = k.attnum ORDER BY k.pos),
+       COALESCE(fn.nspname, ''), COALESCE(fc.relname, ''),
+       ARRAY(SELECT a.attname::text FROM unnest(co.confkey) WITH ORDINALITY AS k(attnum, pos)
+             JOIN pg_attribute a ON a.attrelid = co.confrelid AND a.attnum = k.attnum ORDER BY k.pos),
+       pg_catalog.pg_get_constraintdef(co.oid)
+FROM pg_constraint co
+JOIN pg_class c ON co.conrelid = c.oid
+JOIN pg_namespace n ON c.relnamespace = n.oid
+LEFT JOIN pg_class fc ON fc.oid = co.confrelid
+LEFT JOIN pg_namespace fn ON fn.oid = fc.relnamespace
+WHERE co.contype IN ('p','u','c','f') AND c.relkind IN ('r','p')
+  AND n.nspname NOT IN ('pg_catalog','information_schema')
+ORDER BY n.nspname, c.relname, co.conname`
+
+	schemaIndexesSQL = `
+SELECT n.nspname, c.relname, ic.relname, i.indisunique, i.indisprimary, am.amname,
+       ARRAY(SELECT pg_catalog.pg_get_indexdef(i.indexrelid, k, true) FROM generate_series(1, i.indnkeyatts) AS k),
+       pg_catalog.pg_get_indexdef(i.indexrelid),
+       EXISTS (SELECT 1 FROM pg_constraint co WHERE co.conindid = i.indexrelid AND co.conrelid = i.indrelid AND co.contype IN ('p','u','x'))
+FROM pg_index i
+JOIN pg_class ic ON ic.oid = i.indexrelid
+JOIN pg_class c ON c.oid = i.indrelid
+JOIN pg_namespace n ON c.relnamespace = n.oid
+JOIN pg_am am ON am.oid = ic.relam
+WHERE c.relkind IN ('r','p') AND n.nspname NOT IN ('pg_catalog','information_schema')
+ORDER BY n.nspname, c.relname, ic.relname`
+
+	schemaEnumsSQL = `
+SELECT n.nspname, t.typname, array_agg(e.enumlabel::text ORDER BY e.enumsortorder)
+FROM pg_type t
+JOIN pg_enum e ON e.enumtypid = t.oid
+JOIN pg_namespace n ON t.typnamespace = n.oid
+WHERE n.nspname NOT IN ('pg_catalog','information_schema')
+GROUP BY n.nspname, t.typname
+ORDER BY n.nspname, t.typname`
+)
+
+// loadSchemaModel reads tables, columns, constraints, indexes and enum types
+// from the system catalogs.
+func loadSchemaModel(ctx context.Context, db *pgxpool.Pool) (*SchemaModel, error) {
+	ctxTO, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	m := &SchemaModel{LoadedAt: time.Now()}
+	byName := map[string]*Table{}
+	lookup := func(schema, name string) *Table { return byName[schema+"\x00"+name] }
+
+	rows, err := db.Query(ctxTO, schemaColumnsSQL)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var schema, table, comment, relkind string
+		var estimate int64
+		var col Column
+		if err := rows.Scan(&schema, &table, &comment, &estimate, &col.Name, &col.Type, &col.BaseType, &col.TypeSchema,
+			&col.NotNull, &col.Default, &col.Comment, &col.Position, &relkind); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t := lookup(schema, table)
+		if t == nil {
+			t = &Table{Schema: schema, Name: table, Comment: comment, RowEstimate: estimate}
+			byName[schema+"\x00"+table] = t
+			switch relkind {
+			case "p":
+				t.Kind = tableKindPartitioned
+			case "v":
+				t.Kind = tableKindView
+			case "m":
+				t.Kind = tableKindMatview
+			}
+			if relkind == "v" || relkind == "m" {
+				m.Views = append(m.Views, t)
+			} else {
+				m.Tables = append(m.Tables, t)
+			}
+		}
+		t.Columns = append(t.Columns, col)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(ctxTO, schemaConstraintsSQL)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var schema, table, name, kind, refSchema, refTable, def string
+		var cols, refCols []string
+		if err := rows.Scan(&schema, &table, &name, &kind, &cols, &refSchema, &refTable, &refCols, &def); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t := lookup(schema, table)
+		if t == nil {
+			continue
+		}
+		switch kind {
+		case "p":
+			t.PrimaryKey = &Constraint{Name: name, Columns: cols}
+		case "u":
+			t.Uniques = append(t.Uniques, Constraint{Name: name, Columns: cols})
+		case "c":
+			t.Checks = append(t.Checks, Check{Name: name, Expr: strings.TrimPrefix(def, "CHECK ")})
+		case "f":
+			t.ForeignKeys = append(t.ForeignKeys, ForeignKey{
+				Name: name, Columns: cols, RefSchema: refSchema, RefTable: refTable, RefColumns: refCols,
+			})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(ctxTO, schemaIndexesSQL)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var schema, table string
+		var ix Index
+		if err := rows.Scan(&schema, &table, &ix.Name, &ix.Unique, &ix.Primary, &ix.Method,
+			&ix.Keys, &ix.Definition, &ix.Constraint); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if t := lookup(schema, table); t != nil {
+			t.Indexes = append(t.Indexes, ix)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(ctxTO, schemaEnumsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e EnumType
+		if err := rows.Scan(&e.Schema, &e.Name, &e.Labels); err != nil {
+			return nil, err
+		}
+		m.Enums = append(m.Enums, e)
+	}
+	return m, rows.Err()
+}
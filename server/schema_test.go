@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serverWithModel returns a Server whose schema cache is already populated,
+// so handlers that only need the catalog can run without a database.
+func serverWithModel(m *SchemaModel) *Server {
+	return &Server{cache: &SchemaCache{
+		model:     m,
+		txt:       m.RenderPrompt(),
+		expiresAt: time.Now().Add(time.Hour),
+		ttl:       time.Hour,
+	}}
+}
+
+// testSchemaModel builds a small catalog snapshot resembling the minimal test schema.
+func testSchemaModel() *SchemaModel {
+	return &SchemaModel{
+		Tables: []*Table{
+			{
+				Schema: "public", Name: "Categories",
+				Columns: []Column{
+					{Name: "id", Type: "integer", BaseType: "int4", NotNull: true, Default: `nextval('"Categories_id_seq"'::regclass)`, Position: 1},
+					{Name: "name", Type: "text", BaseType: "text", NotNull: true, Position: 2},
+				},
+				PrimaryKey: &Constraint{Name: "Categories_pkey", Columns: []string{"id"}},
+				Indexes: []Index{
+					{Name: "Categories_pkey", Unique: true, Primary: true, Method: "btree", Keys: []string{"id"},
+						Definition: `CREATE UNIQUE INDEX "Categories_pkey" ON public."Categories" USING btree (id)`, Constraint: true},
+				},
+			},
+			{
+				Schema: "public", Name: "order_items", Comment: "Line items\nper order",
+				Columns: []Column{
+					{Name: "order_id", Type: "integer", BaseType: "int4", NotNull: true, Position: 1},
+					{Name: "item_id", Type: "integer", BaseType: "int4", NotNull: true, Position: 2},
+					{Name: "quantity", Type: "integer", BaseType: "int4", NotNull: true, Position: 3, Comment: "units ordered"},
+					{Name: "status", Type: "order_status", BaseType: "order_status", TypeSchema: "public", Position: 4},
+				},
+				PrimaryKey: &Constraint{Name: "order_items_pkey", Columns: []string{"order_id", "item_id"}},
+				Checks:     []Check{{Name: "order_items_quantity_check", Expr: "((quantity > 0))"}},
+				Indexes: []Index{
+					{Name: "order_items_item_idx", Method: "btree", Keys: []string{"item_id"},
+						Definition: "CREATE INDEX order_items_item_idx ON public.order_items USING btree (item_id)"},
+				},
+				ForeignKeys: []ForeignKey{
+					{Name: "order_items_order_fk", Columns: []string{"order_id", "item_id"}, RefSchema: "public", RefTable: "shipments", RefColumns: []string{"order_id", "item_id"}},
+				},
+			},
+		},
+		Enums: []EnumType{{Schema: "public", Name: "order_status", Labels: []string{"placed", "it's shipped"}}},
+	}
+}
+
+func TestRenderPrompt(t *testing.T) {
+	out := testSchemaModel().RenderPrompt()
+
+	want := []string{
+		`TABLE public."Categories"(id integer PRIMARY KEY DEFAULT nextval('"Categories_id_seq"'::regclass), name text NOT NULL)`,
+		`TABLE public.order_items(item_id integer PRIMARY KEY, order_id integer PRIMARY KEY, quantity integer NOT NULL, status order_status)`,
+		`FK public.order_items(order_id, item_id) -> public.shipments(order_id, item_id)`,
+		`CHECK public.order_items ((quantity > 0))`,
+		`INDEX public.order_items order_items_item_idx USING btree (item_id)`,
+		`ENUM public.order_status('placed', 'it''s shipped')`,
+		`COMMENT public.order_items: Line items per order`,
+		`COMMENT public.order_items.quantity: units ordered`,
+	}
+	for _, w := range want {
+		if !strings.Contains(out, w+"\n") {
+			t.Fatalf("prompt rendering missing %q\n%s", w, out)
+		}
+	}
+	if strings.Contains(out, "Categories_pkey") {
+		t.Fatalf("primary key index should not be listed as INDEX:\n%s", out)
+	}
+
+	// Lines are sorted so the output is stable across catalog orderings
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i-1] > lines[i] {
+			t.Fatalf("lines not sorted: %q before %q", lines[i-1], lines[i])
+		}
+	}
+}
+
+func TestRenderDDL(t *testing.T) {
+	out := testSchemaModel().RenderDDL()
+
+	want := []string{
+		`CREATE TYPE public.order_status AS ENUM ('placed', 'it''s shipped');`,
+		`CREATE TABLE public."Categories" (`,
+		`  CONSTRAINT "Categories_pkey" PRIMARY KEY (id)`,
+		`  CONSTRAINT order_items_quantity_check CHECK ((quantity > 0))`,
+		`CREATE INDEX order_items_item_idx ON public.order_items USING btree (item_id);`,
+		`COMMENT ON TABLE public.order_items IS 'Line items` + "\n" + `per order';`,
+		`ALTER TABLE public.order_items ADD CONSTRAINT order_items_order_fk FOREIGN KEY (order_id, item_id) REFERENCES public.shipments (order_id, item_id);`,
+	}
+	for _, w := range want {
+		if !strings.Contains(out, w) {
+			t.Fatalf("DDL rendering missing %q\n%s", w, out)
+		}
+	}
+	// Indexes that back constraints are created by the constraint itself
+	if strings.Contains(out, `CREATE UNIQUE INDEX "Categories_pkey"`) {
+		t.Fatalf("constraint index should not be emitted separately:\n%s", out)
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	out, err := testSchemaModel().RenderJSON()
+	if err != nil {
+		t.Fatalf("RenderJSON: %v", err)
+	}
+	var back SchemaModel
+	if err := json.Unmarshal([]byte(out), &back); err != nil {
+		t.Fatalf("RenderJSON produced invalid JSON: %v", err)
+	}
+	tbl := back.Table("public", "order_items")
+	if tbl == nil || len(tbl.ForeignKeys) != 1 || len(tbl.ForeignKeys[0].Columns) != 2 {
+		t.Fatalf("round-tripped model lost composite foreign key: %+v", tbl)
+	}
+	if c := tbl.Column("quantity"); c == nil || !c.NotNull || c.Comment != "units ordered" {
+		t.Fatalf("round-tripped column mismatch: %+v", c)
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	tests := map[string]string{
+		"users":       "users",
+		"_private":    "_private",
+		"Book":        `"Book"`,
+		"user-data":   `"user-data"`,
+		`we"ird`:      `"we""ird"`,
+		"1table":      `"1table"`,
+		"order_items": "order_items",
+	}
+	for in, want := range tests {
+		if got := quoteIdent(in); got != want {
+			t.Fatalf("quoteIdent(%q)=%s want %s", in, got, want)
+		}
+	}
+}
+
+func TestColumnIsText(t *testing.T) {
+	for _, bt := range []string{"text", "varchar", "bpchar", "citext"} {
+		if !(Column{BaseType: bt}).IsText() {
+			t.Fatalf("%s should be text", bt)
+		}
+	}
+	for _, bt := range []string{"int4", "uuid", "jsonb", "_text"} {
+		if (Column{BaseType: bt}).IsText() {
+			t.Fatalf("%s should not be text", bt)
+		}
+	}
+}
+
+func TestSchemaCacheServesStaleWhileRefreshing(t *testing.T) {
+	m := testSchemaModel()
+	cache := &SchemaCache{model: m, txt: "stale schema", expiresAt: time.Now().Add(-time.Minute), ttl: time.Minute}
+	// Pretend a revalidation is already in flight so Get must not start another one
+	cache.refreshing.Store(true)
+
+	txt, err := cache.Get(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Get on stale cache: %v", err)
+	}
+	if txt != "stale schema" {
+		t.Fatalf("expected stale text to be served, got %q", txt)
+	}
+	got, err := cache.Model(context.Background(), nil)
+	if err != nil || got != m {
+		t.Fatalf("expected stale model to be served, got %p err=%v", got, err)
+	}
+}
+
+func TestSchemaCacheServesEmptySchema(t *testing.T) {
+	// A database with no visible tables renders an empty prompt; it is still
+	// a loaded schema and must be served without touching the database.
+	m := &SchemaModel{}
+	cache := &SchemaCache{model: m, expiresAt: time.Now().Add(time.Minute), ttl: time.Minute}
+
+	got, err := cache.Model(context.Background(), nil)
+	if err != nil || got != m {
+		t.Fatalf("expected the empty model to be served, got %p err=%v", got, err)
+	}
+}
+
+func TestChangedTables(t *testing.T) {
+	old := testSchemaModel()
+	cur := testSchemaModel()
+
+	if got := changedTables(old, cur); len(got) != 0 {
+		t.Fatalf("identical models reported changes: %v", got)
+	}
+
+	// Row estimates drift constantly and must not count as a change
+	cur.Tables[0].RowEstimate = 1234
+	if got := changedTables(old, cur); len(got) != 0 {
+		t.Fatalf("row estimate change reported as schema change")
+	}
+
+	// Altered, added and dropped tables are all reported
+	cur.Tables[1].Columns = append(cur.Tables[1].Columns, Column{Name: "note", Type: "text", BaseType: "text", Position: 5})
+	cur.Tables = append(cur.Tables, &Table{Schema: "public", Name: "shipments"})
+	cur.Tables = cur.Tables[1:] // drop "Categories"
+
+	names := map[string]bool{}
+	for _, tbl := range changedTables(old, cur) {
+		names[tbl.Name] = true
+	}
+	for _, want := range []string{"Categories", "order_items", "shipments"} {
+		if !names[want] {
+			t.Fatalf("expected %s to be reported as changed, got %v", want, names)
+		}
+	}
+}
+
+func TestSchemaChanged(t *testing.T) {
+	f, err := newObjectFilter(Config{SchemaExclude: []string{"audit"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	load := func(auditCols ...Column) *SchemaModel {
+		m := testSchemaModel()
+		m.Tables = append(m.Tables, &Table{Schema: "audit", Name: "log", Columns: auditCols})
+		f.apply(m)
+		return m
+	}
+	old := load()
+
+	// Altering a hidden table moves the fingerprint but not the filtered model
+	cur := load(Column{Name: "note", Type: "text", BaseType: "text", Position: 1})
+	cur.Tables[0].RowEstimate = 1234
+	if schemaChanged(old, cur) {
+		t.Fatal("change to an excluded table reported")
+	}
+
+	cur.Views = []*Table{{Schema: "public", Name: "recent", Kind: tableKindView}}
+	if !schemaChanged(old, cur) {
+		t.Fatal("added view not reported")
+	}
+	cur = load()
+	cur.Enums[0].Labels = append(cur.Enums[0].Labels, "lost")
+	if !schemaChanged(old, cur) {
+		t.Fatal("enum change not reported")
+	}
+}
@@ -0,0 +1,722 @@
+// server/search.go
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	searchModeText     = "text"     // ILIKE substring match (default)
+	searchModeFullText = "fulltext" // tsvector / websearch_to_tsquery, ranked
+	searchModeFuzzy    = "fuzzy"    // pg_trgm similarity, ranked
+
+	// How text mode compares a column with q
+	searchMatchSubstring     = "substring"      // ILIKE anywhere (default)
+	searchMatchPrefix        = "prefix"         // ILIKE at the start
+	searchMatchExact         = "exact"          // ILIKE the whole value
+	searchMatchCaseSensitive = "case_sensitive" // LIKE anywhere
+	searchMatchRegex         = "regex"          // POSIX regular expression, case-sensitive
+
+	// What typed search reads q as
+	searchTypeUUID   = "uuid"
+	searchTypeEmail  = "email"
+	searchTypeNumber = "number"
+	searchTypeDate   = "date"
+
+	defaultFTSConfig      = "english"
+	defaultFuzzyThreshold = 0.3 // pg_trgm's own default
+	defaultSearchWorkers  = 4
+	defaultSearchBudget   = 10 * time.Second
+	maxNotedColumns       = 20 // columns named in a note before it just counts the rest
+	ftsHeadlineOption     = "MaxFragments=2, MaxWords=20, MinWords=5"
+)
+
+var (
+	uuidText   = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	emailText  = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	numberText = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?$`)
+)
+
+// searchScope is the part of a search request that picks what to search,
+// as globs or /regex/. Tables are name or schema.name; columns are name,
+// table.name or schema.table.name. Excludes win.
+type searchScope struct {
+	Schemas        []string `json:"schemas,omitempty"`
+	Tables         []string `json:"tables,omitempty"`
+	Columns        []string `json:"columns,omitempty"`
+	ExcludeSchemas []string `json:"exclude_schemas,omitempty"`
+	ExcludeTables  []string `json:"exclude_tables,omitempty"`
+	ExcludeColumns []string `json:"exclude_columns,omitempty"`
+}
+
+// searchFilter narrows a search to some schemas, tables and columns. Table
+// patterns work as in SCHEMA_INCLUDE and TABLE_INCLUDE. A column pattern
+// without a dot matches the column name, otherwise table.column or
+// schema.table.column. Excludes win over includes; no includes means all.
+type searchFilter struct {
+	tables                       *objectFilter
+	columnInclude, columnExclude []objectPattern
+}
+
+// newSearchFilter builds the filter a search request asks for, or nil if it
+// asks for none.
+func newSearchFilter(in searchScope) (*searchFilter, error) {
+	if len(in.Schemas)+len(in.ExcludeSchemas)+len(in.Tables)+len(in.ExcludeTables)+len(in.Columns)+len(in.ExcludeColumns) == 0 {
+		return nil, nil
+	}
+	tables, err := newObjectFilter(Config{
+		SchemaInclude: in.Schemas, SchemaExclude: in.ExcludeSchemas,
+		TableInclude: in.Tables, TableExclude: in.ExcludeTables,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f := &searchFilter{tables: tables}
+	for _, l := range []struct {
+		dst *[]objectPattern
+		src []string
+	}{
+		{&f.columnInclude, in.Columns},
+		{&f.columnExclude, in.ExcludeColumns},
+	} {
+		for _, raw := range l.src {
+			p, err := parseObjectPattern(raw)
+			if err != nil {
+				return nil, err
+			}
+			*l.dst = append(*l.dst, p)
+		}
+	}
+	return f, nil
+}
+
+func (f *searchFilter) allowTable(t *Table) bool {
+	return f == nil || f.tables.allowTable(t.Schema, t.Name)
+}
+
+func (f *searchFilter) allowColumn(t *Table, column string) bool {
+	if f == nil {
+		return true
+	}
+	m := func(p objectPattern) bool {
+		if !p.qualified {
+			return p.match(column)
+		}
+		return p.match(t.Name+"."+column) || p.match(t.Schema+"."+t.Name+"."+column)
+	}
+	if anyMatch(f.columnExclude, m) {
+		return false
+	}
+	return len(f.columnInclude) == 0 || anyMatch(f.columnInclude, m)
+}
+
+// textMatch is how text mode compares a column with q: value OP $1.
+type textMatch struct {
+	param any // bound to $1
+	op    string
+	regex bool
+	qLen  int
+}
+
+func newTextMatch(match, q string) (textMatch, error) {
+	tm := textMatch{param: containsPattern(q), op: "ILIKE", qLen: utf8.RuneCountInString(q)}
+	switch match {
+	case "", searchMatchSubstring:
+	case searchMatchPrefix:
+		tm.param = likeEscaper.Replace(q) + "%"
+	case searchMatchExact:
+		tm.param = likeEscaper.Replace(q)
+	case searchMatchCaseSensitive:
+		tm.op = "LIKE"
+	case searchMatchRegex:
+		if q == "" {
+			return tm, errors.New("match regex needs a non-empty pattern in q")
+		}
+		tm.param, tm.op, tm.regex = q, "~", true
+	default:
+		return tm, fmt.Errorf("unknown match %q: use %s, %s, %s, %s or %s", match,
+			searchMatchSubstring, searchMatchPrefix, searchMatchExact, searchMatchCaseSensitive, searchMatchRegex)
+	}
+	return tm, nil
+}
+
+// score is the share of col the match covers, so shorter values rank higher.
+// A regex match's length is what removing it takes off col, since
+// substring() would return only the pattern's first capture group.
+func (tm textMatch) score(col string) string {
+	if tm.regex {
+		return fmt.Sprintf("(char_length(%s) - char_length(regexp_replace(%s, $1::text, '')))::float8 / greatest(char_length(%s), 1)", col, col, col)
+	}
+	return fmt.Sprintf("%d::float8 / greatest(char_length(%s), 1)", tm.qLen, col)
+}
+
+// classifySearchText reports whether q is a UUID, email address, number or
+// date (YYYY-MM-DD), or "" if it is none of them.
+func classifySearchText(q string) string {
+	switch {
+	case uuidText.MatchString(q):
+		return searchTypeUUID
+	case emailText.MatchString(q):
+		return searchTypeEmail
+	case numberText.MatchString(q):
+		return searchTypeNumber
+	}
+	if _, err := time.Parse(time.DateOnly, q); err == nil {
+		return searchTypeDate
+	}
+	return ""
+}
+
+// typedCondition returns the condition matching a column of c's type
+// against q read as kind, or "" if the column cannot hold such a value.
+// value returns the placeholder q is bound to, and is only called when a
+// condition is returned. Integers that overflow the column are skipped
+// rather than left to fail the cast.
+func typedCondition(kind, q string, c Column, col string, value func() string) string {
+	switch kind {
+	case searchTypeUUID:
+		if c.BaseType == "uuid" {
+			return fmt.Sprintf("%s = CAST(%s AS uuid)", col, value())
+		}
+	case searchTypeNumber:
+		bits := map[string]int{"int2": 16, "int4": 32, "int8": 64}[c.BaseType]
+		if bits > 0 {
+			if _, err := strconv.ParseInt(strings.TrimPrefix(q, "+"), 10, bits); err != nil {
+				return ""
+			}
+		} else if c.BaseType != "numeric" && c.BaseType != "float4" && c.BaseType != "float8" {
+			return ""
+		}
+		return fmt.Sprintf("%s = CAST(%s AS %s)", col, value(), c.BaseType)
+	case searchTypeDate:
+		switch c.BaseType {
+		case "date":
+			return fmt.Sprintf("%s = CAST(%s AS date)", col, value())
+		case "timestamp", "timestamptz":
+			v := value()
+			return fmt.Sprintf("%s >= CAST(%s AS date) AND %s < CAST(%s AS date) + 1", col, v, col, v)
+		}
+	}
+	return ""
+}
+
+// ftsIndexKey matches an expression index key as pg_get_indexdef prints it,
+// e.g. to_tsvector('english'::regconfig, title).
+var ftsIndexKey = regexp.MustCompile(`^to_tsvector\('((?:[^']|'')+)'::regconfig, (.+)\)$`)
+
+// ftsTarget is one tsvector searched by full-text search: a tsvector column,
+// or a text column converted with to_tsvector.
+type ftsTarget struct {
+	column    string
+	expr      string // the quoted column
+	converted bool   // a text column, matched as to_tsvector(config, expr)
+	config    string // text search configuration; the index's when indexed
+	doc       string // SQL for the text ts_headline highlights
+	indexed   bool   // a GIN index can answer the match
+}
+
+// ftsTargets lists what full-text search reads from t. A table with tsvector
+// columns is searched through them, since they are what its owners built to
+// be searched; otherwise each text column is converted on the fly, using the
+// configuration of a matching to_tsvector GIN index if there is one so the
+// planner can use it.
+func ftsTargets(t *Table, config string, f *searchFilter) []ftsTarget {
+	gin := map[string]bool{}          // indexed tsvector columns
+	exprConfig := map[string]string{} // quoted column -> config of its to_tsvector index
+	for _, ix := range t.Indexes {
+		if ix.Method != "gin" || len(ix.Keys) != 1 {
+			continue
+		}
+		if m := ftsIndexKey.FindStringSubmatch(ix.Keys[0]); m != nil {
+			exprConfig[m[2]] = strings.ReplaceAll(m[1], "''", "'")
+		} else {
+			gin[ix.Keys[0]] = true
+		}
+	}
+
+	var texts []string
+	for _, c := range t.Columns {
+		if c.IsText() {
+			texts = append(texts, pgx.Identifier{c.Name}.Sanitize())
+		}
+	}
+	var out []ftsTarget
+	for _, c := range t.Columns {
+		if c.BaseType != "tsvector" || !f.allowColumn(t, c.Name) {
+			continue
+		}
+		doc := "CAST(" + pgx.Identifier{c.Name}.Sanitize() + " AS text)"
+		if len(texts) > 0 {
+			doc = "concat_ws(' ', " + strings.Join(texts, ", ") + ")"
+		}
+		out = append(out, ftsTarget{
+			column: c.Name, expr: pgx.Identifier{c.Name}.Sanitize(), config: config, doc: doc,
+			indexed: gin[quoteIdent(c.Name)],
+		})
+	}
+	if len(out) > 0 {
+		return out
+	}
+	for _, c := range t.Columns {
+		if !c.IsText() || !f.allowColumn(t, c.Name) {
+			continue
+		}
+		col := pgx.Identifier{c.Name}.Sanitize()
+		target := ftsTarget{column: c.Name, expr: col, converted: true, config: config, doc: col}
+		if cfg, ok := exprConfig[quoteIdent(c.Name)]; ok {
+			target.config, target.indexed = cfg, true
+		}
+		out = append(out, target)
+	}
+	return out
+}
+
+// buildFullTextSQL returns a query per table matching q with
+// websearch_to_tsquery against its tsvector columns, or its text columns
+// converted with to_tsvector, best ts_rank first. Only the returned rows get
+// a ts_headline snippet, since highlighting re-parses the document. The
+// notes list the columns no full-text index covers.
+func (s *Server) buildFullTextSQL(ctx context.Context, q string, limit int, f *searchFilter) ([]tableSearch, []string, error) {
+	model, err := s.cache.Model(ctx, s.db)
+	if err != nil {
+		return nil, nil, err
+	}
+	defaultConfig := cmp.Or(s.cfg.SearchFTSConfig, defaultFTSConfig)
+
+	var out []tableSearch
+	var unindexed []string
+	columns := 0
+	for _, t := range model.Searchable() {
+		if !f.allowTable(t) {
+			continue
+		}
+		args := []any{q, t.Schema + "." + t.Name}
+		param := func(v any, typ string) string {
+			args = append(args, v)
+			return fmt.Sprintf("$%d::%s", len(args), typ)
+		}
+		configs := map[string]string{} // config -> its placeholder
+		key := rowKeySQL(t, &args)
+		var parts []string
+		for _, tg := range ftsTargets(t, defaultConfig, f) {
+			cfg, ok := configs[tg.config]
+			if !ok {
+				cfg = param(tg.config, "regconfig")
+				configs[tg.config] = cfg
+			}
+			vector := tg.expr
+			if tg.converted {
+				vector = fmt.Sprintf("to_tsvector(%s, %s)", cfg, tg.expr)
+			}
+			query := fmt.Sprintf("websearch_to_tsquery(%s, $1::text)", cfg)
+			parts = append(parts, fmt.Sprintf(
+				`SELECT $2::text AS source_table, %s AS column, %s AS cfg, ts_rank(%s, %s) AS score, %s AS doc, %s AS row_key FROM %s WHERE %s @@ %s`,
+				param(tg.column, "text"), cfg, vector, query, tg.doc, key, pgx.Identifier{t.Schema, t.Name}.Sanitize(), vector, query,
+			))
+			if !tg.indexed {
+				unindexed = append(unindexed, t.Schema+"."+t.Name+"."+tg.column)
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		columns += len(parts)
+		finish := fmt.Sprintf(`source_table, "column", score, ts_headline(cfg, doc, websearch_to_tsquery(cfg, $1::text), '%s') AS match_text, row_key`, ftsHeadlineOption)
+		sql := "WITH u AS (\n" + strings.Join(parts, "\nUNION ALL\n") + fmt.Sprintf("\n), top AS (SELECT * FROM u ORDER BY score DESC LIMIT %d)\n", limit) +
+			"SELECT " + finish + " FROM top ORDER BY score DESC"
+		out = append(out, tableSearch{table: t, sql: sql, args: args, parts: parts, finish: finish})
+	}
+	if len(out) == 0 {
+		return nil, nil, errors.New("no searchable columns")
+	}
+
+	var notes []string
+	if len(unindexed) > 0 {
+		notes = append(notes, fmt.Sprintf("%d of %d columns have no full-text GIN index and were converted with to_tsvector on the fly: %s",
+			len(unindexed), columns, columnList(unindexed)))
+	}
+	return out, notes, nil
+}
+
+// trgmSchema returns the schema pg_trgm is installed in, so its functions
+// and operators can be qualified regardless of search_path.
+func (s *Server) trgmSchema(ctx context.Context) (string, error) {
+	var schema string
+	err := s.db.QueryRow(ctx, `SELECT n.nspname FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace WHERE e.extname = 'pg_trgm'`).Scan(&schema)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", errors.New("fuzzy search needs the pg_trgm extension (CREATE EXTENSION pg_trgm)")
+	}
+	return schema, err
+}
+
+// hasTrigramIndex reports whether a GIN or GiST trigram index covers column
+// on its own, which is what lets the planner answer % with an index scan.
+func hasTrigramIndex(t *Table, column string) bool {
+	for _, ix := range t.Indexes {
+		if (ix.Method == "gin" || ix.Method == "gist") && len(ix.Keys) == 1 && ix.Keys[0] == quoteIdent(column) &&
+			strings.Contains(ix.Definition, "_trgm_ops") {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzyThreshold is the similarity threshold for a fuzzy search: the
+// request's when it gives one, 0 included, and otherwise the configured one.
+func (s *Server) fuzzyThreshold(requested *float64) (float64, error) {
+	threshold := cmp.Or(s.cfg.SearchFuzzyThreshold, defaultFuzzyThreshold)
+	if requested != nil {
+		threshold = *requested
+	}
+	if threshold < 0 || threshold > 1 {
+		return 0, fmt.Errorf("threshold %v is not between 0 and 1", threshold)
+	}
+	return threshold, nil
+}
+
+// buildFuzzySQL returns a query per table matching q against its text
+// columns with pg_trgm's % operator, most similar first with the
+// similarity() as score. % compares against pg_trgm.similarity_threshold,
+// which the caller sets for the transaction. The notes list the columns no
+// trigram index covers.
+func (s *Server) buildFuzzySQL(ctx context.Context, q string, limit int, f *searchFilter) ([]tableSearch, []string, error) {
+	schema, err := s.trgmSchema(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	model, err := s.cache.Model(ctx, s.db)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fuzzySearchSQL(model, schema, q, limit, f)
+}
+
+// fuzzySearchSQL is buildFuzzySQL for pg_trgm installed in trgmSchema.
+func fuzzySearchSQL(model *SchemaModel, trgmSchema, q string, limit int, f *searchFilter) ([]tableSearch, []string, error) {
+	ext := pgx.Identifier{trgmSchema}.Sanitize()
+
+	var out []tableSearch
+	var unindexed []string
+	columns := 0
+	for _, t := range model.Searchable() {
+		if !f.allowTable(t) {
+			continue
+		}
+		args := []any{q, t.Schema + "." + t.Name}
+		key := rowKeySQL(t, &args)
+		var parts []string
+		for _, c := range t.Columns {
+			if !c.IsText() || !f.allowColumn(t, c.Name) {
+				continue
+			}
+			args = append(args, c.Name)
+			col := pgx.Identifier{c.Name}.Sanitize()
+			parts = append(parts, fmt.Sprintf(
+				`SELECT $2::text AS source_table, $%d::text AS column, %s.similarity(%s, $1::text) AS score, LEFT(CAST(%s AS text), 240) AS match_text, %s AS row_key FROM %s WHERE %s OPERATOR(%s.%%) $1::text`,
+				len(args), ext, col, col, key, pgx.Identifier{t.Schema, t.Name}.Sanitize(), col, ext,
+			))
+			if !hasTrigramIndex(t, c.Name) {
+				unindexed = append(unindexed, t.Schema+"."+t.Name+"."+c.Name)
+			}
+		}
+		if len(parts) > 0 {
+			columns += len(parts)
+			out = append(out, tableSearch{table: t, sql: unionSearchSQL(parts, limit), args: args, parts: parts})
+		}
+	}
+	if len(out) == 0 {
+		return nil, nil, errors.New("no searchable columns")
+	}
+
+	var notes []string
+	if len(unindexed) > 0 {
+		notes = append(notes, fmt.Sprintf("%d of %d columns have no trigram index (gin_trgm_ops or gist_trgm_ops) and were scanned in full: %s",
+			len(unindexed), columns, columnList(unindexed)))
+	}
+	return out, notes, nil
+}
+
+// queryCanceledCode is the SQLSTATE of a query stopped by a cancel request,
+// which is how context deadlines reach the server.
+const queryCanceledCode = "57014"
+
+// invalidRegexCode is the SQLSTATE of a malformed regular expression.
+const invalidRegexCode = "2201B"
+
+// regexInputError returns the input error to report for err when it is a
+// malformed regular expression, and nil otherwise.
+func regexInputError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != invalidRegexCode {
+		return nil
+	}
+	return fmt.Errorf("invalid regular expression in q: %s", strings.TrimPrefix(pgErr.Message, "invalid regular expression: "))
+}
+
+// searchTimedOut reports whether a table's query failed by running out of
+// time rather than by a real error.
+func searchTimedOut(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &pgErr) && pgErr.Code == queryCanceledCode
+}
+
+// tableSearch is the search query for one table. Each runs on its own
+// connection, so a slow or failing table does not hold up the rest.
+type tableSearch struct {
+	table *Table
+	sql   string
+	args  []any
+	shown []any // args as reported in the response, when they differ
+
+	// For faceted search: one SELECT per column, and the select list that
+	// turns their rows into hits when it is not simply their own columns
+	parts  []string
+	finish string
+}
+
+// label names the table in reports, as schema.table.
+func (ts tableSearch) label() string {
+	return ts.table.Schema + "." + ts.table.Name
+}
+
+// searchedTables is what a search of t reads, for the result cache: t
+// itself when it is an ordinary table, and otherwise nil, leaving it to the
+// plan (views read their base tables, partitioned tables their partitions).
+func searchedTables(t *Table) []string {
+	if t == nil || t.Kind != "" {
+		return nil
+	}
+	return []string{t.Schema + "." + t.Name}
+}
+
+// rowKeySQL returns a jsonb object identifying a row of t by the columns
+// rowKeyColumns lists, as text. The column names are bound as parameters
+// appended to args, so the same expression can be repeated in every part
+// of the table's query. Rows of a view have no key, so their key is null
+// and their hits carry no row_uri.
+func rowKeySQL(t *Table, args *[]any) string {
+	if t.Kind == tableKindView {
+		return `NULL::jsonb`
+	}
+	if t.PrimaryKey == nil {
+		if t.Kind == tableKindPartitioned {
+			return `jsonb_build_object('tableoid', CAST(tableoid AS text), 'ctid', CAST(ctid AS text))`
+		}
+		return `jsonb_build_object('ctid', CAST(ctid AS text))`
+	}
+	var pairs []string
+	for _, c := range t.PrimaryKey.Columns {
+		*args = append(*args, c)
+		pairs = append(pairs, fmt.Sprintf("$%d::text, CAST(%s AS text)", len(*args), pgx.Identifier{c}.Sanitize()))
+	}
+	return "jsonb_build_object(" + strings.Join(pairs, ", ") + ")"
+}
+
+// unionSearchSQL combines one table's per-column matches, best first.
+func unionSearchSQL(parts []string, limit int) string {
+	return "SELECT * FROM (\n" + strings.Join(parts, "\nUNION ALL\n") + fmt.Sprintf("\n) u ORDER BY score DESC LIMIT %d", limit)
+}
+
+// columnList names columns for a note, counting those past maxNotedColumns.
+func columnList(cols []string) string {
+	if len(cols) <= maxNotedColumns {
+		return strings.Join(cols, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(cols[:maxNotedColumns], ", "), len(cols)-maxNotedColumns)
+}
+
+// searchQuery is the SQL that produced some of a search's rows.
+type searchQuery struct {
+	Table  string `json:"table"`
+	SQL    string `json:"sql"`
+	Params []any  `json:"params"` // values bound to $1, $2, ... in SQL
+}
+
+type searchTableError struct {
+	Table string `json:"table"`
+	Error string `json:"error"`
+}
+
+// searchRun is the merged result of a search's table queries.
+type searchRun struct {
+	columns  []columnInfo
+	rows     []map[string]any
+	queries  []searchQuery // for the tables with rows in the result
+	replica  string        // where the queries ran, comma-separated if they were spread out
+	searched int
+	skipped  []string // not started within the time budget
+	timedOut []string // cancelled by the time budget or QUERY_TIMEOUT
+	failed   []searchTableError
+	cachedAt *time.Time // oldest table result, when every table came from the cache
+}
+
+// runSearch runs the table queries on up to SEARCH_WORKERS connections at
+// once within the SEARCH_TIME_BUDGET, and merges their rows best score
+// (or nearest) first. The first worker uses the request's admission slot;
+// each other one starts only if it can take a free slot, so a busy server
+// searches with fewer connections rather than queueing on the pool. Each table's result is cached on its own, so a write to one table
+// only re-runs that table's query. Tables that fail or run out of time are
+// reported rather than failing the search, unless every table failed.
+func (s *Server) runSearch(ctx context.Context, req *mcp.CallToolRequest, searches []tableSearch, settings map[string]string, limit int) (*searchRun, error) {
+	budgetCtx, cancel := context.WithTimeout(ctx, cmp.Or(s.cfg.SearchBudget, defaultSearchBudget))
+	defer cancel()
+
+	type outcome struct {
+		started  bool
+		res      *queryResult
+		cachedAt *time.Time
+		err      error
+	}
+	outcomes := make([]outcome, len(searches))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := range min(cmp.Or(s.cfg.SearchWorkers, defaultSearchWorkers), len(searches)) {
+		free := func() {}
+		if w > 0 {
+			var ok bool
+			if free, ok = s.tryHold(); !ok {
+				break
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer free()
+			for i := range next {
+				ts := searches[i]
+				res, cachedAt, err := cachedQuery(s, req, "search", ts.sql, ts.args, searchedTables(ts.table), []any{limit, settings}, func() (*queryResult, error) {
+					return s.runReadOnlyQueryWith(budgetCtx, settings, ts.sql, limit, ts.args...)
+				})
+				outcomes[i] = outcome{started: true, res: res, cachedAt: cachedAt, err: err}
+			}
+		}()
+	}
+feed:
+	for i := range searches {
+		select {
+		case next <- i:
+		case <-budgetCtx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// A bad regex fails every table alike, so report it once as bad input
+	for _, o := range outcomes {
+		if err := regexInputError(o.err); err != nil {
+			return nil, err
+		}
+	}
+
+	run := &searchRun{}
+	type hit struct {
+		row   map[string]any
+		table int
+	}
+	var hits []hit
+	var replicas []string
+	allCached := true
+	for i, o := range outcomes {
+		table := searches[i].label()
+		switch {
+		case !o.started:
+			run.skipped = append(run.skipped, table)
+		case o.err != nil && searchTimedOut(o.err):
+			run.timedOut = append(run.timedOut, table)
+		case o.err != nil:
+			run.failed = append(run.failed, searchTableError{Table: table, Error: o.err.Error()})
+		default:
+			run.searched++
+			if run.columns == nil {
+				run.columns = o.res.Columns
+			}
+			if o.res.Replica != "" && !slices.Contains(replicas, o.res.Replica) {
+				replicas = append(replicas, o.res.Replica)
+			}
+			if o.cachedAt == nil {
+				allCached = false
+			} else if run.cachedAt == nil || o.cachedAt.Before(*run.cachedAt) {
+				run.cachedAt = o.cachedAt
+			}
+			for _, row := range o.res.Rows {
+				hits = append(hits, hit{row, i})
+			}
+		}
+	}
+	if run.searched == 0 && len(run.failed) > 0 && len(run.timedOut) == 0 && len(run.skipped) == 0 {
+		return nil, fmt.Errorf("search failed on every table: %s", run.failed[0].Error)
+	}
+	if !allCached || run.searched == 0 {
+		run.cachedAt = nil
+	}
+	run.replica = strings.Join(replicas, ", ")
+
+	// Tables come back best first, so a stable sort keeps each table's order
+	slices.SortStableFunc(hits, func(a, b hit) int { return cmp.Compare(searchScore(b.row), searchScore(a.row)) })
+	hits = hits[:min(len(hits), limit)]
+	run.rows = make([]map[string]any, 0, len(hits))
+	seen := map[int]bool{}
+	for _, h := range hits {
+		ts := searches[h.table]
+		// Rows may be shared with the result cache, so add the URI to a copy
+		row := maps.Clone(h.row)
+		if key, ok := row["row_key"].(map[string]any); ok {
+			row["row_uri"] = rowResourceURI(ts.table.Schema, ts.table.Name, rowKey(key))
+		}
+		run.rows = append(run.rows, row)
+		if !seen[h.table] {
+			seen[h.table] = true
+			params := ts.args
+			if ts.shown != nil {
+				params = ts.shown
+			}
+			run.queries = append(run.queries, searchQuery{Table: ts.label(), SQL: ts.sql, Params: params})
+		}
+	}
+	if run.columns != nil {
+		run.columns = append(slices.Clip(run.columns), columnInfo{Name: "row_uri", Type: "text"})
+	}
+	return run, nil
+}
+
+// rowKey converts a decoded row_key object to its column values.
+func rowKey(obj map[string]any) map[string]string {
+	key := make(map[string]string, len(obj))
+	for k, v := range obj {
+		key[k] = fmt.Sprint(v)
+	}
+	return key
+}
+
+// searchScore ranks a row by its score, or by its negated distance for
+// semantic search; non-numeric values (NaN) sort last.
+func searchScore(row map[string]any) float64 {
+	if f, ok := row["score"].(float64); ok {
+		return f
+	}
+	if d, ok := row["distance"].(float64); ok {
+		return -d
+	}
+	return math.Inf(-1)
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestBuildFullTextSQL(t *testing.T) {
+	m := testSchemaModel()
+	m.Tables = append(m.Tables,
+		&Table{
+			Schema: "public", Name: "docs",
+			Columns: []Column{
+				{Name: "title", Type: "text", BaseType: "text", Position: 1},
+				{Name: "tsv", Type: "tsvector", BaseType: "tsvector", Position: 2},
+			},
+			Indexes: []Index{{Name: "docs_tsv_idx", Method: "gin", Keys: []string{"tsv"}}},
+		},
+		&Table{
+			Schema: "public", Name: "posts",
+			Columns: []Column{
+				{Name: "body", Type: "text", BaseType: "text", Position: 1},
+				{Name: "Tag", Type: "text", BaseType: "text", Position: 2},
+			},
+			Indexes: []Index{{Name: "posts_body_fts", Method: "gin", Keys: []string{"to_tsvector('simple'::regconfig, body)"}}},
+		},
+	)
+	q := `"noise cancelling" -wired`
+	searches, notes, err := serverWithModel(m).buildFullTextSQL(context.Background(), q, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(searches) != 3 {
+		t.Fatalf("got %d table searches, want 3", len(searches))
+	}
+	for i, want := range []string{
+		// Converted on the fly with the default configuration
+		`ts_rank(to_tsvector($4::regconfig, "name"), websearch_to_tsquery($4::regconfig, $1::text)) AS score, "name" AS doc, jsonb_build_object($3::text, CAST("id" AS text)) AS row_key FROM "public"."Categories"`,
+		// The tsvector column is used directly, with the table's text as the headline document
+		`ts_rank("tsv", websearch_to_tsquery($3::regconfig, $1::text)) AS score, concat_ws(' ', "title") AS doc, jsonb_build_object('ctid', CAST(ctid AS text)) AS row_key FROM "public"."docs" WHERE "tsv" @@`,
+		// The index's configuration is kept so the planner can use it
+		`WHERE to_tsvector($3::regconfig, "body") @@ websearch_to_tsquery($3::regconfig, $1::text)`,
+	} {
+		sql := searches[i].sql
+		if !strings.Contains(sql, want) || !strings.Contains(sql, `top AS (SELECT * FROM u ORDER BY score DESC LIMIT 10)`) ||
+			!strings.Contains(sql, `SELECT source_table, "column", score, ts_headline(cfg, doc, websearch_to_tsquery(cfg, $1::text), 'MaxFragments=2, MaxWords=20, MinWords=5') AS match_text, row_key FROM top`) {
+			t.Errorf("sql missing %q:\n%s", want, sql)
+		}
+		if strings.Contains(sql, "noise") || strings.Contains(sql, `"title" @@`) {
+			t.Fatalf("unexpected sql:\n%s", sql)
+		}
+	}
+	if want := []any{q, "public.posts", "simple", "body", "english", "Tag"}; !reflect.DeepEqual(searches[2].args, want) {
+		t.Fatalf("args = %q, want %q", searches[2].args, want)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "2 of 4 columns") || !strings.Contains(notes[0], "public.posts.Tag") {
+		t.Fatalf("notes = %q", notes)
+	}
+}
+
+func TestFuzzySearchSQL(t *testing.T) {
+	m := testSchemaModel()
+	m.Tables = append(m.Tables, &Table{
+		Schema: "public", Name: "users",
+		Columns: []Column{
+			{Name: "id", Type: "integer", BaseType: "int4", Position: 1},
+			{Name: "full_name", Type: "text", BaseType: "text", Position: 2},
+		},
+		Indexes: []Index{{Name: "users_name_trgm", Method: "gin", Keys: []string{"full_name"},
+			Definition: "CREATE INDEX users_name_trgm ON public.users USING gin (full_name extensions.gin_trgm_ops)"}},
+	})
+	searches, notes, err := fuzzySearchSQL(m, "extensions", "Jon Smyth", 20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(searches) != 2 {
+		t.Fatalf("got %d table searches, want 2", len(searches))
+	}
+	sql := searches[1].sql
+	for _, want := range []string{
+		`"extensions".similarity("full_name", $1::text) AS score`,
+		`AS match_text, jsonb_build_object('ctid', CAST(ctid AS text)) AS row_key FROM "public"."users" WHERE "full_name" OPERATOR("extensions".%) $1::text`,
+		`) u ORDER BY score DESC LIMIT 20`,
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("sql missing %q:\n%s", want, sql)
+		}
+	}
+	if strings.Contains(sql, "Smyth") {
+		t.Fatalf("search text spliced into sql:\n%s", sql)
+	}
+	if want := []any{"Jon Smyth", "public.users", "full_name"}; !reflect.DeepEqual(searches[1].args, want) {
+		t.Fatalf("args = %q, want %q", searches[1].args, want)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "1 of 2 columns") || !strings.Contains(notes[0], "public.Categories.name") || strings.Contains(notes[0], "full_name") {
+		t.Fatalf("notes = %q", notes)
+	}
+}
+
+func TestFuzzyThreshold(t *testing.T) {
+	s := &Server{cfg: Config{SearchFuzzyThreshold: 0.4}}
+	at := func(f float64) *float64 { return &f }
+	for _, tt := range []struct {
+		requested *float64
+		want      float64
+	}{
+		{nil, 0.4},
+		{at(0), 0}, // an explicit 0 is kept, not taken for "unset"
+		{at(0.7), 0.7},
+	} {
+		if got, err := s.fuzzyThreshold(tt.requested); err != nil || got != tt.want {
+			t.Fatalf("fuzzyThreshold(%v) = %v, %v; want %v", tt.requested, got, err, tt.want)
+		}
+	}
+	if got, _ := (&Server{}).fuzzyThreshold(nil); got != defaultFuzzyThreshold {
+		t.Fatalf("unconfigured threshold = %v", got)
+	}
+	if _, err := s.fuzzyThreshold(at(1.5)); err == nil {
+		t.Fatal("expected an out of range error")
+	}
+}
+
+func TestColumnList(t *testing.T) {
+	var cols []string
+	for i := range maxNotedColumns + 3 {
+		cols = append(cols, fmt.Sprintf("public.t.c%d", i))
+	}
+	if got := columnList(cols[:2]); got != "public.t.c0, public.t.c1" {
+		t.Fatalf("got %q", got)
+	}
+	if got := columnList(cols); !strings.HasSuffix(got, "public.t.c19 and 3 more") {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestRunSearchMergesByScore(t *testing.T) {
+	c, err := newResultCache(Config{ResultCacheTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{resultCache: c, cfg: Config{SearchWorkers: 2}}
+	searches := []tableSearch{
+		{table: &Table{Schema: "public", Name: "a"}, sql: "SELECT a", args: []any{"q"}},
+		{table: &Table{Schema: "public", Name: "b"}, sql: "SELECT b", args: []any{"q"}},
+		{table: &Table{Schema: "public", Name: "c"}, sql: "SELECT c", args: []any{"q"}},
+	}
+	rows := [][]map[string]any{
+		{{"source_table": "public.a", "score": 0.9, "row_key": map[string]any{"id": "7"}}, {"source_table": "public.a", "score": 0.2}},
+		{{"source_table": "public.b", "score": 0.5}},
+		{},
+	}
+	older := time.Now().Add(-time.Minute)
+	for i, ts := range searches {
+		ran := time.Now().Add(-time.Second)
+		if i == 1 {
+			ran = older
+		}
+		c.put(c.key(nil, "search", ts.sql, ts.args, []any{2, map[string]string(nil)}), &queryResult{Rows: rows[i]}, []string{ts.label()}, ran)
+	}
+
+	// Every table is cached, so nothing touches the database
+	run, err := s.runSearch(context.Background(), nil, searches, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scores []float64
+	for _, r := range run.rows {
+		scores = append(scores, searchScore(r))
+	}
+	if !reflect.DeepEqual(scores, []float64{0.9, 0.5}) {
+		t.Fatalf("scores = %v", scores)
+	}
+	if run.searched != 3 || len(run.queries) != 2 || run.queries[0].Table != "public.a" || run.queries[1].Table != "public.b" {
+		t.Fatalf("searched %d, queries %+v", run.searched, run.queries)
+	}
+	if got := run.rows[0]["row_uri"]; got != "pgmcp://row/public/a/id%3D7" {
+		t.Fatalf("row_uri = %v", got)
+	}
+	if _, ok := rows[0][0]["row_uri"]; ok {
+		t.Fatal("row_uri was added to the cached row")
+	}
+	if run.cachedAt == nil || !run.cachedAt.Equal(older) {
+		t.Fatalf("cachedAt = %v, want the oldest table result", run.cachedAt)
+	}
+}
+
+func TestSearchFilter(t *testing.T) {
+	f, err := newSearchFilter(searchScope{
+		Schemas: []string{"public", "sales"}, ExcludeTables: []string{"audit_*"},
+		Columns: []string{"email", "orders.note", "/^sales\\.orders\\.ref$/"}, ExcludeColumns: []string{"*_hash"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders := &Table{Schema: "sales", Name: "orders"}
+	for _, tt := range []struct {
+		table  *Table
+		column string
+		want   bool
+	}{
+		{orders, "email", true},
+		{orders, "note", true}, // table.column
+		{orders, "ref", true},  // schema.table.column regex
+		{orders, "total", false},
+		{&Table{Schema: "public", Name: "users"}, "note", false}, // orders.note is another table's
+		{&Table{Schema: "public", Name: "users"}, "email_hash", false},
+	} {
+		if got := f.allowColumn(tt.table, tt.column); got != tt.want {
+			t.Errorf("allowColumn(%s.%s, %s) = %v", tt.table.Schema, tt.table.Name, tt.column, got)
+		}
+	}
+	if f.allowTable(&Table{Schema: "public", Name: "audit_log"}) || f.allowTable(&Table{Schema: "hr", Name: "staff"}) || !f.allowTable(orders) {
+		t.Fatal("table filter not applied")
+	}
+
+	if f, err := newSearchFilter(searchScope{}); f != nil || err != nil {
+		t.Fatalf("no filters should give a nil filter, got %v, %v", f, err)
+	}
+	var none *searchFilter
+	if !none.allowTable(orders) || !none.allowColumn(orders, "x") {
+		t.Fatal("a nil filter allows everything")
+	}
+	if _, err := newSearchFilter(searchScope{ExcludeColumns: []string{"/(/"}}); err == nil {
+		t.Fatal("expected an invalid pattern error")
+	}
+}
+
+func TestClassifySearchText(t *testing.T) {
+	for q, want := range map[string]string{
+		"3F2504E0-4F89-11D3-9A0C-0305E82C3301": searchTypeUUID,
+		"jane.doe@example.com":                 searchTypeEmail,
+		"42":                                   searchTypeNumber,
+		"-3.50":                                searchTypeNumber,
+		"2024-02-29":                           searchTypeDate,
+		"2023-02-29":                           "",
+		"1e9":                                  "",
+		"NaN":                                  "",
+		"jane doe":                             "",
+	} {
+		if got := classifySearchText(q); got != want {
+			t.Errorf("classifySearchText(%q) = %q, want %q", q, got, want)
+		}
+	}
+}
+
+func TestBuildSearchSQLMatchAndTyped(t *testing.T) {
+	m := testSchemaModel()
+	m.Tables = append(m.Tables, &Table{
+		Schema: "public", Name: "events",
+		Columns: []Column{
+			{Name: "id", Type: "uuid", BaseType: "uuid", Position: 1},
+			{Name: "note", Type: "text", BaseType: "text", Position: 2},
+			{Name: "day", Type: "date", BaseType: "date", Position: 3},
+			{Name: "at", Type: "timestamp with time zone", BaseType: "timestamptz", Position: 4},
+			{Name: "amount", Type: "numeric(10,2)", BaseType: "numeric", Position: 5},
+			{Name: "small", Type: "smallint", BaseType: "int2", Position: 6},
+		},
+		PrimaryKey: &Constraint{Name: "events_pkey", Columns: []string{"id"}},
+	})
+	srv := serverWithModel(m)
+	ctx := context.Background()
+
+	for match, want := range map[string]string{
+		searchMatchPrefix:        `WHERE "name" ILIKE $1::text`,
+		searchMatchCaseSensitive: `WHERE "name" LIKE $1::text`,
+		searchMatchRegex:         `(char_length("name") - char_length(regexp_replace("name", $1::text, '')))::float8 / greatest(char_length("name"), 1) AS score`,
+	} {
+		searches, _, err := srv.buildSearchSQL(ctx, "a_b", 5, match, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(searches[0].sql, want) {
+			t.Errorf("%s: sql missing %q:\n%s", match, want, searches[0].sql)
+		}
+	}
+	searches, _, _ := srv.buildSearchSQL(ctx, "a_b", 5, searchMatchExact, false, nil)
+	if searches[0].args[0] != `a\_b` {
+		t.Fatalf("exact pattern = %q", searches[0].args[0])
+	}
+	if _, _, err := srv.buildSearchSQL(ctx, "a", 5, "glob", false, nil); err == nil || !strings.Contains(err.Error(), "unknown match") {
+		t.Fatalf("expected unknown match error, got %v", err)
+	}
+	if _, _, err := srv.buildSearchSQL(ctx, "", 5, searchMatchRegex, false, nil); err == nil || !strings.Contains(err.Error(), "non-empty pattern") {
+		t.Fatalf("expected empty pattern error, got %v", err)
+	}
+
+	// A number is compared with the numeric columns it fits in, bound once
+	searches, notes, err := srv.buildSearchSQL(ctx, "70000", 5, "", true, &searchFilter{tables: &objectFilter{}, columnExclude: []objectPattern{{raw: "quantity"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(searches) != 3 {
+		t.Fatalf("got %d table searches", len(searches))
+	}
+	sql := searches[2].sql
+	for _, want := range []string{
+		`1::float8 AS score, LEFT(CAST("amount" AS text), 240) AS match_text, jsonb_build_object($3::text, CAST("id" AS text)) AS row_key FROM "public"."events" WHERE "amount" = CAST($5::text AS numeric)`,
+		`WHERE "note" ILIKE $1::text`,
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("sql missing %q:\n%s", want, sql)
+		}
+	}
+	if strings.Contains(sql, `"small" =`) || strings.Contains(searches[1].sql, `"quantity"`) {
+		t.Fatalf("overflowing or excluded columns searched:\n%s\n%s", sql, searches[1].sql)
+	}
+	if want := []any{"%70000%", "public.events", "id", "note", "70000", "amount"}; !reflect.DeepEqual(searches[2].args, want) {
+		t.Fatalf("args = %q, want %q", searches[2].args, want)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "4 columns") || !strings.Contains(notes[0], "public.order_items.order_id") {
+		t.Fatalf("notes = %q", notes)
+	}
+
+	searches, _, _ = srv.buildSearchSQL(ctx, "2024-05-01", 5, "", true, nil)
+	if sql := searches[len(searches)-1].sql; !strings.Contains(sql, `WHERE "day" = CAST($5::text AS date)`) ||
+		!strings.Contains(sql, `WHERE "at" >= CAST($5::text AS date) AND "at" < CAST($5::text AS date) + 1`) {
+		t.Fatalf("date conditions missing:\n%s", sql)
+	}
+
+	searches, notes, _ = srv.buildSearchSQL(ctx, "Jane@Example.com", 5, "", true, nil)
+	if searches[0].args[0] != "Jane@Example.com" || len(notes) != 1 || !strings.Contains(notes[0], "whole value") {
+		t.Fatalf("email should match whole values: %q, %q", searches[0].args, notes)
+	}
+}
+
+func TestBuildSearchSQLViews(t *testing.T) {
+	m := testSchemaModel()
+	m.Views = []*Table{
+		{Schema: "public", Name: "recent_orders", Kind: tableKindView, Columns: []Column{{Name: "note", Type: "text", BaseType: "text", Position: 1}}},
+		{Schema: "public", Name: "order_totals", Kind: tableKindMatview, Columns: []Column{{Name: "label", Type: "text", BaseType: "text", Position: 1}}},
+	}
+	searches, _, err := serverWithModel(m).buildSearchSQL(context.Background(), "a", 5, "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byLabel := map[string]tableSearch{}
+	for _, ts := range searches {
+		byLabel[ts.label()] = ts
+	}
+	view, matview := byLabel["public.recent_orders"], byLabel["public.order_totals"]
+	if view.table == nil || matview.table == nil {
+		t.Fatalf("views not searched: %v", slices.Collect(maps.Keys(byLabel)))
+	}
+	// A view has no ctid; a materialized view does
+	if !strings.Contains(view.sql, `NULL::jsonb AS row_key FROM "public"."recent_orders"`) {
+		t.Errorf("view row key:\n%s", view.sql)
+	}
+	if !strings.Contains(matview.sql, `jsonb_build_object('ctid', CAST(ctid AS text)) AS row_key`) {
+		t.Errorf("materialized view row key:\n%s", matview.sql)
+	}
+	if !strings.Contains(facetSearchSQL(view, 3), "(SELECT count(*) FROM u) AS table_count") {
+		t.Errorf("view facets count rows by key:\n%s", facetSearchSQL(view, 3))
+	}
+
+	// Only ordinary tables skip the plan lookup when cached
+	if searchedTables(view.table) != nil || searchedTables(matview.table) != nil {
+		t.Fatal("views should have their tables looked up")
+	}
+	if got := searchedTables(m.Tables[0]); !reflect.DeepEqual(got, []string{"public.Categories"}) {
+		t.Fatalf("searchedTables = %q", got)
+	}
+}
+
+func TestSearchTimedOut(t *testing.T) {
+	for _, err := range []error{
+		context.DeadlineExceeded,
+		fmt.Errorf("query: %w", context.DeadlineExceeded),
+		fmt.Errorf("query: %w", &pgconn.PgError{Code: queryCanceledCode}),
+	} {
+		if !searchTimedOut(err) {
+			t.Fatalf("searchTimedOut(%v) = false", err)
+		}
+	}
+	// A real error stays a failure even when the budget has run out meanwhile
+	for _, err := range []error{errors.New("boom"), &pgconn.PgError{Code: "42703"}} {
+		if searchTimedOut(err) {
+			t.Fatalf("searchTimedOut(%v) = true", err)
+		}
+	}
+}
+
+func TestRegexInputError(t *testing.T) {
+	bad := fmt.Errorf("search: %w", &pgconn.PgError{Code: invalidRegexCode, Message: "invalid regular expression: parentheses () not balanced"})
+	if err := regexInputError(bad); err == nil || err.Error() != "invalid regular expression in q: parentheses () not balanced" {
+		t.Fatalf("regexInputError = %v", err)
+	}
+	for _, err := range []error{nil, errors.New("boom"), &pgconn.PgError{Code: queryCanceledCode}} {
+		if got := regexInputError(err); got != nil {
+			t.Fatalf("regexInputError(%v) = %v", err, got)
+		}
+	}
+}
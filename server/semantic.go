@@ -0,0 +1,355 @@
+// server/semantic.go
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	openai "github.com/openai/openai-go/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	embedProviderOpenAI = "openai"
+	embedProviderStub   = "stub" // local, deterministic word hashing; no API calls
+
+	defaultEmbeddingModel = "text-embedding-3-small"
+	defaultStubDims       = 256 // for vector columns without a declared dimension
+	embedTimeout          = 15 * time.Second
+
+	metricL2     = "l2"     // <->
+	metricCosine = "cosine" // <=>
+
+	hnswDefaultEFSearch = 40 // pgvector's default hnsw.ef_search, which caps the rows an index scan returns
+)
+
+var metricOperators = map[string]string{metricL2: "<->", metricCosine: "<=>"}
+
+// vectorTypmod reads the dimension from a type as format_type prints it, e.g. vector(1536).
+var vectorTypmod = regexp.MustCompile(`\((\d+)\)$`)
+
+// embedder turns search text into a vector of dims dimensions; zero dims
+// means the model's own size.
+type embedder interface {
+	embed(ctx context.Context, text string, dims int) ([]float32, error)
+}
+
+func newEmbedder(cfg Config, llm openai.Client) embedder {
+	if cfg.EmbeddingProvider == embedProviderStub {
+		return stubEmbedder{}
+	}
+	return openaiEmbedder{llm: llm, model: cmp.Or(cfg.EmbeddingModel, defaultEmbeddingModel)}
+}
+
+// openaiEmbedder calls the embeddings endpoint of the configured OpenAI API.
+type openaiEmbedder struct {
+	llm   openai.Client
+	model string
+}
+
+func (e openaiEmbedder) embed(ctx context.Context, text string, dims int) ([]float32, error) {
+	ctxTO, cancel := context.WithTimeout(ctx, embedTimeout)
+	defer cancel()
+
+	params := openai.EmbeddingNewParams{
+		Input: openai.EmbeddingNewParamsInputUnion{OfString: openai.String(text)},
+		Model: openai.EmbeddingModel(e.model),
+	}
+	// Only text-embedding-3 and later can shorten their output
+	if dims > 0 && strings.HasPrefix(e.model, "text-embedding-3") {
+		params.Dimensions = openai.Int(int64(dims))
+	}
+	resp, err := e.llm.Embeddings.New(ctxTO, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, errors.New("embedding response has no data")
+	}
+	v := make([]float32, len(resp.Data[0].Embedding))
+	for i, f := range resp.Data[0].Embedding {
+		v[i] = float32(f)
+	}
+	if dims > 0 && len(v) != dims {
+		return nil, fmt.Errorf("embedding model %s returned %d dimensions, the column has %d", e.model, len(v), dims)
+	}
+	return v, nil
+}
+
+// stubEmbedder hashes each word of the text into a bucket and normalizes
+// the counts, so texts sharing words are near each other. It lets
+// semantic_search run locally and in tests without an embedding API.
+type stubEmbedder struct{}
+
+func (stubEmbedder) embed(_ context.Context, text string, dims int) ([]float32, error) {
+	v := make([]float32, cmp.Or(dims, defaultStubDims))
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	if len(words) == 0 {
+		return nil, errors.New("nothing to embed: the text has no words")
+	}
+	for _, w := range words {
+		h := fnv.New64a()
+		h.Write([]byte(w))
+		v[h.Sum64()%uint64(len(v))]++
+	}
+	var norm float64
+	for _, f := range v {
+		norm += float64(f * f)
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] = float32(float64(v[i]) / norm)
+	}
+	return v, nil
+}
+
+// vectorLiteral formats v as pgvector's text input, e.g. [0.5,1,-2].
+func vectorLiteral(v []float32) string {
+	parts := make([]string, len(v))
+	for i, f := range v {
+		parts[i] = strconv.FormatFloat(float64(f), 'g', -1, 32)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// vectorColumn is a pgvector column semantic search can order by.
+type vectorColumn struct {
+	table  *Table
+	column string
+	typ    string // vector or halfvec
+	dims   int    // 0 when the column declares none
+	metric string // the metric of its hnsw or ivfflat index, "" without one
+}
+
+// vectorColumns lists the vector and halfvec columns the filter allows.
+func vectorColumns(m *SchemaModel, f *searchFilter) []vectorColumn {
+	var out []vectorColumn
+	for _, t := range m.Searchable() {
+		if !f.allowTable(t) {
+			continue
+		}
+		for _, c := range t.Columns {
+			if (c.BaseType != "vector" && c.BaseType != "halfvec") || !f.allowColumn(t, c.Name) {
+				continue
+			}
+			vc := vectorColumn{table: t, column: c.Name, typ: c.BaseType, metric: vectorIndexMetric(t, c.Name)}
+			if m := vectorTypmod.FindStringSubmatch(c.Type); m != nil {
+				vc.dims, _ = strconv.Atoi(m[1])
+			}
+			out = append(out, vc)
+		}
+	}
+	return out
+}
+
+// vectorIndexMetric returns the metric of the hnsw or ivfflat index on
+// column, read from its operator class, or "" if there is none.
+func vectorIndexMetric(t *Table, column string) string {
+	for _, ix := range t.Indexes {
+		if (ix.Method != "hnsw" && ix.Method != "ivfflat") || len(ix.Keys) != 1 || ix.Keys[0] != quoteIdent(column) {
+			continue
+		}
+		switch {
+		case strings.Contains(ix.Definition, "_l2_ops"):
+			return metricL2
+		case strings.Contains(ix.Definition, "_cosine_ops"):
+			return metricCosine
+		}
+	}
+	return ""
+}
+
+// defaultMetric is the metric the columns' vector indexes agree on, so
+// they can be used, or cosine when they have none or disagree.
+func defaultMetric(cols []vectorColumn) string {
+	metric := ""
+	for _, c := range cols {
+		switch {
+		case c.metric == "":
+		case metric == "":
+			metric = c.metric
+		case metric != c.metric:
+			return metricCosine
+		}
+	}
+	return cmp.Or(metric, metricCosine)
+}
+
+// semanticSearchSQL returns a query per table ordering each of its vector
+// columns by distance from the query embedding for the column's dimension
+// (in vectors, as pgvector literals), nearest first. Every column is its own
+// ORDER BY ... LIMIT subquery, which is the shape an hnsw or ivfflat index
+// can answer. Rows come back as JSON without their vector columns. The
+// notes list the columns no index for metric covers.
+func semanticSearchSQL(cols []vectorColumn, metric string, vectors map[int]string, limit int) ([]tableSearch, []string) {
+	op := metricOperators[metric]
+	var out []tableSearch
+	var unindexed []string
+	for start := 0; start < len(cols); {
+		t := cols[start].table
+		end := start
+		for end < len(cols) && cols[end].table == t {
+			end++
+		}
+
+		args := []any{t.Schema + "." + t.Name}
+		param := func(v any) int {
+			args = append(args, v)
+			return len(args)
+		}
+		key := rowKeySQL(t, &args)
+		var vectorNames []string
+		for _, c := range t.Columns {
+			if c.BaseType == "vector" || c.BaseType == "halfvec" {
+				vectorNames = append(vectorNames, c.Name)
+			}
+		}
+		hidden := param(vectorNames)
+		embeddings := map[int]int{} // dims -> placeholder number
+		var parts []string
+		for _, vc := range cols[start:end] {
+			n, ok := embeddings[vc.dims]
+			if !ok {
+				n = param(vectors[vc.dims])
+				embeddings[vc.dims] = n
+			}
+			col := pgx.Identifier{vc.column}.Sanitize()
+			parts = append(parts, fmt.Sprintf(
+				`(SELECT $1::text AS source_table, $%d::text AS column, %s %s CAST($%d::text AS %s) AS distance, to_jsonb(r.*) - $%d::text[] AS row, %s AS row_key FROM %s r WHERE %s IS NOT NULL ORDER BY distance LIMIT %d)`,
+				param(vc.column), col, op, n, vc.typ, hidden, key, pgx.Identifier{t.Schema, t.Name}.Sanitize(), col, limit,
+			))
+			if vc.metric != metric {
+				unindexed = append(unindexed, t.Schema+"."+t.Name+"."+vc.column)
+			}
+		}
+		// Report the embeddings by size rather than as thousands of numbers
+		shown := slices.Clone(args)
+		for _, n := range embeddings {
+			shown[n-1] = fmt.Sprintf("<embedding of q, %d dimensions>", strings.Count(args[n-1].(string), ",")+1)
+		}
+		sql := "SELECT * FROM (\n" + strings.Join(parts, "\nUNION ALL\n") + fmt.Sprintf("\n) u ORDER BY distance LIMIT %d", limit)
+		out = append(out, tableSearch{table: t, sql: sql, args: args, shown: shown})
+		start = end
+	}
+
+	var notes []string
+	if len(unindexed) > 0 {
+		notes = append(notes, fmt.Sprintf("%d of %d vector columns have no hnsw or ivfflat index for the %s metric and were scanned in full: %s",
+			len(unindexed), len(cols), metric, columnList(unindexed)))
+	}
+	return out, notes
+}
+
+// ---------- semantic_search tool ----------
+
+type semanticSearchInput struct {
+	Q      string `json:"q"`
+	Limit  int    `json:"limit,omitempty"`
+	Metric string `json:"metric,omitempty"` // l2 (<->) or cosine (<=>); default: what the columns' vector indexes use, else cosine
+	searchScope
+}
+
+type semanticSearchOutput struct {
+	searchOutput
+	Metric         string `json:"metric"`
+	EmbeddingModel string `json:"embedding_model"`
+}
+
+func (s *Server) handleSemanticSearch(ctx context.Context, req *mcp.CallToolRequest, in semanticSearchInput) (*mcp.CallToolResult, semanticSearchOutput, error) {
+	start := time.Now()
+	ctx, stop := s.requests.withRequestCancel(ctx, req)
+	defer stop()
+	user := requestIdentity(req)
+
+	log.Debug().Str("tool", "semantic_search").Str("q", strings.TrimSpace(in.Q)).Int("limit", in.Limit).Str("metric", in.Metric).Msg("request")
+
+	if err := sanitizeInput(in.Q); err != nil {
+		auditLog("semantic_search_input_validation_failed", user, in.Q, err.Error(), false)
+		log.Debug().Str("tool", "semantic_search").Err(err).Msg("input validation failed")
+		return nil, semanticSearchOutput{}, err
+	}
+	limit := minNonZero(in.Limit, 50)
+	filter, err := newSearchFilter(in.searchScope)
+	if err != nil {
+		return nil, semanticSearchOutput{}, err
+	}
+	model, err := s.cache.Model(ctx, s.db)
+	if err != nil {
+		log.Debug().Str("tool", "semantic_search").Err(err).Msg("schema load failed")
+		return nil, semanticSearchOutput{}, err
+	}
+	cols := vectorColumns(model, filter)
+	if len(cols) == 0 {
+		return nil, semanticSearchOutput{}, errors.New("no vector columns to search (semantic search needs pgvector vector or halfvec columns)")
+	}
+	metric := cmp.Or(in.Metric, defaultMetric(cols))
+	if _, ok := metricOperators[metric]; !ok {
+		return nil, semanticSearchOutput{}, fmt.Errorf("unknown metric %q: use %s or %s", metric, metricL2, metricCosine)
+	}
+
+	// One embedding per column dimension, before taking a query slot
+	vectors := map[int]string{}
+	for _, c := range cols {
+		if _, ok := vectors[c.dims]; ok {
+			continue
+		}
+		v, err := s.embedder.embed(ctx, in.Q, c.dims)
+		if err != nil {
+			auditFailure(ctx, "semantic_search", "semantic_search_embedding_failed", user, in.Q, err)
+			log.Debug().Str("tool", "semantic_search").Err(err).Msg("embedding failed")
+			return nil, semanticSearchOutput{}, fmt.Errorf("embed query: %w", err)
+		}
+		vectors[c.dims] = vectorLiteral(v)
+	}
+	searches, notes := semanticSearchSQL(cols, metric, vectors, limit)
+	var settings map[string]string
+	if limit > hnswDefaultEFSearch {
+		settings = map[string]string{"hnsw.ef_search": strconv.Itoa(limit)}
+	}
+	log.Debug().Str("tool", "semantic_search").Int("tables", len(searches)).Int("columns", len(cols)).Str("metric", metric).Msg("generated sql")
+
+	release, err := s.admit(ctx, req)
+	if err != nil {
+		auditFailure(ctx, "semantic_search", "semantic_search_rejected", user, in.Q, err)
+		log.Debug().Str("tool", "semantic_search").Err(err).Msg("not admitted")
+		return nil, semanticSearchOutput{}, err
+	}
+	defer release()
+
+	run, err := s.runSearch(ctx, req, searches, settings, limit)
+	if err != nil {
+		auditFailure(ctx, "semantic_search", "semantic_search_query_failed", user, in.Q, err)
+		log.Debug().Str("tool", "semantic_search").Err(err).Dur("dur", time.Since(start)).Msg("query failed")
+		return nil, semanticSearchOutput{}, err
+	}
+	cached := run.cachedAt != nil
+	auditLogVia(run.replica, "semantic_search_success", user, in.Q, fmt.Sprintf("returned %d rows from %d tables%s", len(run.rows), run.searched, cachedNote(cached)), true)
+	log.Debug().Str("tool", "semantic_search").Int("row_count", len(run.rows)).Int("tables", run.searched).
+		Int("skipped", len(run.skipped)).Int("timed_out", len(run.timedOut)).Int("failed", len(run.failed)).
+		Str("replica", run.replica).Bool("cached", cached).Dur("dur", time.Since(start)).Msg("done")
+	embeddingModel := cmp.Or(s.cfg.EmbeddingModel, defaultEmbeddingModel)
+	if s.cfg.EmbeddingProvider == embedProviderStub {
+		embeddingModel = embedProviderStub
+	}
+	return nil, semanticSearchOutput{
+		searchOutput: searchOutput{
+			Queries: run.queries, Columns: run.columns, Rows: run.rows, Replica: run.replica,
+			Cached: cached, CachedAt: run.cachedAt, Notes: notes,
+			TablesSearched: run.searched, TablesSkipped: run.skipped, TablesTimedOut: run.timedOut, TablesFailed: run.failed,
+		},
+		Metric:         metric,
+		EmbeddingModel: embeddingModel,
+	}, nil
+}
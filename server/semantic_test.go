@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestStubEmbedder(t *testing.T) {
+	ctx := context.Background()
+	e := stubEmbedder{}
+	a, err := e.embed(ctx, "Noise-cancelling headphones", 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 64 {
+		t.Fatalf("got %d dimensions", len(a))
+	}
+	var norm float64
+	for _, f := range a {
+		norm += float64(f * f)
+	}
+	if math.Abs(norm-1) > 1e-6 {
+		t.Fatalf("embedding not normalized: |v|^2 = %v", norm)
+	}
+	again, _ := e.embed(ctx, "noise cancelling HEADPHONES", 64)
+	if vectorLiteral(again) != vectorLiteral(a) {
+		t.Fatal("stub embeddings should ignore case and punctuation")
+	}
+	if v, _ := e.embed(ctx, "x", 0); len(v) != defaultStubDims {
+		t.Fatalf("unsized columns get %d dimensions, want %d", len(v), defaultStubDims)
+	}
+	if _, err := e.embed(ctx, "?!", 8); err == nil {
+		t.Fatal("expected an error for text without words")
+	}
+}
+
+func TestVectorLiteral(t *testing.T) {
+	if got := vectorLiteral([]float32{0.5, 1, -2, 0.1}); got != "[0.5,1,-2,0.1]" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func vectorTestModel() *SchemaModel {
+	m := testSchemaModel()
+	m.Tables = append(m.Tables,
+		&Table{
+			Schema: "public", Name: "products",
+			Columns: []Column{
+				{Name: "id", Type: "integer", BaseType: "int4", Position: 1},
+				{Name: "name", Type: "text", BaseType: "text", Position: 2},
+				{Name: "embedding", Type: "vector(3)", BaseType: "vector", Position: 3},
+				{Name: "image_embedding", Type: "halfvec(2)", BaseType: "halfvec", Position: 4},
+			},
+			PrimaryKey: &Constraint{Name: "products_pkey", Columns: []string{"id"}},
+			Indexes: []Index{{Name: "products_embedding_idx", Method: "hnsw", Keys: []string{"embedding"},
+				Definition: "CREATE INDEX products_embedding_idx ON public.products USING hnsw (embedding vector_l2_ops)"}},
+		},
+		&Table{
+			Schema: "public", Name: "docs",
+			Columns: []Column{{Name: "body_vec", Type: "vector", BaseType: "vector", Position: 1}},
+		},
+	)
+	return m
+}
+
+func TestVectorColumns(t *testing.T) {
+	cols := vectorColumns(vectorTestModel(), nil)
+	if len(cols) != 3 {
+		t.Fatalf("got %d vector columns", len(cols))
+	}
+	if c := cols[0]; c.column != "embedding" || c.typ != "vector" || c.dims != 3 || c.metric != metricL2 {
+		t.Fatalf("cols[0] = %+v", c)
+	}
+	if c := cols[1]; c.typ != "halfvec" || c.dims != 2 || c.metric != "" {
+		t.Fatalf("cols[1] = %+v", c)
+	}
+	if c := cols[2]; c.dims != 0 {
+		t.Fatalf("cols[2] = %+v", c)
+	}
+	// The only index decides the default, so it can be used
+	if got := defaultMetric(cols); got != metricL2 {
+		t.Fatalf("defaultMetric = %s", got)
+	}
+	if got := defaultMetric(cols[1:]); got != metricCosine {
+		t.Fatalf("defaultMetric without indexes = %s", got)
+	}
+
+	f, _ := newSearchFilter(searchScope{Columns: []string{"products.embedding"}})
+	if cols := vectorColumns(vectorTestModel(), f); len(cols) != 1 || cols[0].column != "embedding" {
+		t.Fatalf("filtered columns = %+v", cols)
+	}
+}
+
+func TestSemanticSearchSQL(t *testing.T) {
+	cols := vectorColumns(vectorTestModel(), nil)
+	vectors := map[int]string{3: "[1,0,0]", 2: "[0,1]", 0: "[0.5,0.5]"}
+	searches, notes := semanticSearchSQL(cols, metricL2, vectors, 10)
+	if len(searches) != 2 || searches[0].label() != "public.products" || searches[1].label() != "public.docs" {
+		t.Fatalf("searches = %+v", searches)
+	}
+	sql := searches[0].sql
+	for _, want := range []string{
+		`(SELECT $1::text AS source_table, $5::text AS column, "embedding" <-> CAST($4::text AS vector) AS distance, to_jsonb(r.*) - $3::text[] AS row, jsonb_build_object($2::text, CAST("id" AS text)) AS row_key FROM "public"."products" r WHERE "embedding" IS NOT NULL ORDER BY distance LIMIT 10)`,
+		`"image_embedding" <-> CAST($6::text AS halfvec) AS distance`,
+		`) u ORDER BY distance LIMIT 10`,
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("sql missing %q:\n%s", want, sql)
+		}
+	}
+	args := searches[0].args
+	if args[2].([]string)[1] != "image_embedding" || args[3] != "[1,0,0]" || args[5] != "[0,1]" {
+		t.Fatalf("args = %v", args)
+	}
+	if shown := searches[0].shown; shown[3] != "<embedding of q, 3 dimensions>" || shown[5] != "<embedding of q, 2 dimensions>" || shown[4] != "embedding" {
+		t.Fatalf("shown = %v", shown)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "2 of 3 vector columns") || strings.Contains(notes[0], "products.embedding,") {
+		t.Fatalf("notes = %q", notes)
+	}
+
+	searches, _ = semanticSearchSQL(cols, metricCosine, vectors, 10)
+	if !strings.Contains(searches[1].sql, `"body_vec" <=> CAST($3::text AS vector)`) {
+		t.Fatalf("cosine sql:\n%s", searches[1].sql)
+	}
+}
+
+func TestSearchScoreDistance(t *testing.T) {
+	near, far := map[string]any{"distance": 0.1}, map[string]any{"distance": 0.7}
+	if searchScore(near) <= searchScore(far) || searchScore(map[string]any{"distance": "NaN"}) != math.Inf(-1) {
+		t.Fatal("nearer rows should rank first")
+	}
+}
@@ -0,0 +1,87 @@
+// server/stats.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// poolStats is one connection pool in /stats.
+type poolStats struct {
+	Name                 string `json:"name"`
+	TotalConns           int32  `json:"total_conns"`
+	IdleConns            int32  `json:"idle_conns"`
+	AcquiredConns        int32  `json:"acquired_conns"`
+	MaxConns             int32  `json:"max_conns"`
+	AcquireCount         int64  `json:"acquire_count"`
+	EmptyAcquireCount    int64  `json:"empty_acquire_count"` // acquires that had to wait for a connection
+	CanceledAcquireCount int64  `json:"canceled_acquire_count"`
+	AcquireDurationMS    int64  `json:"acquire_duration_ms"` // total time spent waiting in Acquire
+
+	// Replicas only
+	Healthy *bool  `json:"healthy,omitempty"`
+	LagMS   *int64 `json:"lag_ms,omitempty"` // unset if the last check failed
+}
+
+type serverStats struct {
+	Pools         []poolStats       `json:"pools"`
+	Admission     admissionStats    `json:"admission"`
+	ResultHandles int               `json:"result_handles"`
+	ResultCache   *resultCacheStats `json:"result_cache,omitempty"` // unset when the cache is disabled
+	Jobs          jobStats          `json:"jobs"`
+}
+
+func newPoolStats(name string, p *pgxpool.Pool) poolStats {
+	st := p.Stat()
+	return poolStats{
+		Name:                 name,
+		TotalConns:           st.TotalConns(),
+		IdleConns:            st.IdleConns(),
+		AcquiredConns:        st.AcquiredConns(),
+		MaxConns:             st.MaxConns(),
+		AcquireCount:         st.AcquireCount(),
+		EmptyAcquireCount:    st.EmptyAcquireCount(),
+		CanceledAcquireCount: st.CanceledAcquireCount(),
+		AcquireDurationMS:    st.AcquireDuration().Milliseconds(),
+	}
+}
+
+func (s *Server) stats() serverStats {
+	out := serverStats{Pools: []poolStats{newPoolStats(primaryName, s.db)}}
+	if s.replicas != nil {
+		for _, r := range s.replicas.replicas {
+			ps := newPoolStats(r.name, r.pool)
+			r.mu.Lock()
+			healthy := r.healthy
+			ps.Healthy = &healthy
+			if r.lag != replicaLagUnknown {
+				lag := r.lag.Milliseconds()
+				ps.LagMS = &lag
+			}
+			r.mu.Unlock()
+			out.Pools = append(out.Pools, ps)
+		}
+	}
+	if s.admission != nil {
+		out.Admission = s.admission.stats()
+	}
+	if s.resultCache != nil {
+		st := s.resultCache.stats()
+		out.ResultCache = &st
+	}
+	if s.jobs != nil {
+		out.Jobs = s.jobs.stats()
+	}
+	s.results.mu.Lock()
+	out.ResultHandles = len(s.results.handles)
+	s.results.mu.Unlock()
+	return out
+}
+
+// handleStats serves pool, admission, result handle, result cache and job statistics as JSON.
+func (s *Server) handleStats(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.stats())
+}